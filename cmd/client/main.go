@@ -2,25 +2,45 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/sds"
+	"github.com/mjlshen/spiffe_fog/pkg/spire"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
+	"github.com/mjlshen/spiffe_fog/pkg/workload"
 	"github.com/mjlshen/spiffe_fog/proto/agent"
+	agentv1 "github.com/mjlshen/spiffe_fog/proto/spire/api/server/agent/v1"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
 )
 
 const (
 	defaultSpiffeId string = "demo"
 	defaultHost     string = "localhost:8080"
+
+	renewRetryInterval = 30 * time.Second
 )
 
-func NewConn(host string, ins bool) (*grpc.ClientConn, error) {
-	var opts []grpc.DialOption
+func NewConn(host string, ins bool, pin client.Pin) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
 	if host != "" {
 		opts = append(opts, grpc.WithAuthority(host))
 	}
@@ -28,39 +48,260 @@ func NewConn(host string, ins bool) (*grpc.ClientConn, error) {
 	if ins {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	} else {
-		systemRoots, err := x509.SystemCertPool()
+		tlsConfig, err := pin.TLSConfig()
 		if err != nil {
 			return nil, err
 		}
-		cred := credentials.NewTLS(&tls.Config{
-			RootCAs: systemRoots,
-		})
-		opts = append(opts, grpc.WithTransportCredentials(cred))
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	}
 
 	return grpc.Dial(host, opts...)
 }
 
+// attester runs attestations over conn, using SPIRE's Agent API if spireAPI is set
+type attester struct {
+	conn     *grpc.ClientConn
+	spireAPI bool
+
+	// bundle is trusted for the server, it stands in for the bundle SPIRE servers do not return
+	bundle []*x509.Certificate
+}
+
+// attestation is the result of an attestation along with the certificates the server presented,
+// which are empty over an insecure connection
+type attestation struct {
+	*client.Result
+	serverChain []*x509.Certificate
+}
+
+// attest runs a single attestation, tracing it from the agent's perspective
+func (a attester) attest(id string, opts ...client.Option) (_ attestation, err error) {
+	ctx, span := otel.Tracer("github.com/mjlshen/spiffe_fog/cmd/client").Start(context.Background(), "Attest")
+	defer func() { telemetry.End(span, err) }()
+
+	var (
+		agentClient agent.Agent_AttestAgentClient
+		p           peer.Peer
+	)
+	if a.spireAPI {
+		stream, err := agentv1.NewAgentClient(a.conn).AttestAgent(ctx, grpc.Peer(&p))
+		if err != nil {
+			return attestation{}, err
+		}
+		agentClient = spire.NewAttestAgentClient(stream)
+	} else {
+		agentClient, err = agent.NewAgentClient(a.conn).AttestAgent(ctx, grpc.Peer(&p))
+		if err != nil {
+			return attestation{}, err
+		}
+	}
+
+	result, err := client.New(agentClient, id, opts...).Attest(ctx)
+	if err != nil {
+		return attestation{}, err
+	}
+	if len(result.Bundle) == 0 {
+		result.Bundle = a.bundle
+	}
+
+	att := attestation{Result: result}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		att.serverChain = info.State.PeerCertificates
+	}
+	return att, nil
+}
+
+// rotate re-attests whenever half of the lifetime of the current X509-SVID has passed and
+// hands every new result to save. It only returns once the X509-SVID expired without being renewed.
+func rotate(a attester, id string, current attestation, save func(attestation) error, opts ...client.Option) error {
+	for {
+		expiresAt := current.SVID.Certificates[0].NotAfter
+		time.Sleep(time.Until(current.RenewAt()))
+
+		next, err := a.attest(id, opts...)
+		for err != nil {
+			if time.Now().After(expiresAt) {
+				return fmt.Errorf("X509-SVID expired without being renewed: %v", err)
+			}
+			slog.Error("failed to renew X509-SVID", "error", err, "retry_in", renewRetryInterval)
+			time.Sleep(renewRetryInterval)
+			next, err = a.attest(id, opts...)
+		}
+
+		if err := save(next); err != nil {
+			slog.Error("failed to save renewed X509-SVID", "error", err)
+		}
+		current.Close()
+		current = next
+	}
+}
+
 func main() {
 	id := flag.String("id", defaultSpiffeId, "The SPIFFE ID to request validation for")
+	trustDomain := flag.String("trust-domain", client.DefaultTrustDomain, "The trust domain of the server, which the SPIFFE ID is requested in")
 	host := flag.String("host", defaultHost, "The host in the form domain:port to the SPIFFE Fog server")
 	ins := flag.Bool("insecure", false, "Use an insecure gRPC connection")
+	caBundle := flag.String("ca-bundle", "", "PEM file of CAs to trust for the server instead of the system roots")
+	bundlePath := flag.String("bundle-path", "", "Where to save the trust bundle received after attesting if it anchors the server's certificate, it is trusted along with -ca-bundle for later connections")
+	serverID := flag.String("server-id", "", "SPIFFE ID the server must present, verified against -ca-bundle or the saved trust bundle")
+	serverKeySHA256 := flag.String("server-pubkey-sha256", "", "SHA-256 fingerprint of the server's public key in hex or base64")
+	akPath := flag.String("ak-path", "", "Where to persist the AK so that later attestations reuse it instead of creating a new one")
+	devidCert := flag.String("devid-cert", "", "PEM file of the DevID certificate followed by its intermediates, to attest with tpm_devid instead of a registered EK")
+	devidHandle := flag.Uint("devid-handle", uint(common.DefaultIDevIDHandle), "Persistent handle of the DevID key in the TPM")
+	tpmKey := flag.Bool("tpm-key", false, "Create the X509-SVID key in the TPM so that it cannot be exported, it cannot be combined with -socket or -sds-socket")
+	outputDir := flag.String("output-dir", "", "Directory to write the X509-SVID, its key and the trust bundle to, the agent keeps renewing them if set")
+	outputFormats := flag.String("output-formats", client.FormatPEM, "Comma separated formats to write to -output-dir: pem, pkcs12 or json")
+	pkcs12Password := flag.String("pkcs12-password", "", "Password encrypting the PKCS#12 files written to -output-dir")
+	rotateHook := flag.String("rotate-hook", "", "Shell command run in -output-dir after the files were written, e.g. to reload a service")
+	socket := flag.String("socket", "", "Path of a unix socket to serve the Workload API on after attesting, the agent exits after attesting if empty")
+	spireAPI := flag.Bool("spire-api", false, "Attest with the AttestAgent method of SPIRE's Agent API, e.g. against a SPIRE server with a tpm_activation node attestor")
+	sdsSocket := flag.String("sds-socket", "", "Path of a unix socket to serve Envoy's Secret Discovery Service on after attesting")
+	sdsAgentSelectors := flag.String("sds-agent-selectors", "", "Comma separated selectors, e.g. unix:uid:101, that a caller of -sds-socket must have to receive the agent X509-SVID and its key, it is not served if empty")
+	traceExporter := flag.String("trace-exporter", telemetry.ExporterNone, "Where to export traces: none, otlp or stdout")
+	logLevel := flag.String("log-level", "info", "Minimum level of logs: debug, info, warn or error")
+	logFormat := flag.String("log-format", telemetry.LogFormatText, "Format of logs: text or json")
 	flag.Parse()
 
-	log.Printf("Requesting SPIFFE ID: %s, host: %s, insecure: %v", *id, *host, *ins)
-	conn, err := NewConn(*host, *ins)
+	level, err := telemetry.ParseLevel(*logLevel)
+	if err != nil {
+		panic(err)
+	}
+	logger, err := telemetry.NewLogger(os.Stderr, level, *logFormat)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	shutdown, err := telemetry.Setup(context.Background(), "spiffe_fog_agent", *traceExporter)
+	if err != nil {
+		panic(err)
+	}
+	// Renewals keep producing spans, so they are flushed when the agent exits
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	if *tpmKey && *spireAPI {
+		panic("the SPIRE Agent API cannot carry the certification of TPM-resident keys")
+	}
+	if *tpmKey && (*socket != "" || *sdsSocket != "") {
+		panic("TPM-resident keys cannot be handed to workloads over the Workload API or SDS")
+	}
+	var agentSelectors []registry.Selector
+	if *sdsAgentSelectors != "" {
+		for _, raw := range strings.Split(*sdsAgentSelectors, ",") {
+			selector, err := registry.ParseSelector(raw)
+			if err != nil {
+				panic(err)
+			}
+			agentSelectors = append(agentSelectors, selector)
+		}
+	}
+	output := client.Output{
+		Dir:            *outputDir,
+		Formats:        strings.Split(*outputFormats, ","),
+		PKCS12Password: *pkcs12Password,
+		Hook:           *rotateHook,
+	}
+	if err := client.ValidateFormats(output.Formats); err != nil {
+		panic(err)
+	}
+	if *tpmKey && *outputDir != "" && slices.Contains(output.Formats, client.FormatPKCS12) {
+		panic("PKCS#12 requires an exportable key, it cannot be written for -tpm-key")
+	}
+
+	if _, err := common.ParseSPIFFEID("spiffe://" + *trustDomain + "/" + *id); err != nil {
+		panic(err)
+	}
+	slog.Info("requesting SPIFFE ID", "id", *id, "trust_domain", *trustDomain, "host", *host, "insecure", *ins)
+	pin, err := client.LoadPin(*caBundle, *bundlePath, *serverID, *serverKeySHA256)
+	if err != nil {
+		panic(err)
+	}
+	pin.SVIDDir = *outputDir
+	conn, err := NewConn(*host, *ins, pin)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
 
-	agentClient, err := agent.NewAgentClient(conn).AttestAgent(context.TODO())
+	opts := []client.Option{client.WithTrustDomain(*trustDomain)}
+	if *akPath != "" {
+		opts = append(opts, client.WithAKPath(*akPath))
+	}
+	if *tpmKey {
+		opts = append(opts, client.WithTPMKey())
+	}
+	if *devidCert != "" {
+		chain, err := common.LoadBundle(*devidCert)
+		if err != nil {
+			panic(fmt.Errorf("failed to load DevID certificate: %v", err))
+		}
+		opts = append(opts, client.WithDevID(client.DevID{Certificates: chain, Handle: uint32(*devidHandle)}))
+	}
+	a := attester{conn: conn, spireAPI: *spireAPI, bundle: pin.Bundle}
+	result, err := a.attest(*id, opts...)
 	if err != nil {
 		panic(err)
 	}
+	defer result.Close()
+
+	cache := workload.NewCache()
+	save := func(result attestation) error {
+		// A bundle that does not anchor the server's chain would fail the next connection
+		if *bundlePath != "" {
+			if err := client.VerifyServerChain(result.Bundle, result.serverChain); err != nil {
+				slog.Warn("not saving a trust bundle that does not anchor the server's certificate", "path", *bundlePath, "error", err)
+			} else if err := client.SaveBundle(*bundlePath, result.Bundle); err != nil {
+				return err
+			} else {
+				slog.Info("saved trust bundle", "path", *bundlePath)
+			}
+		}
+		if *outputDir != "" {
+			if err := output.Write(context.Background(), result.Result); err != nil {
+				return err
+			}
+			slog.Info("wrote X509-SVID", "dir", *outputDir, "expires_at", result.SVID.Certificates[0].NotAfter)
+		}
+		cache.Update(result.Result)
+		return nil
+	}
+	if err := save(result); err != nil {
+		panic(err)
+	}
+
+	if *socket == "" && *sdsSocket == "" && *outputDir == "" {
+		return
+	}
+
+	errs := make(chan error, 3)
+	go func() {
+		errs <- rotate(a, *id, result, save, opts...)
+	}()
+	if *socket != "" {
+		slog.Info("serving the Workload API", "socket", *socket)
+		srv := workload.NewServer(cache, workload.UnixAttestor{}, workload.CgroupAttestor{})
+		go func() {
+			errs <- workload.ListenAndServe(*socket, srv)
+		}()
+	}
+	if *sdsSocket != "" {
+		slog.Info("serving the Secret Discovery Service", "socket", *sdsSocket)
+		srv := sds.NewServer(cache, agentSelectors, workload.UnixAttestor{}, workload.CgroupAttestor{})
+		go func() {
+			errs <- sds.ListenAndServe(*sdsSocket, srv)
+		}()
+	}
 
-	agent := client.New(agentClient, *id)
-	if err := agent.Attest(); err != nil {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
 		panic(err)
+	case sig := <-term:
+		slog.Info("shutting down", "signal", sig.String())
 	}
 }
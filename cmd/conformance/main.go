@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/conformance"
+)
+
+func main() {
+	spiffeID := flag.String("spiffe-id", "spiffe://spiffe_fog/demo", "SPIFFE ID the agent under test requests")
+	addr := flag.String("addr", "127.0.0.1:8080", "Address the reference server listens on")
+	agentCmd := flag.String("agent-cmd", "", "Shell command that runs a single attestation, {addr} is replaced with the server address. If empty, start the agent by hand for every scenario")
+	stepTimeout := flag.Duration("step-timeout", 30*time.Second, "Step timeout of the reference server")
+	waitTimeout := flag.Duration("wait-timeout", 2*time.Minute, "How long to wait for the agent in each scenario")
+	record := flag.String("record", "", "Directory to save the transcript of every scenario to")
+	transcripts := flag.String("transcripts", "", "Comma separated transcripts to evaluate instead of running an agent")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	var report *conformance.Report
+	var err error
+	if *transcripts != "" {
+		report, err = evaluate(strings.Split(*transcripts, ","), *stepTimeout)
+	} else {
+		suite := &conformance.Suite{
+			SPIFFEID:    *spiffeID,
+			Addr:        *addr,
+			StepTimeout: *stepTimeout,
+			WaitTimeout: *waitTimeout,
+			RecordDir:   *record,
+			Logger:      logger,
+		}
+		if *agentCmd != "" {
+			suite.RunAgent = func(ctx context.Context, addr string) error {
+				cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(*agentCmd, "{addr}", addr))
+				cmd.Stdout = os.Stderr
+				cmd.Stderr = os.Stderr
+				return cmd.Run()
+			}
+		}
+		report, err = suite.Run(context.Background())
+	}
+	if err != nil {
+		logger.Error("conformance run failed", "error", err)
+		os.Exit(2)
+	}
+
+	if err := report.Write(os.Stdout); err != nil {
+		logger.Error("failed to write report", "error", err)
+		os.Exit(2)
+	}
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
+
+// evaluate checks recorded transcripts against the scenarios they were recorded in
+func evaluate(paths []string, stepTimeout time.Duration) (*conformance.Report, error) {
+	report := &conformance.Report{}
+	for _, path := range paths {
+		t, err := conformance.LoadTranscript(path)
+		if err != nil {
+			return nil, err
+		}
+		sc, ok := conformance.ScenarioByName(t.Scenario)
+		if !ok {
+			return nil, fmt.Errorf("%s was recorded in unknown scenario %q", path, t.Scenario)
+		}
+		report.Add(sc, t, stepTimeout)
+	}
+	return report, nil
+}
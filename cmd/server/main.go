@@ -1,30 +1,318 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/mjlshen/spiffe_fog/pkg/config"
+	"github.com/mjlshen/spiffe_fog/pkg/federation"
 	"github.com/mjlshen/spiffe_fog/pkg/server"
+	"github.com/mjlshen/spiffe_fog/pkg/spire"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
 	"github.com/mjlshen/spiffe_fog/proto/agent"
+	agentv1 "github.com/mjlshen/spiffe_fog/proto/spire/api/server/agent/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-const defaultPort string = "8080"
+// healthCheckInterval is how often the CA and registration store are checked
+const healthCheckInterval = 10 * time.Second
 
 func main() {
-	port := flag.String("port", defaultPort, "Port to listen on")
+	configPath := flag.String("config", "", "Path to a YAML configuration file, defaults are used if empty")
+	port := flag.String("port", "", "Port to listen on, overrides listen.grpc")
 	flag.Parse()
 
-	listener, err := net.Listen("tcp", ":"+*port)
+	if err := run(*configPath, *port); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, port string) error {
+	cfg, err := loadConfig(configPath, port)
+	if err != nil {
+		return err
+	}
+
+	level := new(slog.LevelVar)
+	l, err := telemetry.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+
+	logger, err := telemetry.NewLogger(os.Stderr, level, cfg.Logging.Format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	shutdown, err := telemetry.Setup(context.Background(), "spiffe_fog_server", cfg.Tracing.Exporter)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	authority, err := cfg.NewCA()
+	if err != nil {
+		return err
+	}
+
+	svcConfig, err := cfg.Server()
+	if err != nil {
+		return err
+	}
+	svcConfig.CA = authority
+	svcConfig.AKStore, err = cfg.NewAKStore()
+	if err != nil {
+		return err
+	}
+	svcConfig.Registerer = prometheus.DefaultRegisterer
+	svcConfig.Logger = logger
+	fetcher, err := cfg.NewFederation(logger)
+	if err != nil {
+		return err
+	}
+	if fetcher != nil {
+		svcConfig.FederatedBundles = fetcher
+	}
+
+	svc, err := server.New(svcConfig)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	s := grpc.NewServer()
+	go reloadOnSIGHUP(configPath, port, cfg, svc, level)
+
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+	if cfg.TLS.Enabled() {
+		tlsConfig, err := svc.TLSConfig(cfg.TLS.Server())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s := grpc.NewServer(opts...)
 	reflection.Register(s)
-	agent.RegisterAgentServer(s, &server.Service{})
-	if err := s.Serve(listener); err != nil {
-		panic(err)
+	agent.RegisterAgentServer(s, svc)
+	if cfg.Listen.SPIREAPI {
+		agentv1.RegisterAgentServer(s, spire.NewAgentServer(svc))
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go svc.WatchHealth(ctx, healthServer, healthCheckInterval)
+	if fetcher != nil {
+		go fetcher.Run(ctx)
+	}
+
+	httpServers, err := serveHTTP(cfg.Listen, healthServer)
+	if err != nil {
+		return err
+	}
+	if cfg.Federation.BundleEndpoint.Listen != "" {
+		srv, err := serveBundleEndpoint(cfg.Federation.BundleEndpoint, svc, logger)
+		if err != nil {
+			return err
+		}
+		httpServers = append(httpServers, srv)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Listen.GRPC)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(listener)
+	}()
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-term:
+		slog.Info("shutting down", "signal", sig.String(), "drain_timeout", cfg.Shutdown.DrainTimeout)
+	}
+
+	// Fail readiness first so that load balancers stop sending new attestations
+	stop()
+	healthServer.Shutdown()
+
+	drained := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(cfg.Shutdown.DrainTimeout):
+		slog.Warn("attestations did not finish before the drain timeout, cancelling them")
+		s.Stop()
+	}
+
+	for _, srv := range httpServers {
+		if err := srv.Close(); err != nil {
+			slog.Error("failed to stop HTTP server", "addr", srv.Addr, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// serveHTTP exposes metrics, liveness and readiness on their configured addresses, sharing a
+// server if they are the same
+func serveHTTP(listen config.Listen, hs *health.Server) ([]*http.Server, error) {
+	muxes := map[string]*http.ServeMux{}
+	mux := func(addr string) *http.ServeMux {
+		if _, ok := muxes[addr]; !ok {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	if listen.Metrics != "" {
+		mux(listen.Metrics).Handle("/metrics", promhttp.Handler())
+	}
+	if listen.Health != "" {
+		// The process is alive as long as it can answer
+		mux(listen.Health).HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux(listen.Health).HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			resp, err := hs.Check(r.Context(), &healthpb.HealthCheckRequest{})
+			if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	var servers []*http.Server
+	for addr, m := range muxes {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		srv := &http.Server{Addr: addr, Handler: m, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server failed", "addr", addr, "error", err)
+			}
+		}()
+		servers = append(servers, srv)
+	}
+
+	return servers, nil
+}
+
+// serveBundleEndpoint serves the bundle of the trust domain to federated servers over HTTPS
+func serveBundleEndpoint(endpoint config.BundleEndpoint, svc *server.Service, logger *slog.Logger) (*http.Server, error) {
+	tlsConfig, err := svc.TLSConfig(server.TLSConfig{
+		CertFile:  endpoint.CertFile,
+		KeyFile:   endpoint.KeyFile,
+		Bootstrap: endpoint.Profile == federation.ProfileHTTPSSPIFFE,
+		SPIFFEID:  endpoint.SPIFFEID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bundle endpoint: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", endpoint.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{
+		Addr:              endpoint.Listen,
+		Handler:           federation.Handler(svc.Bundle, endpoint.RefreshHint, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(tls.NewListener(listener, tlsConfig)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("bundle endpoint failed", "addr", endpoint.Listen, "error", err)
+		}
+	}()
+	logger.Info("serving bundle endpoint", "addr", endpoint.Listen, "profile", endpoint.Profile)
+
+	return srv, nil
+}
+
+func loadConfig(path, port string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if port != "" {
+		cfg.Listen.GRPC = ":" + port
+	}
+	return cfg, nil
+}
+
+// reloadOnSIGHUP re-reads the configuration file on SIGHUP and applies the settings that can be
+// changed without a restart. Attestations in progress finish with the settings they started with.
+func reloadOnSIGHUP(path, port string, current *config.Config, svc *server.Service, level *slog.LevelVar) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		next, err := loadConfig(path, port)
+		if err != nil {
+			slog.Error("failed to reload configuration, keeping the current one", "error", err)
+			continue
+		}
+
+		svcConfig, err := next.Server()
+		if err != nil {
+			slog.Error("failed to reload configuration, keeping the current one", "error", err)
+			continue
+		}
+
+		l, err := telemetry.ParseLevel(next.Logging.Level)
+		if err != nil {
+			slog.Error("failed to reload configuration, keeping the current one", "error", err)
+			continue
+		}
+
+		if changed := current.RestartRequired(next); len(changed) > 0 {
+			slog.Warn("some settings only take effect after a restart", "settings", changed)
+		}
+
+		level.Set(l)
+		svc.Reload(svcConfig)
+		current = next
 	}
 }
@@ -0,0 +1,202 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"time"
+)
+
+// CA signs X509-SVIDs for a single trust domain
+type CA struct {
+	trustDomain string
+	cert        *x509.Certificate
+	key         crypto.Signer
+}
+
+// New creates a CA with a freshly generated, self-signed root for trustDomain that is valid for ttl.
+func New(trustDomain string, ttl time.Duration) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %v", err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:      []string{"US"},
+			Organization: []string{"SPIFFE_FOG"},
+		},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: trustDomain}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(ttl),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to self-sign CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+
+	return &CA{
+		trustDomain: trustDomain,
+		cert:        cert,
+		key:         key,
+	}, nil
+}
+
+// Load reads a CA from PEM encoded certificate and private key files. The trust domain is taken
+// from the spiffe:// URI SAN of the certificate.
+func Load(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded certificate", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+
+	var trustDomain string
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			trustDomain = uri.Host
+			break
+		}
+	}
+	if trustDomain == "" {
+		return nil, fmt.Errorf("%s does not have a spiffe:// URI SAN", certFile)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %v", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded key", keyFile)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %v", err)
+	}
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("%s does not match the public key of %s", keyFile, certFile)
+	}
+
+	return &CA{
+		trustDomain: trustDomain,
+		cert:        cert,
+		key:         key,
+	}, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// TrustDomain returns the name of the trust domain the CA issues SVIDs for
+func (c *CA) TrustDomain() string {
+	return c.trustDomain
+}
+
+// Certificate returns the CA certificate, which makes up the trust bundle
+func (c *CA) Certificate() *x509.Certificate {
+	return c.cert
+}
+
+// SignX509SVID issues an X509-SVID for id certifying pub. The SVID never outlives the CA, and
+// nothing is issued once the CA has expired.
+func (c *CA) SignX509SVID(pub crypto.PublicKey, id *url.URL, ttl time.Duration) (*x509.Certificate, error) {
+	return c.SignServingCertificate(pub, id, nil, ttl)
+}
+
+// SignServingCertificate issues an X509-SVID for id that is also valid for dnsNames, so that
+// clients that are not SPIFFE aware can verify it by hostname.
+func (c *CA) SignServingCertificate(pub crypto.PublicKey, id *url.URL, dnsNames []string, ttl time.Duration) (*x509.Certificate, error) {
+	if id.Scheme != "spiffe" || id.Host != c.trustDomain {
+		return nil, fmt.Errorf("%s is not a member of trust domain %s", id, c.trustDomain)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(c.cert.NotAfter) {
+		return nil, fmt.Errorf("CA expired at %s", c.cert.NotAfter)
+	}
+	notAfter := now.Add(ttl)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:      []string{"US"},
+			Organization: []string{"SPIFFE_FOG"},
+		},
+		URIs:                  []*url.URL{id},
+		DNSNames:              dnsNames,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, pub, c.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign X509-SVID: %v", err)
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
@@ -1,96 +1,729 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
 	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
 	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/mjlshen/spiffe_fog/pkg/client")
+
+// DefaultTrustDomain is the trust domain of the requested SPIFFE ID unless WithTrustDomain is used
+const DefaultTrustDomain = "spiffe_fog"
+
 type Client struct {
 	agent  agent.Agent_AttestAgentClient
 	domain string
+
+	// trustDomain is the trust domain of the server, domain is the SPIFFE ID requested in it
+	trustDomain string
+
+	openTPM func() (*attest.TPM, error)
+
+	// openTPMDevice opens the TPM for the commands go-attestation does not support
+	openTPMDevice func() (io.ReadWriteCloser, error)
+
+	// devid attests with tpm_devid instead of tpm_activation if set
+	devid *DevID
+
+	// akPath persists the AK between attestations if set
+	akPath string
+
+	// tpmKey creates the X509-SVID key in the TPM
+	tpmKey bool
+
+	// payloadVersion overrides the negotiated payload version if set
+	payloadVersion *uint32
 }
 
-func generateSpiffeFogDomain(id string) string {
-	return fmt.Sprintf("spiffe://spiffe_fog/%s", id)
+// Option customizes a Client
+type Option func(*Client)
+
+// WithTPM makes the client use the TPM returned by open, e.g. a simulator, instead of the
+// TPM of the device. The client closes the TPM when it is done with it.
+func WithTPM(open func() (*attest.TPM, error)) Option {
+	return func(c *Client) {
+		c.openTPM = open
+	}
 }
 
-func New(a agent.Agent_AttestAgentClient, id string) Client {
-	return Client{
-		agent:  a,
-		domain: generateSpiffeFogDomain(id),
+// WithTPMDevice makes the client send the TPM commands that go-attestation does not support, such
+// as signing with a DevID, to the TPM returned by open instead of the TPM of the device. It should
+// be the same TPM as the one of WithTPM. The client closes it when it is done with it.
+func WithTPMDevice(open func() (io.ReadWriteCloser, error)) Option {
+	return func(c *Client) {
+		c.openTPMDevice = open
 	}
 }
 
-func (c Client) Attest() error {
-	tpm, err := attest.OpenTPM(&attest.OpenConfig{
+// DevID is an IEEE 802.1AR device identity whose key was provisioned in the TPM by its manufacturer
+type DevID struct {
+	// Certificates is the DevID certificate followed by its intermediates
+	Certificates []*x509.Certificate
+
+	// Handle is the persistent handle of the DevID key, e.g. common.DefaultIDevIDHandle
+	Handle uint32
+}
+
+// WithDevID attests with the tpm_devid attestor, which proves that the DevID key is in the same
+// TPM as the AK instead of relying on the EK being registered. It requires payload version 2.
+func WithDevID(devid DevID) Option {
+	return func(c *Client) {
+		c.devid = &devid
+	}
+}
+
+// WithAKPath persists the AK blob at path and reuses it in later attestations, instead of creating
+// a new AK every time. The blob is encrypted by the TPM and can only be loaded by the TPM that
+// created it, a new AK replaces it if it cannot be loaded.
+func WithAKPath(path string) Option {
+	return func(c *Client) {
+		c.akPath = path
+	}
+}
+
+// WithTPMKey creates the X509-SVID key in the TPM, certified by the AK, instead of in memory. The
+// key cannot be exported, so the TPM stays open until the Result is closed.
+func WithTPMKey() Option {
+	return func(c *Client) {
+		c.tpmKey = true
+	}
+}
+
+// WithTrustDomain requests the SPIFFE ID in trustDomain instead of DefaultTrustDomain. It must be
+// the trust domain of the server.
+func WithTrustDomain(trustDomain string) Option {
+	return func(c *Client) {
+		c.trustDomain = trustDomain
+	}
+}
+
+// WithPayloadVersion makes the client encode its attestation data in version instead of the
+// newest version the server advertises, e.g. to test legacy agents
+func WithPayloadVersion(version uint32) Option {
+	return func(c *Client) {
+		c.payloadVersion = &version
+	}
+}
+
+// openSystemTPM opens the TPM 2.0 of the device
+func openSystemTPM() (*attest.TPM, error) {
+	return attest.OpenTPM(&attest.OpenConfig{
 		TPMVersion: attest.TPMVersion20,
 	})
+}
+
+// openSystemTPMDevice opens the TPM 2.0 of the device for raw commands
+func openSystemTPMDevice() (io.ReadWriteCloser, error) {
+	return tpm2.OpenTPM()
+}
+
+// SVID is an X509-SVID issued to the agent together with its private key
+type SVID struct {
+	ID           string
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+
+	// Selectors a workload must present to be handed the SVID. It is empty for
+	// SVIDs that are only meant for the agent itself.
+	Selectors []registry.Selector
+
+	// tpmResident is set if PrivateKey is in the TPM and cannot be exported
+	tpmResident bool
+}
+
+// Result holds everything the agent received from a successful attestation
+type Result struct {
+	// SVID is the agent X509-SVID for the requested SPIFFE ID
+	SVID *SVID
+
+	// SVIDs has an X509-SVID for every identity the agent is entitled to
+	SVIDs []*SVID
+
+	// Bundle is the set of X.509 authorities of the trust domain
+	Bundle []*x509.Certificate
+
+	// FederatedBundles are the X.509 authorities of the trust domains the server federates
+	// with, by trust domain name
+	FederatedBundles map[string][]*x509.Certificate
+
+	// close releases the TPM holding a TPM-resident X509-SVID key
+	close func() error
+}
+
+// Close releases the TPM if the X509-SVID key is TPM-resident, after which the key cannot be used
+func (r *Result) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	release := r.close
+	r.close = nil
+	return release()
+}
+
+// RenewAt returns when half of the lifetime of the agent X509-SVID has passed
+func (r *Result) RenewAt() time.Time {
+	leaf := r.SVID.Certificates[0]
+	return leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+}
+
+func New(a agent.Agent_AttestAgentClient, id string, opts ...Option) Client {
+	c := Client{
+		agent:         a,
+		trustDomain:   DefaultTrustDomain,
+		openTPM:       openSystemTPM,
+		openTPMDevice: openSystemTPMDevice,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.domain = fmt.Sprintf("spiffe://%s/%s", c.trustDomain, id)
+	return c
+}
+
+// send sends req to the server. If the server already ended the stream, e.g. because a timeout
+// expired, the status it ended the stream with is returned, which gRPC only reports to Recv.
+func (c Client) send(req *agent.AttestAgentRequest, msg string) error {
+	err := c.agent.Send(req)
+	if errors.Is(err, io.EOF) {
+		if _, recvErr := c.agent.Recv(); recvErr != nil && !errors.Is(recvErr, io.EOF) {
+			return recvErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg, err)
+	}
+	return nil
+}
+
+// Attest proves to the server that this device has a TPM it trusts. ctx should be the context of
+// the stream so that spans are part of the same trace as the server's.
+func (c Client) Attest(ctx context.Context) (result *Result, err error) {
+	tpm, err := c.openTPM()
 	if err != nil {
-		log.Fatalf("failed to open TPM: %v", err)
+		return nil, fmt.Errorf("failed to open TPM: %v", err)
 	}
-	defer tpm.Close()
+	// A TPM-resident key needs the TPM for as long as the result is used
+	var tpmKey *attest.Key
+	defer func() {
+		if result != nil && result.close != nil {
+			return
+		}
+		if tpmKey != nil {
+			tpmKey.Close()
+		}
+		tpm.Close()
+	}()
+
+	logger := slog.Default().With("spiffe_id", c.domain)
 
-	ap, akBlob, err := common.GenerateCredentialActivationData(tpm)
+	ap, akBlob, err := c.activationData(ctx, logger, tpm)
 	if err != nil {
-		return fmt.Errorf("failed to generate credential activation data: %v", err)
+		return nil, fmt.Errorf("failed to generate credential activation data: %v", err)
 	}
 
-	apBytes, err := json.Marshal(*ap)
+	svidParams := &agent.AgentX509SVIDParams{}
+	var key crypto.Signer
+	if c.tpmKey {
+		tpmKey, key, svidParams.KeyCertification, err = newTPMKey(ctx, tpm, akBlob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TPM key: %v", err)
+		}
+	} else {
+		key, err = newKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	svidParams.Csr, err = c.newCSR(ctx, key)
 	if err != nil {
-		return fmt.Errorf("failed to marshal activation parameters into json: %v", err)
+		return nil, fmt.Errorf("failed to generate CSR: %v", err)
 	}
 
-	csr, _, err := common.NewCSRTemplate(c.domain)
+	// The server sends its headers as soon as the stream is opened
+	md, err := c.agent.Header()
 	if err != nil {
-		return fmt.Errorf("failed to generate CSR: %v", err)
+		return nil, fmt.Errorf("failed to receive headers: %v", err)
 	}
 
-	c.agent.Send(&agent.AttestAgentRequest{
+	if v := md.Get(common.SessionIDHeader); len(v) > 0 {
+		logger = logger.With("session_id", v[0])
+	}
+
+	version := common.NegotiatePayloadVersion(md.Get(common.PayloadVersionsHeader))
+	if c.payloadVersion != nil {
+		version = *c.payloadVersion
+	}
+
+	data := &agent.AttestationData{
+		Type:           "tpm_activation",
+		PayloadVersion: version,
+	}
+	if c.devid != nil {
+		data.Type = "tpm_devid"
+		data.Payload, err = c.devidData(ctx, tpm, ap, akBlob, version)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data.Payload, err = common.MarshalAttestationData(version, ap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal activation parameters: %v", err)
+		}
+	}
+
+	if err := c.send(&agent.AttestAgentRequest{
 		Step: &agent.AttestAgentRequest_Params_{
 			Params: &agent.AttestAgentRequest_Params{
-				Data: &agent.AttestationData{
-					Type:    "tpm_activation",
-					Payload: apBytes,
-				},
-				Params: &agent.AgentX509SVIDParams{
-					Csr: csr,
-				},
+				Data:   data,
+				Params: svidParams,
 			},
 		}},
+		"failed to send attestation params",
+	); err != nil {
+		return nil, err
+	}
+
+	// Answer challenges until the server is convinced and sends the result
+	var (
+		svidResp *agent.AttestAgentResponse
+		keys     map[string]crypto.Signer
 	)
+	for {
+		resp, err := c.agent.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetResult() != nil {
+			svidResp = resp
+			break
+		}
+		if req := resp.GetCsrRequest(); req != nil {
+			var csrs *agent.CSRs
+			csrs, keys, err = newWorkloadCSRs(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.send(&agent.AttestAgentRequest{
+				Step: &agent.AttestAgentRequest_Csrs{Csrs: csrs},
+			}, "failed to send CSRs"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		answer, err := c.answer(ctx, logger, tpm, akBlob, key, version, resp)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.send(answer, "failed to send challenge response"); err != nil {
+			return nil, err
+		}
+	}
 
-	challengeReq, err := c.agent.Recv()
+	result, err = newResult(svidResp.GetResult(), key, keys)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if tpmKey != nil {
+		result.SVID.tpmResident = true
+		result.close = func() error {
+			tpmKey.Close()
+			return tpm.Close()
+		}
 	}
 
-	challengeBytes := challengeReq.GetChallenge()
-	var challenge attest.EncryptedCredential
-	if err := json.Unmarshal(challengeBytes, &challenge); err != nil {
-		return fmt.Errorf("failed to unmarshal challenge: %v", err)
+	ids := make([]string, 0, len(result.SVIDs))
+	for _, svid := range result.SVIDs {
+		ids = append(ids, svid.ID)
 	}
+	logger.Info("attestation succeeded", "step", "result", "svids", ids, "expires_at", result.SVID.Certificates[0].NotAfter)
+	return result, nil
+}
 
-	decrypted, err := common.SolveCredentialActivationChallenge(tpm, challenge, akBlob)
-	if err != nil {
-		return fmt.Errorf("failed to respond to credential activation challenge: %v", err)
+// answer solves a challenge of the server. Payload versions before common.PayloadVersionTyped only
+// have a single credential activation challenge.
+func (c Client) answer(ctx context.Context, logger *slog.Logger, tpm *attest.TPM, akBlob []byte, key crypto.Signer, version uint32, resp *agent.AttestAgentResponse) (*agent.AttestAgentRequest, error) {
+	if b := resp.GetChallenge(); b != nil {
+		logger.Info("received attestation challenge", "step", "challenge", "payload_version", version)
+
+		challenge, err := common.UnmarshalChallenge(version, b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal challenge: %v", err)
+		}
+
+		decrypted, err := solveCredentialActivationChallenge(ctx, tpm, *challenge, akBlob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to credential activation challenge: %v", err)
+		}
+
+		return &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_ChallengeResponse{
+				ChallengeResponse: decrypted,
+			},
+		}, nil
+	}
+
+	challenge := resp.GetTypedChallenge()
+	if challenge == nil {
+		return nil, fmt.Errorf("expected a challenge or the attestation result")
+	}
+	logger.Info("received attestation challenge", "step", "challenge", "payload_version", version, "round", challenge.Round)
+
+	answer := &agent.ChallengeResponse{Round: challenge.Round}
+	switch kind := challenge.Kind.(type) {
+	case *agent.Challenge_Activation:
+		decrypted, err := solveCredentialActivationChallenge(ctx, tpm, attest.EncryptedCredential{
+			Credential: kind.Activation.Credential,
+			Secret:     kind.Activation.Secret,
+		}, akBlob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to credential activation challenge: %v", err)
+		}
+		answer.Kind = &agent.ChallengeResponse_Activation{
+			Activation: &agent.ActivationResponse{Secret: decrypted},
+		}
+	case *agent.Challenge_Quote:
+		quote, err := quotePCRs(ctx, tpm, akBlob, kind.Quote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to quote challenge: %v", err)
+		}
+		answer.Kind = &agent.ChallengeResponse_Quote{Quote: quote}
+	case *agent.Challenge_Nonce:
+		digest := sha256.Sum256(kind.Nonce.Nonce)
+		sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to nonce challenge: %v", err)
+		}
+		answer.Kind = &agent.ChallengeResponse_Nonce{
+			Nonce: &agent.NonceResponse{Signature: sig},
+		}
+	case *agent.Challenge_Binding:
+		spki, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode CSR public key: %v", err)
+		}
+		quote, err := quotePCRs(ctx, tpm, akBlob, &agent.QuoteChallenge{
+			Nonce:         common.CSRBinding(spki, kind.Binding.Nonce),
+			HashAlgorithm: uint32(attest.HashSHA256),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to binding challenge: %v", err)
+		}
+		answer.Kind = &agent.ChallengeResponse_Binding{Binding: quote}
+	case *agent.Challenge_Devid:
+		if c.devid == nil {
+			return nil, fmt.Errorf("received a DevID challenge without a DevID")
+		}
+		sig, err := c.signWithDevID(ctx, kind.Devid.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to respond to DevID challenge: %v", err)
+		}
+		answer.Kind = &agent.ChallengeResponse_Devid{
+			Devid: &agent.DevIDResponse{Signature: sig},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported challenge in round %d", challenge.Round)
 	}
 
-	c.agent.Send(&agent.AttestAgentRequest{
-		Step: &agent.AttestAgentRequest_ChallengeResponse{
-			ChallengeResponse: decrypted,
+	return &agent.AttestAgentRequest{
+		Step: &agent.AttestAgentRequest_TypedChallengeResponse{
+			TypedChallengeResponse: answer,
 		},
+	}, nil
+}
+
+// activationData returns the attestation data of the persisted AK if it can be loaded, otherwise
+// of a new AK which is persisted for the next attestation
+func (c Client) activationData(ctx context.Context, logger *slog.Logger, tpm *attest.TPM) (*common.AttestationData, []byte, error) {
+	if c.akPath != "" {
+		akBlob, err := os.ReadFile(c.akPath)
+		if err == nil {
+			ap, err := common.LoadCredentialActivationData(tpm, akBlob)
+			if err == nil {
+				logger.Debug("reusing persisted AK", "path", c.akPath)
+				return ap, akBlob, nil
+			}
+			logger.Warn("failed to load persisted AK, creating a new one", "path", c.akPath, "error", err)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("failed to read persisted AK, creating a new one", "path", c.akPath, "error", err)
+		}
+	}
+
+	ap, akBlob, err := generateCredentialActivationData(ctx, tpm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.akPath != "" {
+		if err := writeFileAtomic(c.akPath, akBlob, 0o600); err != nil {
+			logger.Warn("failed to persist AK", "path", c.akPath, "error", err)
+		}
+	}
+	return ap, akBlob, nil
+}
+
+// devidData certifies the DevID key with the AK and encodes the tpm_devid payload
+func (c Client) devidData(ctx context.Context, tpm *attest.TPM, ap *common.AttestationData, akBlob []byte, version uint32) (payload []byte, err error) {
+	_, span := tracer.Start(ctx, "CertifyDevID")
+	defer func() { telemetry.End(span, err) }()
+
+	if version < common.PayloadVersionTyped {
+		return nil, fmt.Errorf("tpm_devid requires payload version %d, got %d", common.PayloadVersionTyped, version)
+	}
+	if len(c.devid.Certificates) == 0 {
+		return nil, errors.New("missing DevID certificate")
+	}
+
+	certification, err := common.CertifyDevID(tpm, akBlob, c.devid.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err = common.MarshalDevIDData(version, &common.DevIDData{
+		AttestationData: *ap,
+		Chain:           c.devid.Certificates,
+		Certification:   certification,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal DevID parameters: %v", err)
+	}
+	return payload, nil
+}
 
-	svidResp, err := c.agent.Recv()
+// signWithDevID signs the SHA256 digest of nonce with the DevID key
+func (c Client) signWithDevID(ctx context.Context, nonce []byte) (sig []byte, err error) {
+	_, span := tracer.Start(ctx, "SignWithDevID")
+	defer func() { telemetry.End(span, err) }()
+
+	rw, err := c.openTPMDevice()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to open TPM: %v", err)
 	}
+	defer rw.Close()
 
-	log.Print(svidResp.GetResult())
-	return nil
+	digest := sha256.Sum256(nonce)
+	return common.SignWithDevID(rw, c.devid.Handle, digest[:])
+}
+
+func generateCredentialActivationData(ctx context.Context, tpm *attest.TPM) (ap *common.AttestationData, akBlob []byte, err error) {
+	_, span := tracer.Start(ctx, "GenerateCredentialActivationData")
+	defer func() { telemetry.End(span, err) }()
+
+	return common.GenerateCredentialActivationData(tpm)
+}
+
+func solveCredentialActivationChallenge(ctx context.Context, tpm *attest.TPM, challenge attest.EncryptedCredential, akBlob []byte) (secret []byte, err error) {
+	_, span := tracer.Start(ctx, "SolveCredentialActivationChallenge")
+	defer func() { telemetry.End(span, err) }()
+
+	return common.SolveCredentialActivationChallenge(tpm, challenge, akBlob)
+}
+
+func quotePCRs(ctx context.Context, tpm *attest.TPM, akBlob []byte, challenge *agent.QuoteChallenge) (resp *agent.QuoteResponse, err error) {
+	_, span := tracer.Start(ctx, "QuotePCRs")
+	defer func() { telemetry.End(span, err) }()
+
+	alg := attest.HashAlg(challenge.HashAlgorithm)
+	if alg != attest.HashSHA1 && alg != attest.HashSHA256 {
+		return nil, fmt.Errorf("unsupported hash algorithm: %#x", challenge.HashAlgorithm)
+	}
+
+	quote, pcrs, err := common.QuotePCRs(tpm, akBlob, challenge.Nonce, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &agent.QuoteResponse{
+		Quote:     quote.Quote,
+		Signature: quote.Signature,
+	}
+	for _, p := range pcrs {
+		resp.Pcrs = append(resp.Pcrs, &agent.PCR{
+			Index:  uint32(p.Index),
+			Digest: p.Digest,
+		})
+	}
+	return resp, nil
+}
+
+// newKey generates the X509-SVID key in memory
+func newKey(ctx context.Context) (key crypto.Signer, err error) {
+	_, span := tracer.Start(ctx, "GenerateKey")
+	defer func() { telemetry.End(span, err) }()
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate X509-SVID key: %v", err)
+	}
+	return key, nil
+}
+
+// newTPMKey creates the X509-SVID key in the TPM, along with its certification by the AK
+func newTPMKey(ctx context.Context, tpm *attest.TPM, akBlob []byte) (tpmKey *attest.Key, key crypto.Signer, cert *agent.KeyCertification, err error) {
+	_, span := tracer.Start(ctx, "CreateTPMKey")
+	defer func() { telemetry.End(span, err) }()
+
+	tpmKey, err = common.NewCertifiedKey(tpm, akBlob)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	priv, err := tpmKey.Private(tpmKey.Public())
+	if err != nil {
+		tpmKey.Close()
+		return nil, nil, nil, err
+	}
+	key, ok := priv.(crypto.Signer)
+	if !ok {
+		tpmKey.Close()
+		return nil, nil, nil, fmt.Errorf("TPM key %T is not a crypto.Signer", priv)
+	}
+
+	params := tpmKey.CertificationParameters()
+	return tpmKey, key, &agent.KeyCertification{
+		Public:            params.Public,
+		CreateData:        params.CreateData,
+		CreateAttestation: params.CreateAttestation,
+		CreateSignature:   params.CreateSignature,
+	}, nil
+}
+
+// newCSR creates a CSR for the requested SPIFFE ID signed by key
+func (c Client) newCSR(ctx context.Context, key crypto.Signer) (csr []byte, err error) {
+	_, span := tracer.Start(ctx, "CreateCSR", trace.WithAttributes(attribute.String("spiffe_fog.spiffe_id", c.domain)))
+	defer func() { telemetry.End(span, err) }()
+
+	return common.NewCSRTemplateWithKey(c.domain, key)
+}
+
+// newWorkloadCSRs creates a key and a CSR for every SPIFFE ID the server asks for, so that no
+// workload is handed the key of the agent X509-SVID
+func newWorkloadCSRs(ctx context.Context, req *agent.CSRRequest) (csrs *agent.CSRs, keys map[string]crypto.Signer, err error) {
+	ctx, span := tracer.Start(ctx, "CreateWorkloadCSRs", trace.WithAttributes(attribute.Int("spiffe_fog.ids", len(req.GetIds()))))
+	defer func() { telemetry.End(span, err) }()
+
+	csrs = &agent.CSRs{}
+	keys = make(map[string]crypto.Signer, len(req.GetIds()))
+	for _, id := range req.GetIds() {
+		key, err := newKey(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		csr, err := common.NewCSRTemplateWithKey(spiffeIDString(id), key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate CSR for %s: %v", spiffeIDString(id), err)
+		}
+		csrs.Csrs = append(csrs.Csrs, csr)
+		keys[spiffeIDString(id)] = key
+	}
+	return csrs, keys, nil
+}
+
+// newResult parses the attestation result, pairing the agent X509-SVID with key and every other
+// X509-SVID with its key in keys
+func newResult(r *agent.AttestAgentResponse_Result, key crypto.Signer, keys map[string]crypto.Signer) (*Result, error) {
+	if r == nil {
+		return nil, fmt.Errorf("missing attestation result")
+	}
+
+	result := &Result{}
+	for _, der := range r.GetBundle() {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bundle: %v", err)
+		}
+		result.Bundle = append(result.Bundle, cert)
+	}
+	for _, fb := range r.GetFederatedBundles() {
+		if result.FederatedBundles == nil {
+			result.FederatedBundles = map[string][]*x509.Certificate{}
+		}
+		for _, der := range fb.GetBundle() {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse federated bundle of %s: %v", fb.GetTrustDomain(), err)
+			}
+			result.FederatedBundles[fb.GetTrustDomain()] = append(result.FederatedBundles[fb.GetTrustDomain()], cert)
+		}
+	}
+
+	selectors := map[string][]registry.Selector{}
+	for _, e := range r.GetEntries() {
+		id := spiffeIDString(e.GetSpiffeId())
+		for _, s := range e.GetSelectors() {
+			selectors[id] = append(selectors[id], registry.Selector{Type: s.GetType(), Value: s.GetValue()})
+		}
+	}
+
+	agentID := spiffeIDString(r.GetSvid().GetId())
+	for _, s := range r.GetSvids() {
+		svidKey := key
+		if id := spiffeIDString(s.GetId()); id != agentID {
+			if svidKey = keys[id]; svidKey == nil {
+				return nil, fmt.Errorf("X509-SVID for %s was not requested", id)
+			}
+		}
+		svid, err := newSVID(s, svidKey)
+		if err != nil {
+			return nil, err
+		}
+		svid.Selectors = selectors[svid.ID]
+		result.SVIDs = append(result.SVIDs, svid)
+
+		if svid.ID == agentID {
+			result.SVID = svid
+		}
+	}
+	if result.SVID == nil {
+		return nil, fmt.Errorf("missing agent X509-SVID")
+	}
+
+	return result, nil
+}
+
+func newSVID(s *agent.X509SVID, key crypto.Signer) (*SVID, error) {
+	svid := &SVID{
+		ID:         spiffeIDString(s.GetId()),
+		PrivateKey: key,
+	}
+	for _, der := range s.GetCertChain() {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse X509-SVID for %s: %v", svid.ID, err)
+		}
+		svid.Certificates = append(svid.Certificates, cert)
+	}
+	if len(svid.Certificates) == 0 {
+		return nil, fmt.Errorf("empty X509-SVID for %s", svid.ID)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(svid.Certificates[0].PublicKey) {
+		return nil, fmt.Errorf("X509-SVID for %s does not certify its key", svid.ID)
+	}
+	return svid, nil
+}
+
+func spiffeIDString(id *agent.SPIFFEID) string {
+	return fmt.Sprintf("spiffe://%s%s", id.GetTrustDomain(), id.GetPath())
 }
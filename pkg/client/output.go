@@ -0,0 +1,210 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Supported output formats
+const (
+	// FormatPEM writes svid.pem with the leaf and its chain, svid_key.pem and bundle.pem
+	FormatPEM = "pem"
+
+	// FormatPKCS12 writes svid.p12 with the key and chain and bundle.p12 as a trust store
+	FormatPKCS12 = "pkcs12"
+
+	// FormatJSON writes svid.json with the PEM encoded X509-SVID, key and bundle
+	FormatJSON = "json"
+)
+
+// Names of the files written by Output
+const (
+	SVIDFileName         = "svid.pem"
+	SVIDKeyFileName      = "svid_key.pem"
+	BundleFileName       = "bundle.pem"
+	SVIDPKCS12FileName   = "svid.p12"
+	BundlePKCS12FileName = "bundle.p12"
+	SVIDJSONFileName     = "svid.json"
+)
+
+// Output writes the agent X509-SVID, its key and the trust bundle to a directory for workloads
+// that read files, e.g. nginx or envoy. Every file is replaced atomically, keys are only
+// readable by the owner.
+type Output struct {
+	// Dir is the directory the files are written to, it is created if needed
+	Dir string
+
+	// Formats is a list of pem, pkcs12 or json, defaults to pem
+	Formats []string
+
+	// PKCS12Password encrypts the PKCS#12 files, it may be empty
+	PKCS12Password string
+
+	// Hook is a shell command run in Dir after every write, e.g. to reload a service
+	Hook string
+}
+
+// ValidateFormats returns an error if any format is not supported
+func ValidateFormats(formats []string) error {
+	for _, f := range formats {
+		switch f {
+		case FormatPEM, FormatPKCS12, FormatJSON:
+		default:
+			return fmt.Errorf("unsupported output format: %s", f)
+		}
+	}
+	return nil
+}
+
+// svidJSON is the layout of svid.json
+type svidJSON struct {
+	SPIFFEID  string    `json:"spiffe_id"`
+	X509SVID  string    `json:"x509_svid"`
+	Key       string    `json:"x509_svid_key,omitempty"`
+	Bundle    string    `json:"bundle"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Write writes the agent X509-SVID of result in every format and runs the hook. TPM-resident
+// keys cannot be exported, so svid_key.pem is removed and svid.json has no key for them, while
+// PKCS#12, which requires the key, fails.
+func (o Output) Write(ctx context.Context, result *Result) error {
+	if err := ValidateFormats(o.Formats); err != nil {
+		return err
+	}
+	formats := o.Formats
+	if len(formats) == 0 {
+		formats = []string{FormatPEM}
+	}
+
+	svid := result.SVID
+	if svid == nil || len(svid.Certificates) == 0 {
+		return fmt.Errorf("result has no X509-SVID")
+	}
+
+	var keyPEM []byte
+	if !svid.tpmResident {
+		der, err := x509.MarshalPKCS8PrivateKey(svid.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to marshal X509-SVID key: %v", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	chainPEM, err := encodeCertificates(svid.Certificates)
+	if err != nil {
+		return err
+	}
+	bundlePEM, err := encodeCertificates(result.Bundle)
+	if err != nil {
+		return err
+	}
+
+	for _, format := range formats {
+		switch format {
+		case FormatPEM:
+			// The key is replaced before the certificates, so until svid.pem is replaced too a
+			// reader loading both files sees the new key with the old certificate and must
+			// retry. Readers that reload when svid.pem changes, like fogtls, never see the
+			// mismatch. A key left by a previous run would never match a TPM-resident key.
+			if keyPEM != nil {
+				if err := o.write(SVIDKeyFileName, keyPEM, 0o600); err != nil {
+					return err
+				}
+			} else if err := o.remove(SVIDKeyFileName); err != nil {
+				return err
+			}
+			if err := o.write(SVIDFileName, chainPEM, 0o644); err != nil {
+				return err
+			}
+			if err := o.write(BundleFileName, bundlePEM, 0o644); err != nil {
+				return err
+			}
+		case FormatPKCS12:
+			if keyPEM == nil {
+				return fmt.Errorf("PKCS#12 requires an exportable key, the X509-SVID key is TPM-resident")
+			}
+			p12, err := pkcs12.Modern.Encode(svid.PrivateKey, svid.Certificates[0], svid.Certificates[1:], o.PKCS12Password)
+			if err != nil {
+				return fmt.Errorf("failed to encode PKCS#12: %v", err)
+			}
+			if err := o.write(SVIDPKCS12FileName, p12, 0o600); err != nil {
+				return err
+			}
+			trustStore, err := pkcs12.Modern.EncodeTrustStore(result.Bundle, o.PKCS12Password)
+			if err != nil {
+				return fmt.Errorf("failed to encode PKCS#12 trust store: %v", err)
+			}
+			if err := o.write(BundlePKCS12FileName, trustStore, 0o644); err != nil {
+				return err
+			}
+		case FormatJSON:
+			b, err := json.MarshalIndent(svidJSON{
+				SPIFFEID:  svid.ID,
+				X509SVID:  string(chainPEM),
+				Key:       string(keyPEM),
+				Bundle:    string(bundlePEM),
+				ExpiresAt: svid.Certificates[0].NotAfter,
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := o.write(SVIDJSONFileName, b, 0o600); err != nil {
+				return err
+			}
+		}
+	}
+
+	return o.runHook(ctx)
+}
+
+func (o Output) write(name string, data []byte, perm os.FileMode) error {
+	path := filepath.Join(o.Dir, name)
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func (o Output) remove(name string) error {
+	path := filepath.Join(o.Dir, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
+func (o Output) runHook(ctx context.Context) error {
+	if o.Hook == "" {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", o.Hook)
+	cmd.Dir = o.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// encodeCertificates encodes certs as concatenated PEM blocks
+func encodeCertificates(certs []*x509.Certificate) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+)
+
+// Pin restricts which attestation servers the agent trusts. The zero value trusts the system roots.
+type Pin struct {
+	// Bundle replaces the system roots, e.g. a CA bundle file, the trust bundle received from a
+	// previous attestation, or both
+	Bundle []*x509.Certificate
+
+	// ServerID is the SPIFFE ID the server must present. The chain is verified against
+	// Bundle without checking the hostname, as SPIFFE IDs replace hostnames.
+	ServerID *url.URL
+
+	// PublicKeySHA256 is the SHA-256 hash of the DER encoded SubjectPublicKeyInfo of the
+	// server's certificate. If it is set without a Bundle, the chain is not verified at all.
+	PublicKeySHA256 []byte
+
+	// SVIDDir is the directory a previous attestation wrote its X509-SVID to. The X509-SVID is
+	// presented to servers that request a client certificate, if it exists and has not expired.
+	SVIDDir string
+}
+
+// TLSConfig returns the TLS configuration that connects to a server satisfying the pin
+func (p Pin) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	var roots *x509.CertPool
+	if len(p.Bundle) > 0 {
+		roots = x509.NewCertPool()
+		for _, cert := range p.Bundle {
+			roots.AddCert(cert)
+		}
+	} else {
+		systemRoots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		roots = systemRoots
+	}
+	cfg.RootCAs = roots
+
+	if p.SVIDDir != "" {
+		cfg.GetClientCertificate = p.clientCertificate
+	}
+
+	if p.ServerID == nil && p.PublicKeySHA256 == nil {
+		return cfg, nil
+	}
+	if p.ServerID != nil && len(p.Bundle) == 0 {
+		return nil, errors.New("pinning a server SPIFFE ID requires a bundle")
+	}
+
+	// Hostname verification is replaced by the SPIFFE ID or public key checks below
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return errors.New("server did not present a certificate")
+		}
+		leaf := certs[0]
+
+		if p.PublicKeySHA256 != nil {
+			sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+			if subtle.ConstantTimeCompare(sum[:], p.PublicKeySHA256) != 1 {
+				return fmt.Errorf("server public key sha256 %s does not match the pinned one", hex.EncodeToString(sum[:]))
+			}
+		}
+
+		if len(p.Bundle) > 0 || p.ServerID != nil {
+			if err := verifyServerChain(roots, certs); err != nil {
+				return err
+			}
+		}
+
+		if p.ServerID != nil {
+			for _, uri := range leaf.URIs {
+				if uri.String() == p.ServerID.String() {
+					return nil
+				}
+			}
+			return fmt.Errorf("server certificate does not have SPIFFE ID %s", p.ServerID)
+		}
+
+		return nil
+	}
+
+	return cfg, nil
+}
+
+// clientCertificate loads the X509-SVID on every handshake, as it is renewed in place. Without a
+// usable one, e.g. before the first attestation or when its key stays in the TPM, no certificate is
+// presented and the server decides whether to continue.
+func (p Pin) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(p.SVIDDir, SVIDFileName), filepath.Join(p.SVIDDir, SVIDKeyFileName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("not presenting the X509-SVID from a previous attestation", "dir", p.SVIDDir, "error", err)
+		}
+		return &tls.Certificate{}, nil
+	}
+	if !time.Now().Before(pair.Leaf.NotAfter) {
+		slog.Warn("not presenting the expired X509-SVID from a previous attestation", "dir", p.SVIDDir)
+		return &tls.Certificate{}, nil
+	}
+	return &pair, nil
+}
+
+// LoadPin builds a Pin from the CAs in caBundle and the trust bundle a previous attestation saved
+// at bundlePath, either of which may be empty. The server is trusted if either anchors its chain, so
+// that a stale saved bundle, e.g. after the CA of the server was replaced, falls back to caBundle.
+func LoadPin(caBundle, bundlePath, serverID, publicKeySHA256 string) (Pin, error) {
+	var pin Pin
+
+	if caBundle != "" {
+		bundle, err := common.LoadBundle(caBundle)
+		if err != nil {
+			return pin, err
+		}
+		pin.Bundle = bundle
+	}
+
+	if bundlePath != "" {
+		bundle, err := common.LoadBundle(bundlePath)
+		switch {
+		case err == nil:
+			slog.Info("trusting the trust bundle from a previous attestation", "path", bundlePath)
+			pin.Bundle = append(pin.Bundle, bundle...)
+		case !errors.Is(err, os.ErrNotExist):
+			return pin, err
+		}
+	}
+
+	if serverID != "" {
+		id, err := common.ParseSPIFFEID(serverID)
+		if err != nil {
+			return pin, err
+		}
+		pin.ServerID = id
+	}
+
+	if publicKeySHA256 != "" {
+		sum, err := ParsePublicKeySHA256(publicKeySHA256)
+		if err != nil {
+			return pin, err
+		}
+		pin.PublicKeySHA256 = sum
+	}
+
+	return pin, nil
+}
+
+// VerifyServerChain checks that bundle anchors chain, the certificates a server presented, so that
+// bundle can be saved for later connections to the server
+func VerifyServerChain(bundle, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	roots := x509.NewCertPool()
+	for _, cert := range bundle {
+		roots.AddCert(cert)
+	}
+	return verifyServerChain(roots, chain)
+}
+
+func verifyServerChain(roots *x509.CertPool, certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return fmt.Errorf("failed to verify server certificate: %v", err)
+	}
+	return nil
+}
+
+// ParsePublicKeySHA256 parses a public key fingerprint encoded as hex, optionally separated by
+// colons, or base64 as used by HPKP
+func ParsePublicKeySHA256(s string) ([]byte, error) {
+	if b, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid sha256 fingerprint %q: expected hex or base64", s)
+}
+
+// SaveBundle writes bundle to path as PEM, replacing the previous file atomically
+func SaveBundle(path string, bundle []*x509.Certificate) error {
+	b, err := encodeCertificates(bundle)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b, 0o644)
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadBundle reads PEM encoded certificates from path
+func LoadBundle(path string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		bundle = append(bundle, cert)
+	}
+	if len(bundle) == 0 {
+		return nil, fmt.Errorf("%s does not contain any PEM encoded certificates", path)
+	}
+
+	return bundle, nil
+}
@@ -0,0 +1,5 @@
+package common
+
+// SessionIDHeader is the gRPC header the server uses to tell the agent the ID
+// of its attestation session, so that logs on both sides can be correlated.
+const SessionIDHeader = "spiffe-fog-session-id"
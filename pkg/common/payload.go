@@ -0,0 +1,290 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/protobuf/proto"
+)
+
+// Payload versions of tpm_activation attestation data and challenges
+const (
+	// PayloadVersionJSON is the legacy encoding/json encoding of AttestationData and
+	// attest.EncryptedCredential
+	PayloadVersionJSON uint32 = 0
+
+	// PayloadVersionProto encodes them as agent.TPMActivationParams and agent.TPMActivationChallenge
+	PayloadVersionProto uint32 = 1
+
+	// PayloadVersionTyped encodes attestation data like PayloadVersionProto, followed by any number
+	// of agent.Challenge rounds instead of a single challenge
+	PayloadVersionTyped uint32 = 2
+)
+
+// PayloadVersions are the payload versions this build understands, newest first
+var PayloadVersions = []uint32{PayloadVersionTyped, PayloadVersionProto, PayloadVersionJSON}
+
+// PayloadVersionsHeader is the header the server advertises the payload versions it accepts in
+const PayloadVersionsHeader = "spiffe-fog-payload-versions"
+
+// FormatPayloadVersions encodes versions for PayloadVersionsHeader
+func FormatPayloadVersions(versions []uint32) string {
+	s := make([]string, 0, len(versions))
+	for _, v := range versions {
+		s = append(s, strconv.FormatUint(uint64(v), 10))
+	}
+	return strings.Join(s, ",")
+}
+
+// NegotiatePayloadVersion returns the newest version in PayloadVersions that the server
+// advertised. Servers that don't advertise any only understand the legacy JSON encoding.
+func NegotiatePayloadVersion(advertised []string) uint32 {
+	supported := map[uint32]bool{}
+	for _, header := range advertised {
+		for _, s := range strings.Split(header, ",") {
+			if v, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32); err == nil {
+				supported[uint32(v)] = true
+			}
+		}
+	}
+
+	for _, v := range PayloadVersions {
+		if supported[v] {
+			return v
+		}
+	}
+	return PayloadVersionJSON
+}
+
+// MarshalAttestationData encodes data as the tpm_activation payload of version
+func MarshalAttestationData(version uint32, data *AttestationData) ([]byte, error) {
+	switch version {
+	case PayloadVersionJSON:
+		return json.Marshal(data)
+	case PayloadVersionProto, PayloadVersionTyped:
+		params, err := activationParams(version, data)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(params)
+	default:
+		return nil, fmt.Errorf("unsupported payload version: %d", version)
+	}
+}
+
+// UnmarshalAttestationData decodes a tpm_activation payload of version. The EK of the result is
+// always PEM encoded, as expected by DecodeEK.
+func UnmarshalAttestationData(version uint32, payload []byte) (*AttestationData, error) {
+	switch version {
+	case PayloadVersionJSON:
+		var data AttestationData
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return nil, err
+		}
+		return &data, nil
+	case PayloadVersionProto, PayloadVersionTyped:
+		var params agent.TPMActivationParams
+		if err := proto.Unmarshal(payload, &params); err != nil {
+			return nil, err
+		}
+		return activationData(version, &params)
+	default:
+		return nil, fmt.Errorf("unsupported payload version: %d", version)
+	}
+}
+
+// DevIDData is the tpm_devid attestation data of an agent, its AttestationData must hold an AK
+type DevIDData struct {
+	AttestationData
+
+	// Chain is the DevID certificate followed by its intermediates
+	Chain []*x509.Certificate
+
+	// Certification is the TPM2_Certify of the DevID key by the AK
+	Certification *attest.CertificationParameters
+}
+
+// MarshalDevIDData encodes data as the tpm_devid payload of version, which only exists from
+// PayloadVersionTyped
+func MarshalDevIDData(version uint32, data *DevIDData) ([]byte, error) {
+	if version < PayloadVersionTyped {
+		return nil, fmt.Errorf("payload version %d does not support tpm_devid", version)
+	}
+	if data.Certification == nil {
+		return nil, fmt.Errorf("missing DevID certification")
+	}
+
+	activation, err := activationParams(version, &data.AttestationData)
+	if err != nil {
+		return nil, err
+	}
+	params := &agent.TPMDevIDParams{
+		Activation: activation,
+		DevidCertification: &agent.KeyCertification{
+			Public:            data.Certification.Public,
+			CreateData:        data.Certification.CreateData,
+			CreateAttestation: data.Certification.CreateAttestation,
+			CreateSignature:   data.Certification.CreateSignature,
+		},
+	}
+	for _, cert := range data.Chain {
+		params.DevidChain = append(params.DevidChain, cert.Raw)
+	}
+	return proto.Marshal(params)
+}
+
+// UnmarshalDevIDData decodes a tpm_devid payload of version
+func UnmarshalDevIDData(version uint32, payload []byte) (*DevIDData, error) {
+	if version < PayloadVersionTyped {
+		return nil, fmt.Errorf("payload version %d does not support tpm_devid", version)
+	}
+
+	var params agent.TPMDevIDParams
+	if err := proto.Unmarshal(payload, &params); err != nil {
+		return nil, err
+	}
+	if params.Activation == nil {
+		return nil, fmt.Errorf("missing activation params")
+	}
+	data, err := activationData(version, params.Activation)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params.DevidChain) == 0 {
+		return nil, fmt.Errorf("missing DevID certificate")
+	}
+	chain := make([]*x509.Certificate, 0, len(params.DevidChain))
+	for _, der := range params.DevidChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DevID certificate: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	certification := params.GetDevidCertification()
+	if certification == nil {
+		return nil, fmt.Errorf("missing DevID certification")
+	}
+
+	return &DevIDData{
+		AttestationData: *data,
+		Chain:           chain,
+		Certification: &attest.CertificationParameters{
+			Public:            certification.Public,
+			CreateData:        certification.CreateData,
+			CreateAttestation: certification.CreateAttestation,
+			CreateSignature:   certification.CreateSignature,
+		},
+	}, nil
+}
+
+// activationParams encodes the EK and AK of data as agent.TPMActivationParams
+func activationParams(version uint32, data *AttestationData) (*agent.TPMActivationParams, error) {
+	if data.AK == nil {
+		return nil, fmt.Errorf("missing AK attestation parameters")
+	}
+	params := &agent.TPMActivationParams{
+		Version:                version,
+		AkPublic:               data.AK.Public,
+		AkCreateData:           data.AK.CreateData,
+		AkCreateAttestation:    data.AK.CreateAttestation,
+		AkCreateSignature:      data.AK.CreateSignature,
+		AkTcsdActivationFormat: data.AK.UseTCSDActivationFormat,
+	}
+
+	block, _ := pem.Decode(data.EK)
+	if block == nil {
+		return nil, fmt.Errorf("EK is not PEM encoded")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		params.Ek = &agent.TPMActivationParams_EkCertificate{EkCertificate: block.Bytes}
+	case "PUBLIC KEY":
+		params.Ek = &agent.TPMActivationParams_EkPublicKey{EkPublicKey: block.Bytes}
+	default:
+		return nil, fmt.Errorf("unsupported EK type: %s", block.Type)
+	}
+	return params, nil
+}
+
+// activationData decodes agent.TPMActivationParams of version, the EK of the result is PEM encoded
+func activationData(version uint32, params *agent.TPMActivationParams) (*AttestationData, error) {
+	if params.Version != version {
+		return nil, fmt.Errorf("payload version %d does not match %d", params.Version, version)
+	}
+
+	var ek []byte
+	switch {
+	case params.GetEkCertificate() != nil:
+		if _, err := x509.ParseCertificate(params.GetEkCertificate()); err != nil {
+			return nil, fmt.Errorf("invalid EK certificate: %v", err)
+		}
+		ek = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: params.GetEkCertificate()})
+	case params.GetEkPublicKey() != nil:
+		ek = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: params.GetEkPublicKey()})
+	default:
+		return nil, fmt.Errorf("missing EK")
+	}
+
+	return &AttestationData{
+		EK: ek,
+		AK: &attest.AttestationParameters{
+			Public:                  params.AkPublic,
+			UseTCSDActivationFormat: params.AkTcsdActivationFormat,
+			CreateData:              params.AkCreateData,
+			CreateAttestation:       params.AkCreateAttestation,
+			CreateSignature:         params.AkCreateSignature,
+		},
+	}, nil
+}
+
+// MarshalChallenge encodes a credential activation challenge in version. From PayloadVersionTyped
+// challenges are agent.Challenge messages instead.
+func MarshalChallenge(version uint32, ec *attest.EncryptedCredential) ([]byte, error) {
+	switch version {
+	case PayloadVersionJSON:
+		return json.Marshal(ec)
+	case PayloadVersionProto:
+		return proto.Marshal(&agent.TPMActivationChallenge{
+			Version:    version,
+			Credential: ec.Credential,
+			Secret:     ec.Secret,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported payload version: %d", version)
+	}
+}
+
+// UnmarshalChallenge decodes a credential activation challenge of version
+func UnmarshalChallenge(version uint32, b []byte) (*attest.EncryptedCredential, error) {
+	switch version {
+	case PayloadVersionJSON:
+		var ec attest.EncryptedCredential
+		if err := json.Unmarshal(b, &ec); err != nil {
+			return nil, err
+		}
+		return &ec, nil
+	case PayloadVersionProto:
+		var challenge agent.TPMActivationChallenge
+		if err := proto.Unmarshal(b, &challenge); err != nil {
+			return nil, err
+		}
+		if challenge.Version != version {
+			return nil, fmt.Errorf("challenge version %d does not match %d", challenge.Version, version)
+		}
+		return &attest.EncryptedCredential{
+			Credential: challenge.Credential,
+			Secret:     challenge.Secret,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported payload version: %d", version)
+	}
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ParseSPIFFEID parses id and checks that it is a SPIFFE ID of the form spiffe://trust-domain/path
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: %v", id, err)
+	}
+
+	switch {
+	case u.Scheme != "spiffe":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: scheme must be spiffe", id)
+	case u.Host == "":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: missing trust domain", id)
+	case u.User != nil || u.Port() != "":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: trust domain must not contain user info or a port", id)
+	case u.RawQuery != "" || u.Fragment != "":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: query and fragment are not allowed", id)
+	}
+
+	return u, nil
+}
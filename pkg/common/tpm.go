@@ -3,12 +3,20 @@ package common
 import (
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 
 	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 )
 
+// DefaultIDevIDHandle is the persistent handle the TCG assigns to the IDevID key
+const DefaultIDevIDHandle uint32 = 0x81020000
+
 type AttestationData struct {
 	EK []byte
 	AK *attest.AttestationParameters
@@ -125,6 +133,32 @@ func GenerateCredentialActivationData(tpm *attest.TPM) (*AttestationData, []byte
 	return ap, akBlob, nil
 }
 
+// LoadCredentialActivationData loads the AK represented by akBlob, which must have been created by
+// the same TPM, and returns its credential activation material like GenerateCredentialActivationData
+func LoadCredentialActivationData(tpm *attest.TPM, akBlob []byte) (*AttestationData, error) {
+	ak, err := tpm.LoadAK(akBlob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AK: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	ek, err := GetEK(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get EK: %v", err)
+	}
+
+	ekBytes, err := EncodeEK(ek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode EK: %v", err)
+	}
+
+	params := ak.AttestationParameters()
+	return &AttestationData{
+		EK: ekBytes,
+		AK: &params,
+	}, nil
+}
+
 // SolveCredentialActivationChallenge attempts to decrypt a challenge that should be encrypted with
 // the public EK and AK (represented by akBlob), so that only a specific TPM can decrypt it successfully.
 func SolveCredentialActivationChallenge(tpm *attest.TPM, challenge attest.EncryptedCredential, akBlob []byte) ([]byte, error) {
@@ -137,6 +171,101 @@ func SolveCredentialActivationChallenge(tpm *attest.TPM, challenge attest.Encryp
 	return ak.ActivateCredential(tpm, challenge)
 }
 
+// NewCertifiedKey creates an ECDSA P-256 key that never leaves the TPM, certified by the AK
+// represented by akBlob. The key stays usable until it or the TPM is closed.
+func NewCertifiedKey(tpm *attest.TPM, akBlob []byte) (*attest.Key, error) {
+	ak, err := tpm.LoadAK(akBlob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AK: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	key, err := tpm.NewKey(ak, &attest.KeyConfig{Algorithm: attest.ECDSA, Size: 256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %v", err)
+	}
+	return key, nil
+}
+
+// CertifyDevID certifies the DevID key at the persistent handle with the AK represented by akBlob,
+// which proves to the server that both are in the same TPM
+func CertifyDevID(tpm *attest.TPM, akBlob []byte, handle uint32) (*attest.CertificationParameters, error) {
+	ak, err := tpm.LoadAK(akBlob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AK: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	params, err := ak.Certify(tpm, tpmutil.Handle(handle))
+	if err != nil {
+		return nil, fmt.Errorf("failed to certify DevID key: %v", err)
+	}
+	return params, nil
+}
+
+// SignWithDevID signs a SHA256 digest with the DevID key at the persistent handle, returning an
+// ASN.1 encoded ECDSA or a PKCS #1 v1.5 RSA signature. go-attestation cannot load persistent keys,
+// so the TPM is accessed directly through rw.
+func SignWithDevID(rw io.ReadWriter, handle uint32, digest []byte) ([]byte, error) {
+	pub, _, _, err := tpm2.ReadPublic(rw, tpmutil.Handle(handle))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DevID key: %v", err)
+	}
+
+	scheme := &tpm2.SigScheme{Hash: tpm2.AlgSHA256}
+	switch pub.Type {
+	case tpm2.AlgECC:
+		scheme.Alg = tpm2.AlgECDSA
+	case tpm2.AlgRSA:
+		scheme.Alg = tpm2.AlgRSASSA
+	default:
+		return nil, fmt.Errorf("unsupported DevID key type: %v", pub.Type)
+	}
+
+	sig, err := tpm2.Sign(rw, tpmutil.Handle(handle), "", digest, nil, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with DevID key: %v", err)
+	}
+	switch {
+	case sig.ECC != nil:
+		return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	default:
+		return nil, fmt.Errorf("unexpected signature algorithm: %v", sig.Alg)
+	}
+}
+
+// QuotePCRs quotes every PCR of the alg bank with the AK represented by akBlob, returning the quote
+// along with the PCR values so that the server can verify them against it.
+func QuotePCRs(tpm *attest.TPM, akBlob, nonce []byte, alg attest.HashAlg) (*attest.Quote, []attest.PCR, error) {
+	ak, err := tpm.LoadAK(akBlob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load AK: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	quote, err := ak.Quote(tpm, nonce, alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to quote PCRs: %v", err)
+	}
+
+	pcrs, err := tpm.PCRs(alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read PCRs: %v", err)
+	}
+
+	return quote, pcrs, nil
+}
+
+// CSRBinding returns the qualifying data of a binding quote, SHA256(SHA256(spki) || nonce), where
+// spki is the DER encoded SubjectPublicKeyInfo of the CSR
+func CSRBinding(spki, nonce []byte) []byte {
+	keyHash := sha256.Sum256(spki)
+	binding := sha256.Sum256(append(keyHash[:], nonce...))
+	return binding[:]
+}
+
 // GetEK returns the first EK provided, otherwise returns an error
 func GetEK(tpm *attest.TPM) (*attest.EK, error) {
 	eks, err := tpm.EKs()
@@ -0,0 +1,635 @@
+// Package config loads the configuration of the spiffe_fog server from a YAML file and the environment
+package config
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/ca"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/federation"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/server"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported CA backends
+const (
+	CABackendMemory = "memory"
+	CABackendDisk   = "disk"
+)
+
+// Supported AK store backends
+const (
+	AKStoreNone   = "none"
+	AKStoreMemory = "memory"
+	AKStoreDisk   = "disk"
+)
+
+// Config is the configuration file of the server. Every setting can be overridden by an
+// environment variable named after its path, e.g. SPIFFE_FOG_RATE_LIMITS_PER_IP_RATE.
+type Config struct {
+	TrustDomain string        `yaml:"trust_domain"`
+	SVIDTTL     time.Duration `yaml:"svid_ttl"`
+
+	Listen      Listen      `yaml:"listen"`
+	TLS         TLS         `yaml:"tls"`
+	CA          CA          `yaml:"ca"`
+	AKStore     AKStore     `yaml:"ak_store"`
+	EKRegistry  EKRegistry  `yaml:"ek_registry"`
+	Datastore   Datastore   `yaml:"datastore"`
+	Attestors   []string    `yaml:"attestors"`
+	TPMDevID    TPMDevID    `yaml:"tpm_devid"`
+	Attestation Attestation `yaml:"attestation"`
+	RateLimits  RateLimits  `yaml:"rate_limits"`
+	Logging     Logging     `yaml:"logging"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Shutdown    Shutdown    `yaml:"shutdown"`
+	Federation  Federation  `yaml:"federation"`
+}
+
+type Listen struct {
+	// GRPC is the address the Agent service listens on
+	GRPC string `yaml:"grpc"`
+
+	// Metrics is the address Prometheus metrics are exposed on at /metrics, empty to disable
+	Metrics string `yaml:"metrics"`
+
+	// Health is the address liveness and readiness are exposed on at /healthz and /readyz,
+	// empty to disable. It may be the same as Metrics.
+	Health string `yaml:"health"`
+
+	// SPIREAPI additionally serves the AttestAgent method of SPIRE's Agent API on GRPC, for SPIRE
+	// agents with a tpm_activation node attestor
+	SPIREAPI bool `yaml:"spire_api"`
+}
+
+// TLS configures how the gRPC listener terminates TLS, it serves plaintext if neither a
+// certificate nor bootstrapping is configured, e.g. behind Cloud Run.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// Bootstrap issues the serving certificate from the server CA
+	Bootstrap bool     `yaml:"bootstrap"`
+	SPIFFEID  string   `yaml:"spiffe_id"`
+	DNSNames  []string `yaml:"dns_names"`
+
+	// ClientAuth is one of none, verify_if_given or require
+	ClientAuth string `yaml:"client_auth"`
+}
+
+// Enabled returns true if the listener should serve TLS
+func (t TLS) Enabled() bool {
+	return t.Bootstrap || t.CertFile != "" || t.KeyFile != ""
+}
+
+// Server converts the TLS configuration to a server.TLSConfig
+func (t TLS) Server() server.TLSConfig {
+	return server.TLSConfig{
+		CertFile:   t.CertFile,
+		KeyFile:    t.KeyFile,
+		Bootstrap:  t.Bootstrap,
+		SPIFFEID:   t.SPIFFEID,
+		DNSNames:   t.DNSNames,
+		ClientAuth: t.ClientAuth,
+	}
+}
+
+type CA struct {
+	// Backend is either memory, which generates a new root on every start, or disk
+	Backend string        `yaml:"backend"`
+	TTL     time.Duration `yaml:"ttl"`
+
+	// CertFile and KeyFile are PEM files holding the root of the disk backend
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// AKStore remembers the AK activated by every node, so that agents reusing their AK answer a
+// quote instead of activating it again
+type AKStore struct {
+	// Backend is none, which activates the AK in every attestation, memory or disk
+	Backend string `yaml:"backend"`
+
+	// File holds the known AKs of the disk backend
+	File string `yaml:"file"`
+}
+
+// EKRegistry lists the nodes allowed to attest, inline or in a separate YAML file
+type EKRegistry struct {
+	File  string `yaml:"file"`
+	Nodes []Node `yaml:"nodes"`
+}
+
+type Node struct {
+	EKHash    string   `yaml:"ek_hash"`
+	Selectors []string `yaml:"selectors"`
+}
+
+// Datastore lists the registration entries, inline or in a separate YAML file
+type Datastore struct {
+	File    string  `yaml:"file"`
+	Entries []Entry `yaml:"entries"`
+}
+
+type Entry struct {
+	ID                string   `yaml:"id"`
+	SPIFFEID          string   `yaml:"spiffe_id"`
+	Selectors         []string `yaml:"selectors"`
+	WorkloadSelectors []string `yaml:"workload_selectors"`
+}
+
+type Attestation struct {
+	StepTimeout   time.Duration `yaml:"step_timeout"`
+	AttestTimeout time.Duration `yaml:"attest_timeout"`
+	MaxInFlight   int           `yaml:"max_in_flight"`
+	Challenges    []string      `yaml:"challenges"`
+	MaxRounds     int           `yaml:"max_rounds"`
+
+	// RequireTPMKeys only issues X509-SVIDs for keys created in the TPM and certified by the AK
+	RequireTPMKeys bool `yaml:"require_tpm_keys"`
+
+	// ReplayWindow rejects the AK parameters and CSR of a successful attestation for this long
+	ReplayWindow time.Duration `yaml:"replay_window"`
+
+	// AllowUnboundCSR accepts agents older than payload version 2, whose CSR is not bound to the AK
+	AllowUnboundCSR bool `yaml:"allow_unbound_csr"`
+}
+
+type RateLimits struct {
+	PerIP             RateLimit `yaml:"per_ip"`
+	PerEK             RateLimit `yaml:"per_ek"`
+	TrustForwardedFor bool      `yaml:"trust_forwarded_for"`
+}
+
+// RateLimit is a token bucket, a negative rate disables it
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter"`
+}
+
+type Shutdown struct {
+	// DrainTimeout is how long attestations in progress may take to finish after SIGTERM
+	// before they are cancelled
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// TPMDevID configures the tpm_devid attestor
+type TPMDevID struct {
+	// RootsFile holds the PEM encoded manufacturer CAs that DevID certificates must chain to
+	RootsFile string `yaml:"roots_file"`
+}
+
+// Federation exchanges bundles with the servers of other trust domains, so that their workloads
+// can authenticate each other
+type Federation struct {
+	// BundleEndpoint serves the bundle of this trust domain to the servers federating with it
+	BundleEndpoint BundleEndpoint `yaml:"bundle_endpoint"`
+
+	// RefreshInterval is how often bundles are fetched from endpoints that give no refresh hint
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+	// CacheDir keeps fetched bundles across restarts, they are only kept in memory if empty
+	CacheDir string `yaml:"cache_dir"`
+
+	// TrustDomains are the trust domains whose bundles are fetched and returned to agents
+	TrustDomains []FederatedTrustDomain `yaml:"trust_domains"`
+}
+
+type BundleEndpoint struct {
+	// Listen is the address the bundle endpoint is served on, empty to disable
+	Listen string `yaml:"listen"`
+
+	// Profile is https_spiffe, which presents an X509-SVID for SPIFFEID issued by the server CA,
+	// or https_web, which presents CertFile and KeyFile
+	Profile  string `yaml:"profile"`
+	SPIFFEID string `yaml:"spiffe_id"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// RefreshHint tells federated servers how often to fetch the bundle
+	RefreshHint time.Duration `yaml:"refresh_hint"`
+}
+
+type FederatedTrustDomain struct {
+	TrustDomain       string `yaml:"trust_domain"`
+	BundleEndpointURL string `yaml:"bundle_endpoint_url"`
+
+	// Profile is https_spiffe or https_web, see BundleEndpoint
+	Profile string `yaml:"profile"`
+
+	// EndpointSPIFFEID is the SPIFFE ID an https_spiffe endpoint must present, defaults to
+	// spiffe://<trust domain>/server
+	EndpointSPIFFEID string `yaml:"endpoint_spiffe_id"`
+
+	// BundleFile holds PEM encoded certificates that authenticate the first fetch from an
+	// https_spiffe endpoint, or replace the system roots for an https_web endpoint
+	BundleFile string `yaml:"bundle_file"`
+}
+
+// Default returns the configuration used when no file is provided
+func Default() *Config {
+	return &Config{
+		TrustDomain: "spiffe_fog",
+		SVIDTTL:     time.Hour,
+		Listen: Listen{
+			GRPC:    ":8080",
+			Metrics: ":9090",
+			Health:  ":8081",
+		},
+		TLS: TLS{
+			ClientAuth: server.ClientAuthNone,
+		},
+		CA: CA{
+			Backend: CABackendMemory,
+			TTL:     24 * time.Hour,
+		},
+		AKStore: AKStore{
+			Backend: AKStoreNone,
+		},
+		Attestors: []string{server.AttestorTPMActivation},
+		Attestation: Attestation{
+			StepTimeout:   30 * time.Second,
+			AttestTimeout: 2 * time.Minute,
+			MaxInFlight:   64,
+			Challenges:    []string{server.ChallengeActivation},
+			MaxRounds:     4,
+			ReplayWindow:  time.Hour,
+		},
+		RateLimits: RateLimits{
+			PerIP: RateLimit{Rate: 1, Burst: 10},
+			PerEK: RateLimit{Rate: 0.1, Burst: 3},
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: telemetry.LogFormatText,
+		},
+		Tracing: Tracing{
+			Exporter: telemetry.ExporterNone,
+		},
+		Shutdown: Shutdown{
+			DrainTimeout: 25 * time.Second,
+		},
+		Federation: Federation{
+			BundleEndpoint: BundleEndpoint{
+				Profile:     federation.ProfileHTTPSSPIFFE,
+				RefreshHint: 5 * time.Minute,
+			},
+			RefreshInterval: 5 * time.Minute,
+		},
+	}
+}
+
+// Load reads the configuration file at path over the defaults and applies environment overrides.
+// An empty path only applies environment overrides.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := decodeFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// decodeFile strictly decodes the YAML file at path into out, unknown fields are an error
+func decodeFile(path string, out any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks for settings that can never work
+func (c *Config) Validate() error {
+	if c.TrustDomain == "" {
+		return errors.New("trust_domain is required")
+	}
+	if c.Listen.GRPC == "" {
+		return errors.New("listen.grpc is required")
+	}
+	if c.Attestation.MaxInFlight < 1 {
+		return errors.New("attestation.max_in_flight must be at least 1")
+	}
+	if c.Attestation.ReplayWindow < 0 {
+		return errors.New("attestation.replay_window must not be negative")
+	}
+	if err := server.ValidateChallenges(c.Attestation.Challenges, c.Attestation.MaxRounds); err != nil {
+		return fmt.Errorf("attestation.challenges: %v", err)
+	}
+	if err := server.ValidateAttestors(c.Attestors, c.Attestation.Challenges, c.Attestation.MaxRounds); err != nil {
+		return fmt.Errorf("attestors: %v", err)
+	}
+	if slices.Contains(c.Attestors, server.AttestorTPMDevID) && c.TPMDevID.RootsFile == "" {
+		return fmt.Errorf("tpm_devid.roots_file is required by the %s attestor", server.AttestorTPMDevID)
+	}
+
+	if !c.TLS.Bootstrap && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case server.ClientAuthNone, server.ClientAuthVerifyIfGiven, server.ClientAuthRequire:
+	default:
+		return fmt.Errorf("unsupported tls.client_auth: %s", c.TLS.ClientAuth)
+	}
+	if c.TLS.ClientAuth != server.ClientAuthNone && !c.TLS.Enabled() {
+		return errors.New("tls.client_auth requires a serving certificate or tls.bootstrap")
+	}
+
+	switch c.CA.Backend {
+	case CABackendMemory:
+	case CABackendDisk:
+		if c.CA.CertFile == "" || c.CA.KeyFile == "" {
+			return errors.New("ca.cert_file and ca.key_file are required by the disk backend")
+		}
+	default:
+		return fmt.Errorf("unsupported ca.backend: %s", c.CA.Backend)
+	}
+
+	switch c.AKStore.Backend {
+	case AKStoreNone, AKStoreMemory:
+	case AKStoreDisk:
+		if c.AKStore.File == "" {
+			return errors.New("ak_store.file is required by the disk backend")
+		}
+	default:
+		return fmt.Errorf("unsupported ak_store.backend: %s", c.AKStore.Backend)
+	}
+
+	switch c.Federation.BundleEndpoint.Profile {
+	case federation.ProfileHTTPSSPIFFE:
+	case federation.ProfileHTTPSWeb:
+		if c.Federation.BundleEndpoint.Listen != "" && (c.Federation.BundleEndpoint.CertFile == "" || c.Federation.BundleEndpoint.KeyFile == "") {
+			return errors.New("federation.bundle_endpoint.cert_file and key_file are required by the https_web profile")
+		}
+	default:
+		return fmt.Errorf("unsupported federation.bundle_endpoint.profile: %s", c.Federation.BundleEndpoint.Profile)
+	}
+	for _, td := range c.Federation.TrustDomains {
+		if td.TrustDomain == c.TrustDomain {
+			return fmt.Errorf("federation.trust_domains: cannot federate with the own trust domain %s", td.TrustDomain)
+		}
+	}
+	if _, err := c.Peers(); err != nil {
+		return err
+	}
+
+	if _, err := c.Store(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NewCA creates the CA of the configured backend
+func (c *Config) NewCA() (*ca.CA, error) {
+	if c.CA.Backend == CABackendDisk {
+		return ca.Load(c.CA.CertFile, c.CA.KeyFile)
+	}
+	return ca.New(c.TrustDomain, c.CA.TTL)
+}
+
+// NewAKStore creates the AK store of the configured backend, it returns nil if AKs are not reused
+func (c *Config) NewAKStore() (registry.AKStore, error) {
+	switch c.AKStore.Backend {
+	case AKStoreMemory:
+		return registry.NewMemoryAKStore(), nil
+	case AKStoreDisk:
+		return registry.NewFileAKStore(c.AKStore.File)
+	default:
+		return nil, nil
+	}
+}
+
+// Peers loads the bundle files of the federated trust domains
+func (c *Config) Peers() ([]federation.Peer, error) {
+	peers := make([]federation.Peer, 0, len(c.Federation.TrustDomains))
+	for _, td := range c.Federation.TrustDomains {
+		peer := federation.Peer{
+			TrustDomain:      td.TrustDomain,
+			URL:              td.BundleEndpointURL,
+			Profile:          td.Profile,
+			EndpointSPIFFEID: td.EndpointSPIFFEID,
+		}
+		if peer.Profile == "" {
+			peer.Profile = federation.ProfileHTTPSSPIFFE
+		}
+		if peer.EndpointSPIFFEID == "" {
+			peer.EndpointSPIFFEID = fmt.Sprintf("spiffe://%s/server", td.TrustDomain)
+		}
+		if td.BundleFile != "" {
+			bundle, err := common.LoadBundle(td.BundleFile)
+			if err != nil {
+				return nil, fmt.Errorf("federation.trust_domains: %s: %v", td.TrustDomain, err)
+			}
+			peer.Bundle = bundle
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// NewFederation creates the fetcher of the federated bundles, it returns nil if no trust domains
+// are federated
+func (c *Config) NewFederation(logger *slog.Logger) (*federation.Fetcher, error) {
+	if len(c.Federation.TrustDomains) == 0 {
+		return nil, nil
+	}
+	peers, err := c.Peers()
+	if err != nil {
+		return nil, err
+	}
+	return federation.NewFetcher(peers, c.Federation.RefreshInterval, c.Federation.CacheDir, logger)
+}
+
+// Store builds the registry from the EK registry and datastore. It returns nil if neither
+// has any nodes or entries so that the server falls back to its demo registry.
+func (c *Config) Store() (registry.Store, error) {
+	nodes := c.EKRegistry.Nodes
+	if c.EKRegistry.File != "" {
+		var fromFile []Node
+		if err := decodeFile(c.EKRegistry.File, &fromFile); err != nil {
+			return nil, err
+		}
+		nodes = append(append([]Node(nil), nodes...), fromFile...)
+	}
+
+	entries := c.Datastore.Entries
+	if c.Datastore.File != "" {
+		var fromFile []Entry
+		if err := decodeFile(c.Datastore.File, &fromFile); err != nil {
+			return nil, err
+		}
+		entries = append(append([]Entry(nil), entries...), fromFile...)
+	}
+
+	if len(nodes) == 0 && len(entries) == 0 {
+		return nil, nil
+	}
+
+	store := registry.NewMemoryStore()
+	for _, n := range nodes {
+		if n.EKHash == "" {
+			return nil, errors.New("ek_registry: node is missing ek_hash")
+		}
+		selectors, err := parseSelectors(n.Selectors)
+		if err != nil {
+			return nil, fmt.Errorf("ek_registry: node %s: %v", n.EKHash, err)
+		}
+		store.AddNode(registry.Node{EKHash: n.EKHash, Selectors: selectors})
+	}
+
+	for _, e := range entries {
+		id, err := common.ParseSPIFFEID(e.SPIFFEID)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: %v", err)
+		}
+		if id.Host != c.TrustDomain {
+			return nil, fmt.Errorf("datastore: %s is not a member of trust domain %s", e.SPIFFEID, c.TrustDomain)
+		}
+
+		selectors, err := parseSelectors(e.Selectors)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: entry %s: %v", e.SPIFFEID, err)
+		}
+		if len(selectors) == 0 {
+			return nil, fmt.Errorf("datastore: entry %s has no selectors", e.SPIFFEID)
+		}
+		workloadSelectors, err := parseSelectors(e.WorkloadSelectors)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: entry %s: %v", e.SPIFFEID, err)
+		}
+
+		store.AddEntry(registry.Entry{
+			ID:                e.ID,
+			SPIFFEID:          e.SPIFFEID,
+			Selectors:         selectors,
+			WorkloadSelectors: workloadSelectors,
+		})
+	}
+
+	return store, nil
+}
+
+func parseSelectors(raw []string) ([]registry.Selector, error) {
+	selectors := make([]registry.Selector, 0, len(raw))
+	for _, s := range raw {
+		selector, err := registry.ParseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// Server converts the configuration to a server.Config without a CA, AK store, registerer or logger
+func (c *Config) Server() (server.Config, error) {
+	store, err := c.Store()
+	if err != nil {
+		return server.Config{}, err
+	}
+
+	var devIDRoots []*x509.Certificate
+	if c.TPMDevID.RootsFile != "" {
+		devIDRoots, err = common.LoadBundle(c.TPMDevID.RootsFile)
+		if err != nil {
+			return server.Config{}, fmt.Errorf("tpm_devid.roots_file: %v", err)
+		}
+	}
+
+	return server.Config{
+		TrustDomain:     c.TrustDomain,
+		Store:           store,
+		Attestors:       c.Attestors,
+		DevIDRoots:      devIDRoots,
+		SVIDTTL:         c.SVIDTTL,
+		StepTimeout:     c.Attestation.StepTimeout,
+		AttestTimeout:   c.Attestation.AttestTimeout,
+		MaxInFlight:     c.Attestation.MaxInFlight,
+		Challenges:      c.Attestation.Challenges,
+		MaxRounds:       c.Attestation.MaxRounds,
+		RequireTPMKeys:  c.Attestation.RequireTPMKeys,
+		ReplayWindow:    c.Attestation.ReplayWindow,
+		AllowUnboundCSR: c.Attestation.AllowUnboundCSR,
+		PerIPLimit: server.RateLimit{
+			Rate:  c.RateLimits.PerIP.Rate,
+			Burst: c.RateLimits.PerIP.Burst,
+		},
+		PerEKLimit: server.RateLimit{
+			Rate:  c.RateLimits.PerEK.Rate,
+			Burst: c.RateLimits.PerEK.Burst,
+		},
+		TrustForwardedFor: c.RateLimits.TrustForwardedFor,
+	}, nil
+}
+
+// RestartRequired returns the settings that differ between c and next but cannot be reloaded
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	if c.TrustDomain != next.TrustDomain {
+		changed = append(changed, "trust_domain")
+	}
+	if c.Listen != next.Listen {
+		changed = append(changed, "listen")
+	}
+	if !reflect.DeepEqual(c.TLS, next.TLS) {
+		changed = append(changed, "tls")
+	}
+	if c.CA != next.CA {
+		changed = append(changed, "ca")
+	}
+	if c.AKStore != next.AKStore {
+		changed = append(changed, "ak_store")
+	}
+	if c.Logging.Format != next.Logging.Format {
+		changed = append(changed, "logging.format")
+	}
+	if c.Tracing != next.Tracing {
+		changed = append(changed, "tracing")
+	}
+	if c.Shutdown != next.Shutdown {
+		changed = append(changed, "shutdown")
+	}
+	if !reflect.DeepEqual(c.Federation, next.Federation) {
+		changed = append(changed, "federation")
+	}
+	return changed
+}
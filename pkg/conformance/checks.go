@@ -0,0 +1,457 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/server"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc/codes"
+)
+
+// Outcomes of a check
+const (
+	Pass = "PASS"
+	Fail = "FAIL"
+	Skip = "SKIP"
+)
+
+// Result is the outcome of a single check against a single transcript
+type Result struct {
+	Check   string
+	Outcome string
+	Detail  string
+}
+
+// Check inspects a transcript of a scenario
+type Check struct {
+	Name        string
+	Description string
+
+	// Run returns errSkip if the check does not apply to the scenario
+	Run func(sc Scenario, t *Transcript, stepTimeout time.Duration) error
+}
+
+var errSkip = errors.New("not applicable")
+
+// Scenario configures the reference server for a single attestation by the agent under test
+type Scenario struct {
+	Name        string
+	Description string
+
+	// Store returns the registry of the reference server given the SPIFFE ID the agent requests
+	Store func(spiffeID string) registry.Store
+
+	// Challenges the reference server issues, defaults to a single activation. Agents of payload
+	// version 2 also get a binding challenge if it is missing.
+	Challenges []string
+
+	// WantCode is the status the reference server should end the stream with
+	WantCode codes.Code
+}
+
+// rounds returns the number of challenges the reference server issues in sc to an agent of version
+func (sc Scenario) rounds(version uint32) int {
+	challenges := sc.Challenges
+	if len(challenges) == 0 {
+		challenges = []string{server.ChallengeActivation}
+	}
+	if version >= common.PayloadVersionTyped && !slices.Contains(challenges, server.ChallengeBinding) {
+		return len(challenges) + 1
+	}
+	return len(challenges)
+}
+
+// conformanceSelector is given to every node by stores that trust any EK
+var conformanceSelector = registry.Selector{Type: "conformance", Value: "any_ek"}
+
+// anyEKStore trusts every EK and entitles it to the entries
+type anyEKStore struct {
+	entries []registry.Entry
+}
+
+func (s anyEKStore) FetchNode(_ context.Context, ekHash string) (*registry.Node, error) {
+	return &registry.Node{EKHash: ekHash, Selectors: []registry.Selector{conformanceSelector}}, nil
+}
+
+func (s anyEKStore) ListEntries(context.Context) ([]registry.Entry, error) {
+	return s.entries, nil
+}
+
+// Scenarios are run in order against the agent under test
+var Scenarios = []Scenario{
+	{
+		Name:        "success",
+		Description: "any EK is trusted and entitled to the requested SPIFFE ID",
+		Store: func(spiffeID string) registry.Store {
+			return anyEKStore{entries: []registry.Entry{{
+				ID:        "conformance",
+				SPIFFEID:  spiffeID,
+				Selectors: []registry.Selector{conformanceSelector},
+			}}}
+		},
+		WantCode: codes.OK,
+	},
+	{
+		Name:        "multi_round",
+		Description: "like success, but the agent must also answer a quote, a nonce and a binding challenge, which requires payload version 2",
+		Store: func(spiffeID string) registry.Store {
+			return anyEKStore{entries: []registry.Entry{{
+				ID:        "conformance",
+				SPIFFEID:  spiffeID,
+				Selectors: []registry.Selector{conformanceSelector},
+			}}}
+		},
+		Challenges: []string{server.ChallengeActivation, server.ChallengeQuote, server.ChallengeNonce, server.ChallengeBinding},
+		WantCode:   codes.OK,
+	},
+	{
+		Name:        "unknown_ek",
+		Description: "no EK is trusted, the agent must stop after the server rejects its params",
+		Store: func(string) registry.Store {
+			return registry.NewMemoryStore()
+		},
+		WantCode: codes.InvalidArgument,
+	},
+	{
+		Name:        "unauthorized_id",
+		Description: "any EK is trusted but not entitled to the requested SPIFFE ID",
+		Store: func(spiffeID string) registry.Store {
+			return anyEKStore{entries: []registry.Entry{{
+				ID:        "conformance",
+				SPIFFEID:  spiffeID + "-not-entitled",
+				Selectors: []registry.Selector{conformanceSelector},
+			}}}
+		},
+		WantCode: codes.InvalidArgument,
+	},
+}
+
+// ScenarioByName returns the scenario a recorded transcript was taken from
+func ScenarioByName(name string) (Scenario, bool) {
+	for _, sc := range Scenarios {
+		if sc.Name == name {
+			return sc, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// Checks are evaluated against the transcript of every scenario
+var Checks = []Check{
+	{
+		Name:        "ordering",
+		Description: "params, a challenge and its response for every round, and the result are exchanged in order and nothing else",
+		Run:         checkOrdering,
+	},
+	{
+		Name:        "params_schema",
+		Description: "the first message carries tpm_activation params with a well-formed payload of its version and a valid CSR",
+		Run:         checkParamsSchema,
+	},
+	{
+		Name:        "challenge_schema",
+		Description: "every challenge is encoded in the agent's payload version and the agent answers it in the same round with the same kind",
+		Run:         checkChallengeSchema,
+	},
+	{
+		Name:        "status_code",
+		Description: "the stream ends with the status code expected by the scenario",
+		Run:         checkStatusCode,
+	},
+	{
+		Name:        "timeouts",
+		Description: "the agent sends each message within the step timeout",
+		Run:         checkTimeouts,
+	},
+}
+
+// Evaluate runs every check against a transcript of sc
+func Evaluate(sc Scenario, t *Transcript, stepTimeout time.Duration) []Result {
+	results := make([]Result, 0, len(Checks))
+	for _, c := range Checks {
+		r := Result{Check: c.Name, Outcome: Pass}
+		if err := c.Run(sc, t, stepTimeout); errors.Is(err, errSkip) {
+			r.Outcome = Skip
+		} else if err != nil {
+			r.Outcome = Fail
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+type expectedStep struct{ from, step string }
+
+// expectedSteps is the sequence of senders and steps of a successful attestation of sc. Challenges
+// are typed from payload version 2.
+func expectedSteps(sc Scenario, version uint32) []expectedStep {
+	challenge, response := "challenge", "challengeResponse"
+	if version >= common.PayloadVersionTyped {
+		challenge, response = "typedChallenge", "typedChallengeResponse"
+	}
+
+	steps := []expectedStep{{FromAgent, "params"}}
+	for i := 0; i < sc.rounds(version); i++ {
+		steps = append(steps, expectedStep{FromServer, challenge}, expectedStep{FromAgent, response})
+	}
+	return append(steps, expectedStep{FromServer, "result"})
+}
+
+// payloadVersion returns the payload version of the agent's params, if any
+func payloadVersion(t *Transcript) uint32 {
+	if len(t.Messages) == 0 {
+		return common.PayloadVersionJSON
+	}
+	req, err := t.Messages[0].Request()
+	if err != nil {
+		return common.PayloadVersionJSON
+	}
+	return req.GetParams().GetData().GetPayloadVersion()
+}
+
+func step(m Message) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(m.Data, &fields); err != nil {
+		return "", fmt.Errorf("failed to decode %s message: %v", m.From, err)
+	}
+	if len(fields) != 1 {
+		return "", fmt.Errorf("%s message must set exactly one step, found %d", m.From, len(fields))
+	}
+	for name := range fields {
+		return name, nil
+	}
+	return "", nil
+}
+
+func checkOrdering(sc Scenario, t *Transcript, _ time.Duration) error {
+	want := expectedSteps(sc, payloadVersion(t))
+	if sc.WantCode != codes.OK {
+		// Rejected agents only get to send their params
+		want = want[:1]
+	}
+
+	if len(t.Messages) > len(want) {
+		return fmt.Errorf("expected %d messages, got %d", len(want), len(t.Messages))
+	}
+	for i, m := range t.Messages {
+		s, err := step(m)
+		if err != nil {
+			return err
+		}
+		if m.From != want[i].from || s != want[i].step {
+			return fmt.Errorf("message %d: expected %s from the %s, got %s from the %s", i+1, want[i].step, want[i].from, s, m.From)
+		}
+	}
+	if len(t.Messages) < len(want) {
+		return fmt.Errorf("stream ended after %d of %d messages", len(t.Messages), len(want))
+	}
+	return nil
+}
+
+func checkParamsSchema(_ Scenario, t *Transcript, _ time.Duration) error {
+	if len(t.Messages) == 0 {
+		return errors.New("the agent did not send any messages")
+	}
+	req, err := t.Messages[0].Request()
+	if err != nil {
+		return err
+	}
+	params := req.GetParams()
+	switch {
+	case params == nil:
+		return errors.New("first message does not carry params")
+	case params.GetData().GetType() != "tpm_activation":
+		return fmt.Errorf("attestation type must be tpm_activation, got %q", params.GetData().GetType())
+	case len(params.GetParams().GetCsr()) == 0:
+		return errors.New("missing CSR")
+	}
+
+	data, err := decodeAttestationData(params.GetData().GetPayloadVersion(), params.GetData().GetPayload())
+	if err != nil {
+		return err
+	}
+	if _, err := common.DecodeEK(data.EK); err != nil {
+		return fmt.Errorf("EK must be a PEM encoded certificate or public key: %v", err)
+	}
+	if data.AK == nil {
+		return errors.New("missing AK")
+	}
+	for name, field := range map[string][]byte{
+		"Public":            data.AK.Public,
+		"CreateData":        data.AK.CreateData,
+		"CreateAttestation": data.AK.CreateAttestation,
+		"CreateSignature":   data.AK.CreateSignature,
+	} {
+		if len(field) == 0 {
+			return fmt.Errorf("AK is missing %s", name)
+		}
+	}
+
+	csr, err := x509.ParseCertificateRequest(params.GetParams().GetCsr())
+	if err != nil {
+		return fmt.Errorf("CSR must be DER encoded: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid CSR signature: %v", err)
+	}
+	if len(csr.URIs) != 1 {
+		return fmt.Errorf("CSR must contain exactly one URI SAN, found %d", len(csr.URIs))
+	}
+	if _, err := common.ParseSPIFFEID(csr.URIs[0].String()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkChallengeSchema(sc Scenario, t *Transcript, _ time.Duration) error {
+	if sc.WantCode != codes.OK {
+		return errSkip
+	}
+
+	version := payloadVersion(t)
+	for i := 0; i < sc.rounds(version); i++ {
+		if len(t.Messages) < 3+2*i {
+			return fmt.Errorf("stream ended before round %d was answered", i+1)
+		}
+
+		resp, err := t.Messages[1+2*i].Response()
+		if err != nil {
+			return err
+		}
+		req, err := t.Messages[2+2*i].Request()
+		if err != nil {
+			return err
+		}
+
+		if version < common.PayloadVersionTyped {
+			if err := decodeChallenge(version, resp.GetChallenge()); err != nil {
+				return err
+			}
+			if len(req.GetChallengeResponse()) == 0 {
+				return errors.New("empty challenge response")
+			}
+			continue
+		}
+
+		if err := checkTypedRound(uint32(i+1), version, resp.GetTypedChallenge(), req.GetTypedChallengeResponse()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTypedRound checks that the agent answered a typed challenge of the reference server
+func checkTypedRound(round, version uint32, challenge *agent.Challenge, answer *agent.ChallengeResponse) error {
+	switch {
+	case challenge == nil:
+		return fmt.Errorf("reference server did not send a typed challenge in round %d", round)
+	case challenge.GetRound() != round:
+		return fmt.Errorf("reference server challenge is for round %d, expected %d", challenge.GetRound(), round)
+	case challenge.GetActivation() != nil && challenge.GetActivation().GetVersion() != version:
+		return fmt.Errorf("reference server activation challenge is version %d, expected %d", challenge.GetActivation().GetVersion(), version)
+	case answer == nil:
+		return fmt.Errorf("round %d was not answered with a typed challenge response", round)
+	case answer.GetRound() != round:
+		return fmt.Errorf("round %d was answered as round %d", round, answer.GetRound())
+	}
+
+	switch {
+	case challenge.GetActivation() != nil:
+		if len(answer.GetActivation().GetSecret()) == 0 {
+			return fmt.Errorf("round %d: expected an activation response with a secret", round)
+		}
+	case challenge.GetQuote() != nil:
+		q := answer.GetQuote()
+		if len(q.GetQuote()) == 0 || len(q.GetSignature()) == 0 || len(q.GetPcrs()) == 0 {
+			return fmt.Errorf("round %d: expected a quote response with a quote, signature and PCRs", round)
+		}
+	case challenge.GetNonce() != nil:
+		if len(answer.GetNonce().GetSignature()) == 0 {
+			return fmt.Errorf("round %d: expected a nonce response with a signature", round)
+		}
+	case challenge.GetBinding() != nil:
+		b := answer.GetBinding()
+		if len(b.GetQuote()) == 0 || len(b.GetSignature()) == 0 || len(b.GetPcrs()) == 0 {
+			return fmt.Errorf("round %d: expected a binding response with a quote, signature and PCRs", round)
+		}
+	default:
+		return fmt.Errorf("reference server sent a challenge of unknown kind in round %d", round)
+	}
+	return nil
+}
+
+// decodeAttestationData decodes a tpm_activation payload, rejecting legacy JSON payloads with fields
+// that common.AttestationData does not have
+func decodeAttestationData(version uint32, payload []byte) (*common.AttestationData, error) {
+	if version != common.PayloadVersionJSON {
+		data, err := common.UnmarshalAttestationData(version, payload)
+		if err != nil {
+			return nil, fmt.Errorf("payload is not a version %d agent.TPMActivationParams: %v", version, err)
+		}
+		return data, nil
+	}
+
+	var data common.AttestationData
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("payload is not a common.AttestationData: %v", err)
+	}
+	return &data, nil
+}
+
+// decodeChallenge checks that the reference server encoded the challenge in the agent's version
+func decodeChallenge(version uint32, challenge []byte) error {
+	if version != common.PayloadVersionJSON {
+		if _, err := common.UnmarshalChallenge(version, challenge); err != nil {
+			return fmt.Errorf("reference server challenge is not a version %d agent.TPMActivationChallenge: %v", version, err)
+		}
+		return nil
+	}
+
+	var ec attest.EncryptedCredential
+	dec := json.NewDecoder(bytes.NewReader(challenge))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ec); err != nil {
+		return fmt.Errorf("reference server challenge is not an attest.EncryptedCredential: %v", err)
+	}
+	return nil
+}
+
+func checkStatusCode(sc Scenario, t *Transcript, _ time.Duration) error {
+	if t.Code != sc.WantCode {
+		return fmt.Errorf("expected %s, got %s: %s", sc.WantCode, t.Code, t.Status)
+	}
+	return nil
+}
+
+func checkTimeouts(_ Scenario, t *Transcript, stepTimeout time.Duration) error {
+	if t.Code == codes.DeadlineExceeded {
+		return fmt.Errorf("server timed out waiting for the agent: %s", t.Status)
+	}
+
+	// The agent has stepTimeout from the stream opening, or from the last server message
+	var last time.Duration
+	for i, m := range t.Messages {
+		if m.From == FromAgent {
+			if took := m.Elapsed - last; took > stepTimeout {
+				return fmt.Errorf("message %d took %s, longer than the %s step timeout", i+1, took, stepTimeout)
+			}
+		}
+		last = m.Elapsed
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Package conformance checks that third-party agents speak the Agent.AttestAgent protocol like
+// the reference client. It runs a reference server.Service for a series of scenarios, records the
+// exchange with the agent under test and evaluates it, or evaluates previously recorded transcripts.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/server"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultAddr        = "127.0.0.1:0"
+	defaultStepTimeout = 30 * time.Second
+	defaultWaitTimeout = 2 * time.Minute
+)
+
+// Suite runs every scenario against an agent
+type Suite struct {
+	// SPIFFEID is the ID the agent under test requests, e.g. spiffe://spiffe_fog/demo
+	SPIFFEID string
+
+	// Addr the reference server listens on, defaults to a random local port
+	Addr string
+
+	// RunAgent starts a single attestation by the agent under test against addr. If it is nil
+	// the suite waits for the agent to be started by other means.
+	RunAgent func(ctx context.Context, addr string) error
+
+	// StepTimeout is the step timeout of the reference server, defaults to 30 seconds
+	StepTimeout time.Duration
+
+	// WaitTimeout is how long to wait for the agent in each scenario, defaults to 2 minutes
+	WaitTimeout time.Duration
+
+	// RecordDir saves the transcript of every scenario as <scenario>.json if set
+	RecordDir string
+
+	// Logger reports progress, defaults to discarding everything
+	Logger *slog.Logger
+}
+
+// Report is the pass/fail matrix of scenarios and checks
+type Report struct {
+	Scenarios []ScenarioReport
+}
+
+type ScenarioReport struct {
+	Scenario string
+	Results  []Result
+}
+
+// Passed returns true if no check failed in any scenario
+func (r *Report) Passed() bool {
+	for _, sr := range r.Scenarios {
+		for _, res := range sr.Results {
+			if res.Outcome == Fail {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Add evaluates a transcript of sc and adds it to the report
+func (r *Report) Add(sc Scenario, t *Transcript, stepTimeout time.Duration) {
+	r.Scenarios = append(r.Scenarios, ScenarioReport{
+		Scenario: sc.Name,
+		Results:  Evaluate(sc, t, stepTimeout),
+	})
+}
+
+// Write prints the matrix with a row per scenario and a column per check, followed by the
+// details of every failure
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	header := []string{"SCENARIO"}
+	for _, c := range Checks {
+		header = append(header, strings.ToUpper(c.Name))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	var failures []string
+	for _, sr := range r.Scenarios {
+		row := []string{sr.Scenario}
+		for _, res := range sr.Results {
+			row = append(row, res.Outcome)
+			if res.Outcome == Fail {
+				failures = append(failures, fmt.Sprintf("%s/%s: %s", sr.Scenario, res.Check, res.Detail))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, f := range failures {
+		if _, err := fmt.Fprintln(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs every scenario in order against the agent under test
+func (s *Suite) Run(ctx context.Context) (*Report, error) {
+	if s.SPIFFEID == "" {
+		return nil, fmt.Errorf("the SPIFFE ID requested by the agent is required")
+	}
+	if s.StepTimeout == 0 {
+		s.StepTimeout = defaultStepTimeout
+	}
+	if s.WaitTimeout == 0 {
+		s.WaitTimeout = defaultWaitTimeout
+	}
+	if s.Addr == "" {
+		s.Addr = defaultAddr
+	}
+	if s.Logger == nil {
+		s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	report := &Report{}
+	for _, sc := range Scenarios {
+		t, err := s.runScenario(ctx, sc)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: %v", sc.Name, err)
+		}
+
+		if s.RecordDir != "" {
+			if err := t.Save(filepath.Join(s.RecordDir, sc.Name+".json")); err != nil {
+				return nil, fmt.Errorf("failed to save transcript of %s: %v", sc.Name, err)
+			}
+		}
+
+		report.Add(sc, t, s.StepTimeout)
+	}
+
+	return report, nil
+}
+
+// runScenario serves a reference server configured for sc until the agent's first stream ends
+func (s *Suite) runScenario(ctx context.Context, sc Scenario) (*Transcript, error) {
+	svc, err := server.New(server.Config{
+		Store:       sc.Store(s.SPIFFEID),
+		StepTimeout: s.StepTimeout,
+		Challenges:  sc.Challenges,
+		// Legacy agents are tested too, even though they cannot bind their CSR to the AK
+		AllowUnboundCSR: true,
+		// Every scenario is a single attestation, limits would only get in the way
+		PerIPLimit: server.RateLimit{Rate: -1},
+		PerEKLimit: server.RateLimit{Rate: -1},
+		Logger:     s.Logger.With("scenario", sc.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	addr := listener.Addr().String()
+
+	rec := newRecorder()
+	srv := grpc.NewServer(grpc.StreamInterceptor(rec.intercept))
+	agent.RegisterAgentServer(srv, svc)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(ctx, s.WaitTimeout)
+	defer cancel()
+
+	agentDone := make(chan error, 1)
+	if s.RunAgent != nil {
+		s.Logger.Info("starting agent", "scenario", sc.Name, "addr", addr)
+		go func() {
+			agentDone <- s.RunAgent(ctx, addr)
+		}()
+	} else {
+		s.Logger.Info("waiting for agent", "scenario", sc.Name, "addr", addr)
+	}
+
+	var t *Transcript
+	select {
+	case t = <-rec.transcripts:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("agent did not attest within %s", s.WaitTimeout)
+	}
+	t.Scenario = sc.Name
+
+	// Agents are expected to fail in the negative scenarios, only wait for them to exit
+	if s.RunAgent != nil {
+		select {
+		case err := <-agentDone:
+			s.Logger.Info("agent exited", "scenario", sc.Name, "error", err)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("agent did not exit within %s", s.WaitTimeout)
+		}
+	}
+
+	return t, nil
+}
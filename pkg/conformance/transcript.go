@@ -0,0 +1,166 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Senders of a message in a transcript
+const (
+	FromAgent  = "agent"
+	FromServer = "server"
+)
+
+// Transcript is everything exchanged over a single AttestAgent stream, as seen by the server
+type Transcript struct {
+	Scenario string    `json:"scenario"`
+	Messages []Message `json:"messages"`
+
+	// Code and Status are the gRPC status the server ended the stream with
+	Code   codes.Code `json:"code"`
+	Status string     `json:"status,omitempty"`
+}
+
+// Message is a single AttestAgentRequest or AttestAgentResponse encoded as protojson
+type Message struct {
+	From string `json:"from"`
+
+	// Elapsed is the time since the stream was opened
+	Elapsed time.Duration   `json:"elapsed_ns"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Request decodes a message sent by the agent
+func (m Message) Request() (*agent.AttestAgentRequest, error) {
+	if m.From != FromAgent {
+		return nil, fmt.Errorf("message was sent by the %s", m.From)
+	}
+	req := &agent.AttestAgentRequest{}
+	if err := protojson.Unmarshal(m.Data, req); err != nil {
+		return nil, fmt.Errorf("failed to decode agent message: %v", err)
+	}
+	return req, nil
+}
+
+// Response decodes a message sent by the server
+func (m Message) Response() (*agent.AttestAgentResponse, error) {
+	if m.From != FromServer {
+		return nil, fmt.Errorf("message was sent by the %s", m.From)
+	}
+	resp := &agent.AttestAgentResponse{}
+	if err := protojson.Unmarshal(m.Data, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode server message: %v", err)
+	}
+	return resp, nil
+}
+
+// LoadTranscript reads a transcript saved by Save
+func LoadTranscript(path string) (*Transcript, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transcript{}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("failed to parse transcript %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Save writes the transcript to path as indented JSON
+func (t *Transcript) Save(path string) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// recorder is a stream interceptor that records a transcript of every AttestAgent stream
+type recorder struct {
+	transcripts chan *Transcript
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		transcripts: make(chan *Transcript, 1),
+	}
+}
+
+func (r *recorder) intercept(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	rs := &recordingStream{
+		ServerStream: ss,
+		start:        time.Now(),
+	}
+
+	err := handler(srv, rs)
+
+	rs.mu.Lock()
+	t := &Transcript{
+		Messages: rs.messages,
+		Code:     status.Code(err),
+	}
+	rs.mu.Unlock()
+	if err != nil {
+		t.Status = status.Convert(err).Message()
+	}
+
+	// Only the first stream of a scenario is evaluated
+	select {
+	case r.transcripts <- t:
+	default:
+	}
+
+	return err
+}
+
+type recordingStream struct {
+	grpc.ServerStream
+	start time.Time
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *recordingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.record(FromAgent, m)
+	}
+	return err
+}
+
+func (s *recordingStream) SendMsg(m any) error {
+	s.record(FromServer, m)
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *recordingStream) record(from string, m any) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, Message{
+		From:    from,
+		Elapsed: time.Since(s.start),
+		Data:    data,
+	})
+}
@@ -0,0 +1,128 @@
+// Package federation exchanges trust bundles with the servers of other trust domains over the
+// SPIFFE bundle endpoint protocol, so that workloads of federated trust domains can authenticate
+// each other.
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// x509SVIDUse marks the keys of a bundle that are X.509 authorities
+const x509SVIDUse = "x509-svid"
+
+// Bundle is the trust bundle of a trust domain as served by its bundle endpoint
+type Bundle struct {
+	X509Authorities []*x509.Certificate
+
+	// RefreshHint is how often the bundle should be fetched again, zero if not set
+	RefreshHint time.Duration
+
+	// Sequence increases whenever the bundle changes, zero if not set
+	Sequence uint64
+}
+
+// document is the JWK set of the SPIFFE bundle format
+type document struct {
+	Keys        []jwk  `json:"keys"`
+	RefreshHint int64  `json:"spiffe_refresh_hint,omitempty"`
+	Sequence    uint64 `json:"spiffe_sequence,omitempty"`
+}
+
+type jwk struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c"`
+}
+
+// Marshal encodes the bundle as a SPIFFE bundle document
+func (b *Bundle) Marshal() ([]byte, error) {
+	doc := document{
+		Keys:        []jwk{},
+		RefreshHint: int64(b.RefreshHint / time.Second),
+		Sequence:    b.Sequence,
+	}
+	for _, cert := range b.X509Authorities {
+		key, err := newJWK(cert)
+		if err != nil {
+			return nil, err
+		}
+		doc.Keys = append(doc.Keys, key)
+	}
+	return json.Marshal(doc)
+}
+
+// ParseBundle decodes a SPIFFE bundle document, ignoring keys that are not X.509 authorities
+func ParseBundle(b []byte) (*Bundle, error) {
+	var doc document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %v", err)
+	}
+
+	bundle := &Bundle{
+		RefreshHint: time.Duration(doc.RefreshHint) * time.Second,
+		Sequence:    doc.Sequence,
+	}
+	for i, key := range doc.Keys {
+		if key.Use != x509SVIDUse {
+			continue
+		}
+		if len(key.X5c) != 1 {
+			return nil, fmt.Errorf("key %d: expected exactly one certificate, got %d", i, len(key.X5c))
+		}
+		der, err := base64.StdEncoding.DecodeString(key.X5c[0])
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		bundle.X509Authorities = append(bundle.X509Authorities, cert)
+	}
+	if len(bundle.X509Authorities) == 0 {
+		return nil, errors.New("bundle has no X.509 authorities")
+	}
+
+	return bundle, nil
+}
+
+func newJWK(cert *x509.Certificate) (jwk, error) {
+	key := jwk{
+		Use: x509SVIDUse,
+		X5c: []string{base64.StdEncoding.EncodeToString(cert.Raw)},
+	}
+
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		key.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() && pub.Curve != elliptic.P521() {
+			return jwk{}, fmt.Errorf("unsupported curve %s", key.Crv)
+		}
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	default:
+		return jwk{}, fmt.Errorf("unsupported authority key %T", cert.PublicKey)
+	}
+
+	return key, nil
+}
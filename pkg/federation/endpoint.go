@@ -0,0 +1,37 @@
+package federation
+
+import (
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Supported bundle endpoint profiles
+const (
+	// ProfileHTTPSWeb authenticates the bundle endpoint with a web PKI certificate
+	ProfileHTTPSWeb = "https_web"
+
+	// ProfileHTTPSSPIFFE authenticates the bundle endpoint with an X509-SVID of its trust domain
+	ProfileHTTPSSPIFFE = "https_spiffe"
+)
+
+// Handler serves the X.509 authorities returned by authorities as a SPIFFE bundle document
+func Handler(authorities func() []*x509.Certificate, refreshHint time.Duration, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := (&Bundle{X509Authorities: authorities(), RefreshHint: refreshHint}).Marshal()
+		if err != nil {
+			logger.Error("failed to encode bundle", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
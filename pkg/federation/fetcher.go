@@ -0,0 +1,300 @@
+package federation
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+)
+
+const (
+	// defaultRefreshInterval is how often bundles are fetched if their endpoint gives no hint
+	defaultRefreshInterval = 5 * time.Minute
+
+	// minRefreshInterval keeps misconfigured endpoints from being fetched in a tight loop
+	minRefreshInterval = 10 * time.Second
+
+	// maxBundleSize is the largest bundle document accepted from an endpoint
+	maxBundleSize = 1 << 20
+
+	fetchTimeout = 30 * time.Second
+)
+
+// Peer is a trust domain the server federates with
+type Peer struct {
+	TrustDomain string
+
+	// URL of the bundle endpoint of the trust domain
+	URL string
+
+	// Profile is https_web or https_spiffe
+	Profile string
+
+	// EndpointSPIFFEID is the SPIFFE ID the https_spiffe bundle endpoint must present
+	EndpointSPIFFEID string
+
+	// Bundle authenticates the first fetch from an https_spiffe endpoint, every later fetch is
+	// authenticated by the previously fetched bundle. For https_web it replaces the system roots.
+	Bundle []*x509.Certificate
+}
+
+// Fetcher periodically fetches and caches the bundles of its peers
+type Fetcher struct {
+	peers    []Peer
+	interval time.Duration
+	cacheDir string
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	bundles map[string]*Bundle
+}
+
+// NewFetcher validates the peers and loads the bundles cached in cacheDir by a previous run.
+// Endpoints that do not hint how often to fetch them are fetched every interval, which defaults
+// to 5 minutes. Fetched bundles are only kept in memory if cacheDir is empty.
+func NewFetcher(peers []Peer, interval time.Duration, cacheDir string, logger *slog.Logger) (*Fetcher, error) {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	f := &Fetcher{
+		peers:    peers,
+		interval: interval,
+		cacheDir: cacheDir,
+		logger:   logger,
+		bundles:  map[string]*Bundle{},
+	}
+
+	seen := map[string]bool{}
+	for _, p := range peers {
+		if id, err := common.ParseSPIFFEID("spiffe://" + p.TrustDomain); err != nil || id.Host != p.TrustDomain {
+			return nil, fmt.Errorf("invalid trust domain %q", p.TrustDomain)
+		}
+		if seen[p.TrustDomain] {
+			return nil, fmt.Errorf("trust domain %s is configured more than once", p.TrustDomain)
+		}
+		seen[p.TrustDomain] = true
+
+		if p.URL == "" {
+			return nil, fmt.Errorf("trust domain %s: bundle endpoint URL is required", p.TrustDomain)
+		}
+		switch p.Profile {
+		case ProfileHTTPSWeb:
+		case ProfileHTTPSSPIFFE:
+			if _, err := common.ParseSPIFFEID(p.EndpointSPIFFEID); err != nil {
+				return nil, fmt.Errorf("trust domain %s: invalid endpoint SPIFFE ID: %v", p.TrustDomain, err)
+			}
+		default:
+			return nil, fmt.Errorf("trust domain %s: unsupported profile %q", p.TrustDomain, p.Profile)
+		}
+
+		cached, err := f.loadCached(p.TrustDomain)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			f.bundles[p.TrustDomain] = cached
+		} else if p.Profile == ProfileHTTPSSPIFFE && len(p.Bundle) == 0 {
+			return nil, fmt.Errorf("trust domain %s: an initial bundle is required by the https_spiffe profile", p.TrustDomain)
+		}
+	}
+
+	return f, nil
+}
+
+// Bundles returns the X.509 authorities of every peer whose bundle was fetched, by trust domain
+func (f *Fetcher) Bundles() map[string][]*x509.Certificate {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	bundles := make(map[string][]*x509.Certificate, len(f.bundles))
+	for td, b := range f.bundles {
+		bundles[td] = b.X509Authorities
+	}
+	return bundles
+}
+
+// Run fetches the bundle of every peer until ctx is cancelled, as often as its endpoint hints
+// or every interval. A failed fetch is retried after a tenth of the interval and the previously
+// fetched bundle is kept.
+func (f *Fetcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range f.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				next, err := f.Fetch(ctx, p.TrustDomain)
+				if err != nil {
+					f.logger.Warn("failed to fetch federated bundle", "trust_domain", p.TrustDomain, "url", p.URL, "error", err)
+					next = max(f.interval/10, minRefreshInterval)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(next):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Fetch fetches the bundle of the trust domain once and returns when it should be fetched again
+func (f *Fetcher) Fetch(ctx context.Context, trustDomain string) (time.Duration, error) {
+	var peer *Peer
+	for i := range f.peers {
+		if f.peers[i].TrustDomain == trustDomain {
+			peer = &f.peers[i]
+		}
+	}
+	if peer == nil {
+		return 0, fmt.Errorf("trust domain %s is not federated", trustDomain)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	httpClient, err := f.httpClient(peer)
+	if err != nil {
+		return 0, err
+	}
+	defer httpClient.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize))
+	if err != nil {
+		return 0, err
+	}
+	bundle, err := ParseBundle(b)
+	if err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	prev := f.bundles[trustDomain]
+	f.bundles[trustDomain] = bundle
+	f.mu.Unlock()
+
+	if prev == nil || prev.Sequence != bundle.Sequence || !sameAuthorities(prev.X509Authorities, bundle.X509Authorities) {
+		f.logger.Info("fetched federated bundle", "trust_domain", trustDomain, "authorities", len(bundle.X509Authorities))
+		if err := f.saveCached(trustDomain, b); err != nil {
+			f.logger.Warn("failed to cache federated bundle", "trust_domain", trustDomain, "error", err)
+		}
+	}
+
+	if bundle.RefreshHint > 0 {
+		return max(bundle.RefreshHint, minRefreshInterval), nil
+	}
+	return f.interval, nil
+}
+
+// httpClient authenticates the bundle endpoint of peer according to its profile
+func (f *Fetcher) httpClient(peer *Peer) (*http.Client, error) {
+	pin := client.Pin{Bundle: peer.Bundle}
+	if peer.Profile == ProfileHTTPSSPIFFE {
+		f.mu.RLock()
+		if current, ok := f.bundles[peer.TrustDomain]; ok {
+			pin.Bundle = current.X509Authorities
+		}
+		f.mu.RUnlock()
+
+		id, err := common.ParseSPIFFEID(peer.EndpointSPIFFEID)
+		if err != nil {
+			return nil, err
+		}
+		pin.ServerID = id
+	}
+
+	tlsConfig, err := pin.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+func (f *Fetcher) loadCached(trustDomain string) (*Bundle, error) {
+	if f.cacheDir == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(f.cachePath(trustDomain))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := ParseBundle(b)
+	if err != nil {
+		return nil, fmt.Errorf("cached bundle of %s: %v", trustDomain, err)
+	}
+	return bundle, nil
+}
+
+func (f *Fetcher) saveCached(trustDomain string, b []byte) error {
+	if f.cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(f.cacheDir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.cacheDir, ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.cachePath(trustDomain))
+}
+
+func (f *Fetcher) cachePath(trustDomain string) string {
+	return filepath.Join(f.cacheDir, trustDomain+".json")
+}
+
+func sameAuthorities(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
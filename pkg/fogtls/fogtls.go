@@ -0,0 +1,197 @@
+// Package fogtls builds mutual TLS configurations for services authenticating with the
+// X509-SVIDs issued by spiffe_fog. Certificates and trust bundles are read from a Source on
+// every handshake, so that rotated X509-SVIDs are picked up without rebuilding the configuration.
+package fogtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Authorizer decides whether the peer presenting an X509-SVID for id may connect, returning
+// why not otherwise. It is only called after the X509-SVID was verified against the bundle.
+type Authorizer func(id *url.URL) error
+
+// AuthorizeAny accepts every peer with an X509-SVID from the trust bundle
+func AuthorizeAny() Authorizer {
+	return func(*url.URL) error {
+		return nil
+	}
+}
+
+// AuthorizeID only accepts peers with one of the SPIFFE IDs
+func AuthorizeID(ids ...string) Authorizer {
+	return func(id *url.URL) error {
+		if slices.Contains(ids, id.String()) {
+			return nil
+		}
+		return fmt.Errorf("unexpected SPIFFE ID %s", id)
+	}
+}
+
+// AuthorizeMemberOf accepts every peer with a SPIFFE ID in the trust domain
+func AuthorizeMemberOf(trustDomain string) Authorizer {
+	return func(id *url.URL) error {
+		if id.Host == trustDomain {
+			return nil
+		}
+		return fmt.Errorf("SPIFFE ID %s is not a member of trust domain %s", id, trustDomain)
+	}
+}
+
+// AuthorizeIf accepts the peers allowed returns true for, e.g. to look SPIFFE IDs up in an
+// allow list that changes at runtime
+func AuthorizeIf(allowed func(id *url.URL) bool) Authorizer {
+	return func(id *url.URL) error {
+		if allowed(id) {
+			return nil
+		}
+		return fmt.Errorf("SPIFFE ID %s is not allowed", id)
+	}
+}
+
+// ServerConfig returns the configuration of a server presenting the X509-SVID of src, which
+// requires clients to present an X509-SVID from a bundle of src that authorize accepts
+func ServerConfig(src Source, authorize Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The chain is verified against the current bundle of src by VerifyPeerCertificate
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate(src)
+		},
+		VerifyPeerCertificate: verifyPeer(src, authorize, x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// ClientConfig returns the configuration of a client presenting the X509-SVID of src, which
+// only connects to servers presenting an X509-SVID from a bundle of src that authorize accepts
+func ClientConfig(src Source, authorize Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Hostname verification is replaced by the SPIFFE ID checks of VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certificate(src)
+		},
+		VerifyPeerCertificate: verifyPeer(src, authorize, x509.ExtKeyUsageServerAuth),
+	}
+}
+
+// ServerCredentials returns gRPC server credentials using ServerConfig
+func ServerCredentials(src Source, authorize Authorizer) credentials.TransportCredentials {
+	return credentials.NewTLS(ServerConfig(src, authorize))
+}
+
+// ClientCredentials returns gRPC client credentials using ClientConfig
+func ClientCredentials(src Source, authorize Authorizer) credentials.TransportCredentials {
+	return credentials.NewTLS(ClientConfig(src, authorize))
+}
+
+// PeerID returns the SPIFFE ID of the peer of a gRPC call made over ServerCredentials or
+// ClientCredentials
+func PeerID(ctx context.Context) (*url.URL, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	id, err := spiffeID(info.State.PeerCertificates[0])
+	if err != nil {
+		return nil, false
+	}
+	return id, true
+}
+
+func certificate(src Source) (*tls.Certificate, error) {
+	svid, err := src.X509SVID()
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		PrivateKey: svid.PrivateKey,
+		Leaf:       svid.Certificates[0],
+	}
+	for _, c := range svid.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// verifyPeer verifies the peer's chain against the current bundle of its trust domain and authorizes
+// its SPIFFE ID
+func verifyPeer(src Source, authorize Authorizer, usage x509.ExtKeyUsage) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer did not present a certificate")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse peer certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+
+		id, err := spiffeID(certs[0])
+		if err != nil {
+			return err
+		}
+		bundle, err := src.Bundle(id.Host)
+		if err != nil {
+			return err
+		}
+		roots := x509.NewCertPool()
+		for _, cert := range bundle {
+			roots.AddCert(cert)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		}); err != nil {
+			return fmt.Errorf("failed to verify peer X509-SVID: %v", err)
+		}
+
+		if err := authorize(id); err != nil {
+			return fmt.Errorf("peer is not authorized: %v", err)
+		}
+		return nil
+	}
+}
+
+// spiffeID returns the SPIFFE ID of an X509-SVID, which must have exactly one
+func spiffeID(cert *x509.Certificate) (*url.URL, error) {
+	var id *url.URL
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if id != nil {
+			return nil, errors.New("X509-SVID has more than one SPIFFE ID")
+		}
+		id = uri
+	}
+	if id == nil {
+		return nil, errors.New("X509-SVID has no SPIFFE ID")
+	}
+	return id, nil
+}
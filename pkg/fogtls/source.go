@@ -0,0 +1,311 @@
+package fogtls
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	workloadapi "github.com/mjlshen/spiffe_fog/proto/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// workloadAPIRetryInterval is how long WorkloadAPISource waits before reconnecting to the agent
+const workloadAPIRetryInterval = time.Second
+
+// Source provides the X509-SVID a service authenticates with and the bundles of the trust domains
+// it accepts peers from. It is called on every handshake and must return the current ones, so that
+// rotations take effect.
+type Source interface {
+	X509SVID() (*client.SVID, error)
+
+	// Bundle returns the X.509 authorities of the trust domain of the service or of a trust
+	// domain it federates with
+	Bundle(trustDomain string) ([]*x509.Certificate, error)
+}
+
+// FileSource reads the svid.pem, svid_key.pem and bundle.pem files the agent writes with
+// -output-dir, reloading them whenever they were replaced
+type FileSource struct {
+	dir string
+
+	mu sync.Mutex
+	// svidInfo and bundleInfo describe the loaded files, which the agent replaces by renaming
+	svidInfo   os.FileInfo
+	bundleInfo os.FileInfo
+	svid       *client.SVID
+	bundle     []*x509.Certificate
+}
+
+// NewFileSource loads the X509-SVID and bundle in dir, which must already exist
+func NewFileSource(dir string) (*FileSource, error) {
+	s := &FileSource{dir: dir}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSource) X509SVID() (*client.SVID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The loaded files are kept if reloading fails, e.g. when the key was already replaced but
+	// the certificate not yet
+	_ = s.reload()
+	return s.svid, nil
+}
+
+// Bundle only returns the bundle of the trust domain of the X509-SVID, federated bundles are not
+// written to files
+func (s *FileSource) Bundle(trustDomain string) ([]*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.reload()
+	if !inTrustDomain(s.svid, trustDomain) {
+		return nil, fmt.Errorf("no bundle for trust domain %s", trustDomain)
+	}
+	return s.bundle, nil
+}
+
+func (s *FileSource) reload() error {
+	svidPath := filepath.Join(s.dir, client.SVIDFileName)
+	bundlePath := filepath.Join(s.dir, client.BundleFileName)
+
+	svidInfo, err := os.Stat(svidPath)
+	if err != nil {
+		return err
+	}
+	bundleInfo, err := os.Stat(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	if changed(s.svidInfo, svidInfo) {
+		pair, err := tls.LoadX509KeyPair(svidPath, filepath.Join(s.dir, client.SVIDKeyFileName))
+		if err != nil {
+			return fmt.Errorf("failed to load X509-SVID: %v", err)
+		}
+		var certs []*x509.Certificate
+		for _, der := range pair.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("failed to parse X509-SVID: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+		svid, err := newSVID(certs, pair.PrivateKey)
+		if err != nil {
+			return err
+		}
+		s.svid = svid
+		s.svidInfo = svidInfo
+	}
+
+	if changed(s.bundleInfo, bundleInfo) {
+		bundle, err := common.LoadBundle(bundlePath)
+		if err != nil {
+			return fmt.Errorf("failed to load bundle: %v", err)
+		}
+		s.bundle = bundle
+		s.bundleInfo = bundleInfo
+	}
+
+	return nil
+}
+
+func changed(prev, next os.FileInfo) bool {
+	return prev == nil || !os.SameFile(prev, next) || !prev.ModTime().Equal(next.ModTime())
+}
+
+// WorkloadAPISource streams the X509-SVID and bundles from the Workload API served by the agent
+// with -socket. It keeps the last ones received while reconnecting after the agent restarted.
+type WorkloadAPISource struct {
+	conn   *grpc.ClientConn
+	id     string
+	cancel context.CancelFunc
+
+	mu        sync.RWMutex
+	svid      *client.SVID
+	bundle    []*x509.Certificate
+	federated map[string][]*x509.Certificate
+}
+
+// NewWorkloadAPISource connects to the Workload API at socket and waits for the first X509-SVID.
+// It uses the X509-SVID for id, or the first one handed to the process if id is empty.
+func NewWorkloadAPISource(ctx context.Context, socket, id string) (*WorkloadAPISource, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	s := &WorkloadAPISource{
+		conn:   conn,
+		id:     id,
+		cancel: cancel,
+	}
+
+	first := make(chan error, 1)
+	go s.run(streamCtx, first)
+
+	select {
+	case err := <-first:
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	case <-ctx.Done():
+		s.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *WorkloadAPISource) X509SVID() (*client.SVID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.svid, nil
+}
+
+func (s *WorkloadAPISource) Bundle(trustDomain string) ([]*x509.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if inTrustDomain(s.svid, trustDomain) {
+		return s.bundle, nil
+	}
+	if bundle, ok := s.federated[trustDomain]; ok {
+		return bundle, nil
+	}
+	return nil, fmt.Errorf("no bundle for trust domain %s", trustDomain)
+}
+
+// Close stops streaming updates from the agent
+func (s *WorkloadAPISource) Close() error {
+	s.cancel()
+	return s.conn.Close()
+}
+
+// run streams updates until ctx is cancelled, reporting the outcome of the first response to first
+func (s *WorkloadAPISource) run(ctx context.Context, first chan<- error) {
+	// The Workload API rejects requests without the security header
+	ctx = metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+	for {
+		err := s.stream(ctx, &first)
+		if ctx.Err() != nil {
+			return
+		}
+		if first != nil {
+			first <- err
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(workloadAPIRetryInterval):
+		}
+	}
+}
+
+func (s *WorkloadAPISource) stream(ctx context.Context, first *chan<- error) error {
+	stream, err := workloadapi.NewSpiffeWorkloadAPIClient(s.conn).FetchX509SVID(ctx, &workloadapi.X509SVIDRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := s.update(resp); err != nil {
+			return err
+		}
+		if *first != nil {
+			*first <- nil
+			*first = nil
+		}
+	}
+}
+
+func (s *WorkloadAPISource) update(resp *workloadapi.X509SVIDResponse) error {
+	for _, svid := range resp.GetSvids() {
+		if s.id != "" && svid.GetSpiffeId() != s.id {
+			continue
+		}
+
+		certs, err := x509.ParseCertificates(svid.GetX509Svid())
+		if err != nil {
+			return fmt.Errorf("failed to parse X509-SVID: %v", err)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(svid.GetX509SvidKey())
+		if err != nil {
+			return fmt.Errorf("failed to parse X509-SVID key: %v", err)
+		}
+		bundle, err := x509.ParseCertificates(svid.GetBundle())
+		if err != nil {
+			return fmt.Errorf("failed to parse bundle: %v", err)
+		}
+		parsed, err := newSVID(certs, key)
+		if err != nil {
+			return err
+		}
+		federated := map[string][]*x509.Certificate{}
+		for id, der := range resp.GetFederatedBundles() {
+			certs, err := x509.ParseCertificates(der)
+			if err != nil {
+				return fmt.Errorf("failed to parse federated bundle of %s: %v", id, err)
+			}
+			federated[strings.TrimPrefix(id, "spiffe://")] = certs
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.svid = parsed
+		s.bundle = bundle
+		s.federated = federated
+		return nil
+	}
+
+	if s.id != "" {
+		return fmt.Errorf("no X509-SVID for %s", s.id)
+	}
+	return errors.New("no X509-SVID")
+}
+
+func inTrustDomain(svid *client.SVID, trustDomain string) bool {
+	return svid != nil && strings.HasPrefix(svid.ID, "spiffe://"+trustDomain+"/")
+}
+
+func newSVID(certs []*x509.Certificate, key crypto.PrivateKey) (*client.SVID, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("X509-SVID key cannot sign")
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("empty X509-SVID")
+	}
+
+	svid := &client.SVID{
+		Certificates: certs,
+		PrivateKey:   signer,
+	}
+
+	id, err := spiffeID(svid.Certificates[0])
+	if err != nil {
+		return nil, err
+	}
+	svid.ID = id.String()
+	return svid, nil
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AKStore remembers the AK activated by each EK, so that later attestations of the node can
+// prove possession of the known AK with a quote instead of activating a new one.
+type AKStore interface {
+	// FetchAK returns the TPMT_PUBLIC area of the AK activated by the EK, or ErrNotFound
+	FetchAK(ctx context.Context, ekHash string) ([]byte, error)
+
+	// StoreAK remembers the AK activated by the EK, replacing any previous one
+	StoreAK(ctx context.Context, ekHash string, akPublic []byte) error
+}
+
+// MemoryAKStore is an AKStore that forgets every AK when the server restarts
+type MemoryAKStore struct {
+	mu  sync.RWMutex
+	aks map[string][]byte
+}
+
+func NewMemoryAKStore() *MemoryAKStore {
+	return &MemoryAKStore{
+		aks: map[string][]byte{},
+	}
+}
+
+func (m *MemoryAKStore) FetchAK(_ context.Context, ekHash string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ak, ok := m.aks[ekHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ak, nil
+}
+
+func (m *MemoryAKStore) StoreAK(_ context.Context, ekHash string, akPublic []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aks[ekHash] = append([]byte(nil), akPublic...)
+	return nil
+}
+
+// FileAKStore is an AKStore saved as a JSON object of EK hashes to base64 encoded AK public areas
+type FileAKStore struct {
+	mu   sync.Mutex
+	path string
+	aks  map[string][]byte
+}
+
+// NewFileAKStore loads the AKs saved at path, which does not need to exist yet
+func NewFileAKStore(path string) (*FileAKStore, error) {
+	s := &FileAKStore{
+		path: path,
+		aks:  map[string][]byte{},
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.aks); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileAKStore) FetchAK(_ context.Context, ekHash string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ak, ok := s.aks[ekHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ak, nil
+}
+
+// StoreAK remembers the AK and rewrites the file, replacing it atomically
+func (s *FileAKStore) StoreAK(_ context.Context, ekHash string, akPublic []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.aks[ekHash]
+	s.aks[ekHash] = append([]byte(nil), akPublic...)
+	if err := s.save(); err != nil {
+		if existed {
+			s.aks[ekHash] = prev
+		} else {
+			delete(s.aks, ekHash)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileAKStore) save() error {
+	b, err := json.MarshalIndent(s.aks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".aks-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
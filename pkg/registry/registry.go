@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when a node or entry is not present in a Store.
+var ErrNotFound = errors.New("not found")
+
+// Selector is a single property of a node or workload, e.g. tpm:ek_hash:<hash>.
+// The type is the name of the attestor that produced it and the value is
+// opaque to everything but that attestor.
+type Selector struct {
+	Type  string
+	Value string
+}
+
+func (s Selector) String() string {
+	return s.Type + ":" + s.Value
+}
+
+// ParseSelector parses a selector in the form type:value
+func ParseSelector(s string) (Selector, error) {
+	typ, value, ok := strings.Cut(s, ":")
+	if !ok || typ == "" || value == "" {
+		return Selector{}, fmt.Errorf("invalid selector %q: expected type:value", s)
+	}
+	return Selector{Type: typ, Value: value}, nil
+}
+
+// Node is a TPM that is allowed to attest, identified by the sha256 hash of its EK.
+type Node struct {
+	EKHash string
+
+	// Selectors are handed to the node in addition to the ones produced by
+	// attestation, e.g. cloud instance tags that were recorded at registration.
+	Selectors []Selector
+}
+
+// Entry maps a set of selectors to a SPIFFE ID. A node is entitled to the
+// SPIFFE ID of every entry whose selectors are all satisfied by the node.
+type Entry struct {
+	ID        string
+	SPIFFEID  string
+	Selectors []Selector
+
+	// WorkloadSelectors must all be presented by a workload on the node before
+	// the agent hands it the X509-SVID. Entries without any are only used by
+	// the agent itself.
+	WorkloadSelectors []Selector
+}
+
+// IsSubset returns true if subset is non-empty and every selector in it is present in set.
+func IsSubset(subset, set []Selector) bool {
+	if len(subset) == 0 {
+		return false
+	}
+
+	lookup := make(map[Selector]struct{}, len(set))
+	for _, s := range set {
+		lookup[s] = struct{}{}
+	}
+	for _, s := range subset {
+		if _, ok := lookup[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Store is a source of registered nodes and registration entries
+type Store interface {
+	// FetchNode returns the node registered with the EK hash, or ErrNotFound.
+	FetchNode(ctx context.Context, ekHash string) (*Node, error)
+
+	// ListEntries returns all registration entries.
+	ListEntries(ctx context.Context) ([]Entry, error)
+}
+
+// MemoryStore is a Store kept entirely in memory
+type MemoryStore struct {
+	mu      sync.RWMutex
+	nodes   map[string]Node
+	entries []Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes: map[string]Node{},
+	}
+}
+
+// AddNode registers a node, replacing any node with the same EK hash.
+func (m *MemoryStore) AddNode(n Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[n.EKHash] = n
+}
+
+// AddEntry adds a registration entry. Entries without an ID are assigned one.
+func (m *MemoryStore) AddEntry(e Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.ID == "" {
+		e.ID = fmt.Sprintf("entry-%d", len(m.entries)+1)
+	}
+	m.entries = append(m.entries, e)
+}
+
+func (m *MemoryStore) FetchNode(_ context.Context, ekHash string) (*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.nodes[ekHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &n, nil
+}
+
+func (m *MemoryStore) ListEntries(_ context.Context) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Entry(nil), m.entries...), nil
+}
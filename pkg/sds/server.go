@@ -0,0 +1,313 @@
+// Package sds serves the X509-SVIDs and trust bundle received by the agent to Envoy over the
+// Secret Discovery Service (v3).
+package sds
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// SecretTypeURL is the type of every resource served by the Server
+const SecretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// Secret names served besides the SPIFFE IDs of the X509-SVIDs and the trust domain, matching
+// the names used by SPIRE so that existing Envoy configurations keep working
+const (
+	// DefaultSVIDName is the tls_certificate of the agent X509-SVID
+	DefaultSVIDName = "default"
+
+	// DefaultBundleName is the validation_context of the trust bundle
+	DefaultBundleName = "ROOTCA"
+)
+
+// Server implements the Secret Discovery Service, handing Envoy the X509-SVIDs whose selectors
+// are satisfied by the attested Envoy process as tls_certificate secrets named by their SPIFFE
+// IDs, and the trust bundle and federated bundles as validation_contexts named by their trust
+// domains. Secrets are pushed again whenever the cache is updated, e.g. after the agent renewed
+// its X509-SVID.
+type Server struct {
+	secretv3.UnimplementedSecretDiscoveryServiceServer
+
+	cache     *workload.Cache
+	attestors []workload.Attestor
+
+	// agentSelectors must be satisfied by a caller to receive the agent X509-SVID and its key
+	agentSelectors []registry.Selector
+}
+
+// NewServer creates a Server that also serves the agent X509-SVID, by its SPIFFE ID and as
+// default, to callers satisfying agentSelectors, e.g. the uid of Envoy. Without agentSelectors
+// the agent X509-SVID is not served, since its key must not be handed to any local process.
+func NewServer(cache *workload.Cache, agentSelectors []registry.Selector, attestors ...workload.Attestor) *Server {
+	return &Server{
+		cache:          cache,
+		attestors:      attestors,
+		agentSelectors: agentSelectors,
+	}
+}
+
+// ListenAndServe serves SDS on a unix socket at path, replacing any stale socket
+func ListenAndServe(path string, srv *Server) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer(grpc.Creds(workload.Credentials()))
+	secretv3.RegisterSecretDiscoveryServiceServer(s, srv)
+	return s.Serve(listener)
+}
+
+// StreamSecrets implements the state of the world protocol. Every request is answered with
+// the requested secrets unless Envoy already has their current version, and rejected updates
+// are only logged, as resending them would be rejected again.
+func (s *Server) StreamSecrets(stream secretv3.SecretDiscoveryService_StreamSecretsServer) error {
+	ctx := stream.Context()
+
+	selectors, err := s.attest(ctx)
+	if err != nil {
+		return err
+	}
+
+	requests := make(chan *discoveryv3.DiscoveryRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		names             []string
+		current, lastSent string
+		nonce             int
+	)
+	result, changed := s.cache.Result()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case req := <-requests:
+			if req.GetTypeUrl() != "" && req.GetTypeUrl() != SecretTypeURL {
+				return status.Errorf(codes.InvalidArgument, "unsupported type %s", req.GetTypeUrl())
+			}
+			// Requests answering an older response are superseded by the one in flight
+			if n := req.GetResponseNonce(); n != "" && n != strconv.Itoa(nonce) {
+				continue
+			}
+			if detail := req.GetErrorDetail(); detail != nil {
+				slog.Warn("envoy rejected secrets", "version", lastSent, "error", detail.GetMessage())
+				continue
+			}
+			names = req.GetResourceNames()
+			current = req.GetVersionInfo()
+		case <-changed:
+			result, changed = s.cache.Result()
+			if nonce == 0 {
+				continue
+			}
+			current = lastSent
+		}
+
+		resp, err := response(result, selectors, s.agentSelectors, names)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to build response: %v", err)
+		}
+		if resp.GetVersionInfo() == current {
+			continue
+		}
+
+		nonce++
+		resp.Nonce = strconv.Itoa(nonce)
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		lastSent = resp.GetVersionInfo()
+	}
+}
+
+func (s *Server) FetchSecrets(ctx context.Context, req *discoveryv3.DiscoveryRequest) (*discoveryv3.DiscoveryResponse, error) {
+	selectors, err := s.attest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, _ := s.cache.Result()
+	resp, err := response(result, selectors, s.agentSelectors, req.GetResourceNames())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to build response: %v", err)
+	}
+	return resp, nil
+}
+
+// attest returns the selectors of the Envoy process on the other end of the connection
+func (s *Server) attest(ctx context.Context) ([]registry.Selector, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Internal, "missing peer information")
+	}
+	info, ok := p.AuthInfo.(workload.AuthInfo)
+	if !ok {
+		return nil, status.Error(codes.Internal, "missing peer credentials")
+	}
+
+	selectors, err := workload.Attest(ctx, s.attestors, info.PeerCred)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "workload attestation failed: %v", err)
+	}
+	return selectors, nil
+}
+
+// response returns the secrets named by names, or every secret if names is empty. Its version
+// is derived from the secrets, so that it only changes when they do.
+func response(result *client.Result, selectors, agentSelectors []registry.Selector, names []string) (*discoveryv3.DiscoveryResponse, error) {
+	secrets, err := secrets(result, selectors, agentSelectors)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &discoveryv3.DiscoveryResponse{
+		TypeUrl: SecretTypeURL,
+	}
+	hash := sha256.New()
+	for _, secret := range secrets {
+		if len(names) > 0 && !slices.Contains(names, secret.GetName()) {
+			continue
+		}
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(secret)
+		if err != nil {
+			return nil, err
+		}
+		hash.Write(b)
+		resp.Resources = append(resp.Resources, &anypb.Any{TypeUrl: SecretTypeURL, Value: b})
+	}
+	resp.VersionInfo = hex.EncodeToString(hash.Sum(nil))[:16]
+
+	return resp, nil
+}
+
+// secrets returns the trust bundle, the federated bundles and every X509-SVID the caller
+// identified by selectors may receive, including the agent X509-SVID if it satisfies agentSelectors
+func secrets(result *client.Result, selectors, agentSelectors []registry.Selector) ([]*tlsv3.Secret, error) {
+	if result == nil {
+		return nil, nil
+	}
+
+	agent := registry.IsSubset(agentSelectors, selectors)
+	var secrets []*tlsv3.Secret
+	for _, svid := range result.SVIDs {
+		if svid == result.SVID && !agent || svid != result.SVID && !registry.IsSubset(svid.Selectors, selectors) {
+			continue
+		}
+
+		cert, err := tlsCertificate(svid)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &tlsv3.Secret{
+			Name: svid.ID,
+			Type: &tlsv3.Secret_TlsCertificate{TlsCertificate: cert},
+		})
+		if svid == result.SVID {
+			secrets = append(secrets, &tlsv3.Secret{
+				Name: DefaultSVIDName,
+				Type: &tlsv3.Secret_TlsCertificate{TlsCertificate: cert},
+			})
+		}
+	}
+
+	id, err := common.ParseSPIFFEID(result.SVID.ID)
+	if err != nil {
+		return nil, err
+	}
+	validation := &tlsv3.CertificateValidationContext{
+		TrustedCa: inlineBytes(encodeCertificates(result.Bundle)),
+	}
+	for _, name := range []string{"spiffe://" + id.Host, DefaultBundleName} {
+		secrets = append(secrets, &tlsv3.Secret{
+			Name: name,
+			Type: &tlsv3.Secret_ValidationContext{ValidationContext: validation},
+		})
+	}
+	for _, td := range slices.Sorted(maps.Keys(result.FederatedBundles)) {
+		secrets = append(secrets, &tlsv3.Secret{
+			Name: "spiffe://" + td,
+			Type: &tlsv3.Secret_ValidationContext{ValidationContext: &tlsv3.CertificateValidationContext{
+				TrustedCa: inlineBytes(encodeCertificates(result.FederatedBundles[td])),
+			}},
+		})
+	}
+
+	return secrets, nil
+}
+
+func tlsCertificate(svid *client.SVID) (*tlsv3.TlsCertificate, error) {
+	key, err := x509.MarshalPKCS8PrivateKey(svid.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key for %s: %v", svid.ID, err)
+	}
+
+	return &tlsv3.TlsCertificate{
+		CertificateChain: inlineBytes(encodeCertificates(svid.Certificates)),
+		PrivateKey:       inlineBytes(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+	}, nil
+}
+
+func inlineBytes(b []byte) *corev3.DataSource {
+	return &corev3.DataSource{
+		Specifier: &corev3.DataSource_InlineBytes{InlineBytes: b},
+	}
+}
+
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var b []byte
+	for _, cert := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// akHashes are the name and signature hash algorithms accepted for AKs
+var akHashes = map[tpm2.Algorithm]bool{
+	tpm2.AlgSHA256: true,
+	tpm2.AlgSHA384: true,
+	tpm2.AlgSHA512: true,
+}
+
+// akLibraryReasons map the errors of go-attestation's AK checks to the reason they are rejected for.
+// Errors that are not listed are about the creation attestation.
+var akLibraryReasons = map[string]string{
+	"AK is exportable":                               reasonAKExportable,
+	"provided key is not limited to attestation":     reasonAKNotRestricted,
+	"creation attestation was not produced by a TPM": reasonAKNotTPMGenerated,
+	"attestation key too small":                      reasonAKAlgorithm,
+	"public key of alg":                              reasonAKAlgorithm,
+}
+
+// akError is an AK that cannot be trusted, with the reason it is rejected for
+type akError struct {
+	reason string
+	err    error
+}
+
+func (e *akError) Error() string {
+	return e.err.Error()
+}
+
+func akErrorf(reason, format string, args ...any) *akError {
+	return &akError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+// validateAK checks that the AK is a restricted signing-only key that was generated by the TPM and
+// can never leave it, using an acceptable algorithm, and that its creation was attested by the TPM.
+// Credential activation only proves that the AK is in the same TPM as the EK, so an AK without
+// these properties could sign anything the TPM did not produce, e.g. a forged quote.
+//
+// go-attestation checks the attributes and creation attestation when generating the activation
+// challenge, which is done up front to reject the AK before any round. It does not check that the
+// AK cannot decrypt, nor which hashes and schemes it uses.
+func validateAK(ek *attest.EK, ak *attest.AttestationParameters) *akError {
+	pub, err := tpm2.DecodePublic(ak.Public)
+	if err != nil {
+		return akErrorf(reasonAKMalformed, "malformed AK public area: %v", err)
+	}
+	if pub.Attributes&tpm2.FlagSign == 0 || pub.Attributes&tpm2.FlagDecrypt != 0 {
+		return akErrorf(reasonAKNotSigningOnly, "AK must be a signing-only key")
+	}
+	if err := checkAKAlgorithm(pub); err != nil {
+		return &akError{reason: reasonAKAlgorithm, err: err}
+	}
+
+	params := attest.ActivationParameters{
+		TPMVersion: attest.TPMVersion20,
+		EK:         ek.Public,
+		AK:         *ak,
+	}
+	if _, _, err := params.Generate(); err != nil {
+		return &akError{reason: akLibraryReason(err), err: err}
+	}
+	return nil
+}
+
+// akLibraryReason returns the reason go-attestation rejected an AK for
+func akLibraryReason(err error) string {
+	for prefix, reason := range akLibraryReasons {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return reason
+		}
+	}
+	return reasonAKCreation
+}
+
+// checkAKAlgorithm accepts RSA AKs with a fixed RSASSA scheme and SHA-2 name and signature hashes.
+// go-attestation only verifies the creation attestation of such keys, and checks their size.
+func checkAKAlgorithm(pub tpm2.Public) error {
+	if pub.Type != tpm2.AlgRSA || pub.RSAParameters == nil {
+		return fmt.Errorf("unsupported AK type %v", pub.Type)
+	}
+	if !akHashes[pub.NameAlg] {
+		return fmt.Errorf("unsupported AK name algorithm %v", pub.NameAlg)
+	}
+
+	// A restricted signing key must fix its scheme, otherwise it is chosen by whoever uses it
+	scheme := pub.RSAParameters.Sign
+	if scheme == nil {
+		return errors.New("AK has no signature scheme")
+	}
+	if scheme.Alg != tpm2.AlgRSASSA {
+		return fmt.Errorf("unsupported AK signature scheme %v", scheme.Alg)
+	}
+	if !akHashes[scheme.Hash] {
+		return fmt.Errorf("unsupported AK signature hash %v", scheme.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,508 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of challenges, each is issued in its own round
+const (
+	// ChallengeActivation proves that the AK is in the same TPM as the EK with TPM2_ActivateCredential.
+	// It must be the first round, the other challenges trust the AK.
+	ChallengeActivation = "activation"
+
+	// ChallengeQuote verifies a quote of the SHA256 PCR bank by the AK and adds a tpm_pcr selector
+	// for every PCR, e.g. tpm_pcr:sha256:7:<hex digest>
+	ChallengeQuote = "quote"
+
+	// ChallengeNonce proves that the agent holds the private key of its CSR at the time of attestation
+	ChallengeNonce = "nonce"
+
+	// ChallengeBinding binds the CSR to the AK with a quote over the hash of the CSR public key and a
+	// fresh nonce, so that a relayed attestation cannot be completed with another CSR. It is added
+	// last to every attestation of payload version 2 that is not configured with it.
+	ChallengeBinding = "binding"
+
+	// ChallengeDevID proves that the agent holds the DevID key at the time of attestation. It is
+	// added after the activation of tpm_devid attestations and cannot be configured.
+	ChallengeDevID = "devid"
+)
+
+var defaultChallenges = []string{ChallengeActivation}
+
+const (
+	defaultMaxRounds = 4
+
+	// nonceSize is the size of the nonces of quote and nonce challenges
+	nonceSize = 32
+
+	// pcrSelectorType is the type of the selectors added by quote challenges
+	pcrSelectorType = "tpm_pcr"
+)
+
+// challengers create the challenger of every kind of challenge
+var challengers = map[string]func() challenger{
+	ChallengeActivation: func() challenger { return &activationChallenger{} },
+	ChallengeQuote:      func() challenger { return &quoteChallenger{} },
+	ChallengeNonce:      func() challenger { return &nonceChallenger{} },
+	ChallengeBinding:    func() challenger { return &bindingChallenger{} },
+	ChallengeDevID:      func() challenger { return &devidChallenger{} },
+}
+
+// ValidateChallenges checks that challenges start with the only activation and fit in maxRounds,
+// along with the binding challenge if it is not configured
+func ValidateChallenges(challenges []string, maxRounds int) error {
+	if len(challenges) == 0 || challenges[0] != ChallengeActivation {
+		return fmt.Errorf("the first challenge must be %s", ChallengeActivation)
+	}
+	for _, c := range challenges[1:] {
+		if c == ChallengeActivation {
+			return fmt.Errorf("%s can only be the first challenge", ChallengeActivation)
+		}
+		if c == ChallengeDevID {
+			return fmt.Errorf("%s is added to %s attestations and cannot be configured", ChallengeDevID, AttestorTPMDevID)
+		}
+		if _, ok := challengers[c]; !ok {
+			return fmt.Errorf("unsupported challenge: %s", c)
+		}
+	}
+	if rounds := challengeRounds(challenges); rounds > maxRounds {
+		return fmt.Errorf("%d challenges exceed the maximum of %d rounds", rounds, maxRounds)
+	}
+	return nil
+}
+
+// challengeRounds returns the number of rounds of challenges, which always end with a binding
+func challengeRounds(challenges []string) int {
+	if slices.Contains(challenges, ChallengeBinding) {
+		return len(challenges)
+	}
+	return len(challenges) + 1
+}
+
+// attestation is what the rounds of a single attestation know about the agent
+type attestation struct {
+	version uint32
+	ek      *attest.EK
+	ak      attest.AttestationParameters
+	csr     *x509.CertificateRequest
+
+	// devid is the DevID certificate of tpm_devid attestations
+	devid *x509.Certificate
+
+	// selectors are added by challenges whose response has been verified
+	selectors []registry.Selector
+}
+
+// challenger issues a single round and verifies the agent's response to it
+type challenger interface {
+	issue(a *attestation) (*agent.Challenge, error)
+	verify(a *attestation, resp *agent.ChallengeResponse) error
+}
+
+// challengeKind returns the kind of the oneof of a challenge or a challenge response
+func challengeKind(kind any) string {
+	switch kind.(type) {
+	case *agent.Challenge_Activation, *agent.ChallengeResponse_Activation:
+		return ChallengeActivation
+	case *agent.Challenge_Quote, *agent.ChallengeResponse_Quote:
+		return ChallengeQuote
+	case *agent.Challenge_Nonce, *agent.ChallengeResponse_Nonce:
+		return ChallengeNonce
+	case *agent.Challenge_Binding, *agent.ChallengeResponse_Binding:
+		return ChallengeBinding
+	case *agent.Challenge_Devid, *agent.ChallengeResponse_Devid:
+		return ChallengeDevID
+	default:
+		return "unknown"
+	}
+}
+
+// plan returns the challenges of an attestation. A node presenting the AK it activated in an earlier
+// attestation proves possession of it with a quote instead of activating it again, in which case
+// reused is true. tpm_devid attestations prove possession of the DevID key right after the AK.
+func (s *Service) plan(ctx context.Context, sess *session, a *attestation, ekHash string) (challenges []string, reused bool) {
+	challenges = sess.settings.challenges
+	if a.version < common.PayloadVersionTyped {
+		return challenges, false
+	}
+	if a.devid != nil {
+		challenges = slices.Insert(slices.Clone(challenges), 1, ChallengeDevID)
+	}
+	if !slices.Contains(challenges, ChallengeBinding) {
+		challenges = append(slices.Clone(challenges), ChallengeBinding)
+	}
+	if s.akStore == nil {
+		return challenges, false
+	}
+
+	known, err := s.akStore.FetchAK(ctx, ekHash)
+	if err != nil {
+		if !errors.Is(err, registry.ErrNotFound) {
+			sess.logger.Warn("failed to fetch known AK", "error", err)
+		}
+		return challenges, false
+	}
+	if !bytes.Equal(known, a.ak.Public) {
+		return challenges, false
+	}
+
+	sess.logger.Info("reusing known AK")
+	challenges = challenges[1:]
+	if !slices.Contains(challenges, ChallengeQuote) {
+		challenges = append([]string{ChallengeQuote}, challenges...)
+	}
+	return challenges, true
+}
+
+// challenge runs a round for every challenge in order, all of which must be answered correctly
+// before anything is issued
+func (s *Service) challenge(ctx context.Context, stream agent.Agent_AttestAgentServer, sess *session, a *attestation, challenges []string) error {
+	for i, kind := range challenges {
+		round := uint32(i + 1)
+		if i >= sess.settings.maxRounds {
+			return status.Errorf(codes.Internal, "round %d exceeds the maximum of %d rounds", round, sess.settings.maxRounds)
+		}
+
+		c := challengers[kind]()
+		challenge, err := s.issueChallenge(ctx, c, a, kind)
+		if err != nil {
+			return err
+		}
+		challenge.Round = round
+
+		sess.logger.Info("sending attestation challenge", "step", "challenge", "round", round, "kind", kind)
+		resp, err := s.exchangeChallenge(ctx, stream, sess, a.version, challenge)
+		if err != nil {
+			return err
+		}
+
+		if err := s.verifyChallengeResponse(ctx, c, a, kind, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issueChallenge creates the challenge of a round
+func (s *Service) issueChallenge(ctx context.Context, c challenger, a *attestation, kind string) (challenge *agent.Challenge, err error) {
+	_, span := tracer.Start(ctx, "GenerateChallenge", trace.WithAttributes(attribute.String("spiffe_fog.challenge", kind)))
+	defer func() { telemetry.End(span, err) }()
+	defer s.metrics.observeStep(stepChallengeGeneration, time.Now())
+
+	challenge, err = c.issue(a)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate %s challenge: %v", kind, err)
+	}
+	return challenge, nil
+}
+
+// exchangeChallenge sends the challenge to the agent and waits for its response, which must answer
+// the same round and kind
+func (s *Service) exchangeChallenge(ctx context.Context, stream agent.Agent_AttestAgentServer, sess *session, version uint32, challenge *agent.Challenge) (resp *agent.ChallengeResponse, err error) {
+	ctx, span := tracer.Start(ctx, "ChallengeRoundTrip", trace.WithAttributes(attribute.Int("spiffe_fog.round", int(challenge.Round))))
+	defer func() { telemetry.End(span, err) }()
+	defer s.metrics.observeStep(stepClientRoundTrip, time.Now())
+
+	msg, err := encodeChallenge(version, challenge)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to marshal challenge: %v", err)
+	}
+	if err := stream.Send(msg); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send challenge: %v", err)
+	}
+
+	req, err := sess.recv(ctx, stream)
+	if err != nil {
+		return nil, recvError(err, codes.Internal, "failed to receive challenge response")
+	}
+
+	resp = decodeChallengeResponse(version, challenge.Round, req)
+	if resp == nil {
+		return nil, s.reject(reasonMalformedParams, status.Error(codes.InvalidArgument, "missing challenge response"))
+	}
+	if resp.Round != challenge.Round {
+		return nil, s.reject(reasonOutOfOrder, status.Errorf(codes.InvalidArgument, "challenge response is for round %d, expected round %d", resp.Round, challenge.Round))
+	}
+	if got, want := challengeKind(resp.Kind), challengeKind(challenge.Kind); got != want {
+		return nil, s.reject(reasonOutOfOrder, status.Errorf(codes.InvalidArgument, "expected a %s challenge response, got %s", want, got))
+	}
+
+	return resp, nil
+}
+
+// verifyChallengeResponse checks the response of a round
+func (s *Service) verifyChallengeResponse(ctx context.Context, c challenger, a *attestation, kind string, resp *agent.ChallengeResponse) (err error) {
+	_, span := tracer.Start(ctx, "VerifyChallengeResponse", trace.WithAttributes(attribute.String("spiffe_fog.challenge", kind)))
+	defer func() { telemetry.End(span, err) }()
+
+	if err := c.verify(a, resp); err != nil {
+		return s.reject(reasonChallengeMismatch, status.Error(codes.PermissionDenied, err.Error()))
+	}
+	return nil
+}
+
+// encodeChallenge wraps a challenge in a response. Payload versions before PayloadVersionTyped only
+// have a single activation challenge, sent as bytes.
+func encodeChallenge(version uint32, challenge *agent.Challenge) (*agent.AttestAgentResponse, error) {
+	if version >= common.PayloadVersionTyped {
+		return &agent.AttestAgentResponse{
+			Step: &agent.AttestAgentResponse_TypedChallenge{TypedChallenge: challenge},
+		}, nil
+	}
+
+	activation := challenge.GetActivation()
+	if activation == nil {
+		return nil, fmt.Errorf("payload version %d does not support %s challenges", version, challengeKind(challenge.Kind))
+	}
+	b, err := common.MarshalChallenge(version, &attest.EncryptedCredential{
+		Credential: activation.Credential,
+		Secret:     activation.Secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &agent.AttestAgentResponse{
+		Step: &agent.AttestAgentResponse_Challenge{Challenge: b},
+	}, nil
+}
+
+// decodeChallengeResponse unwraps the response to the challenge of round, returning nil if the
+// request does not carry one
+func decodeChallengeResponse(version, round uint32, req *agent.AttestAgentRequest) *agent.ChallengeResponse {
+	if version >= common.PayloadVersionTyped {
+		return req.GetTypedChallengeResponse()
+	}
+
+	secret := req.GetChallengeResponse()
+	if secret == nil {
+		return nil
+	}
+	return &agent.ChallengeResponse{
+		Round: round,
+		Kind: &agent.ChallengeResponse_Activation{
+			Activation: &agent.ActivationResponse{Secret: secret},
+		},
+	}
+}
+
+// activationChallenger challenges the agent to decrypt a secret that can only be recovered by the
+// TPM holding both the EK and the AK
+type activationChallenger struct {
+	secret []byte
+}
+
+func (c *activationChallenger) issue(a *attestation) (*agent.Challenge, error) {
+	ap := attest.ActivationParameters{
+		TPMVersion: attest.TPMVersion20,
+		EK:         a.ek.Public,
+		AK:         a.ak,
+	}
+
+	secret, ec, err := ap.Generate()
+	if err != nil {
+		return nil, err
+	}
+	c.secret = secret
+
+	return &agent.Challenge{
+		Kind: &agent.Challenge_Activation{
+			Activation: &agent.TPMActivationChallenge{
+				Version:    a.version,
+				Credential: ec.Credential,
+				Secret:     ec.Secret,
+			},
+		},
+	}, nil
+}
+
+func (c *activationChallenger) verify(_ *attestation, resp *agent.ChallengeResponse) error {
+	if subtle.ConstantTimeCompare(c.secret, resp.GetActivation().GetSecret()) == 0 {
+		return errors.New("challenge response does not match")
+	}
+	return nil
+}
+
+// quoteChallenger challenges the agent to quote its PCRs with the AK over a fresh nonce
+type quoteChallenger struct {
+	nonce []byte
+}
+
+func (c *quoteChallenger) issue(*attestation) (*agent.Challenge, error) {
+	c.nonce = make([]byte, nonceSize)
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, err
+	}
+
+	return &agent.Challenge{
+		Kind: &agent.Challenge_Quote{
+			Quote: &agent.QuoteChallenge{
+				Nonce:         c.nonce,
+				HashAlgorithm: uint32(attest.HashSHA256),
+			},
+		},
+	}, nil
+}
+
+func (c *quoteChallenger) verify(a *attestation, resp *agent.ChallengeResponse) error {
+	pcrs, err := verifyQuote(a, resp.GetQuote(), c.nonce)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pcrs {
+		a.selectors = append(a.selectors, registry.Selector{
+			Type:  pcrSelectorType,
+			Value: fmt.Sprintf("sha256:%d:%x", p.Index, p.Digest),
+		})
+	}
+	return nil
+}
+
+// verifyQuote checks that r is a quote of the SHA256 PCR bank by the AK over nonce, returning the
+// quoted PCRs
+func verifyQuote(a *attestation, r *agent.QuoteResponse, nonce []byte) ([]attest.PCR, error) {
+	ak, err := attest.ParseAKPublic(attest.TPMVersion20, a.ak.Public)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AK: %v", err)
+	}
+
+	pcrs := make([]attest.PCR, 0, len(r.GetPcrs()))
+	for _, p := range r.GetPcrs() {
+		pcrs = append(pcrs, attest.PCR{
+			Index:     int(p.Index),
+			Digest:    p.Digest,
+			DigestAlg: crypto.SHA256,
+		})
+	}
+
+	quote := attest.Quote{
+		Version:   attest.TPMVersion20,
+		Quote:     r.GetQuote(),
+		Signature: r.GetSignature(),
+	}
+	if err := ak.VerifyAll([]attest.Quote{quote}, pcrs, nonce); err != nil {
+		return nil, fmt.Errorf("invalid quote: %v", err)
+	}
+	return pcrs, nil
+}
+
+// bindingChallenger challenges the agent to quote its PCRs with the AK over the binding of its CSR
+// to a fresh nonce
+type bindingChallenger struct {
+	nonce []byte
+}
+
+func (c *bindingChallenger) issue(*attestation) (*agent.Challenge, error) {
+	c.nonce = make([]byte, nonceSize)
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, err
+	}
+
+	return &agent.Challenge{
+		Kind: &agent.Challenge_Binding{
+			Binding: &agent.BindingChallenge{Nonce: c.nonce},
+		},
+	}, nil
+}
+
+func (c *bindingChallenger) verify(a *attestation, resp *agent.ChallengeResponse) error {
+	if _, err := verifyQuote(a, resp.GetBinding(), common.CSRBinding(a.csr.RawSubjectPublicKeyInfo, c.nonce)); err != nil {
+		return fmt.Errorf("CSR is not bound to the AK: %v", err)
+	}
+	return nil
+}
+
+// nonceChallenger challenges the agent to sign a fresh nonce with the private key of its CSR
+type nonceChallenger struct {
+	nonce []byte
+}
+
+func (c *nonceChallenger) issue(*attestation) (*agent.Challenge, error) {
+	c.nonce = make([]byte, nonceSize)
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, err
+	}
+
+	return &agent.Challenge{
+		Kind: &agent.Challenge_Nonce{
+			Nonce: &agent.NonceChallenge{Nonce: c.nonce},
+		},
+	}, nil
+}
+
+func (c *nonceChallenger) verify(a *attestation, resp *agent.ChallengeResponse) error {
+	if err := verifyNonceSignature(a.csr.PublicKey, c.nonce, resp.GetNonce().GetSignature()); err != nil {
+		return fmt.Errorf("CSR key: %v", err)
+	}
+	return nil
+}
+
+// devidChallenger challenges the agent to sign a fresh nonce with its DevID key
+type devidChallenger struct {
+	nonce []byte
+}
+
+func (c *devidChallenger) issue(*attestation) (*agent.Challenge, error) {
+	c.nonce = make([]byte, nonceSize)
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, err
+	}
+
+	return &agent.Challenge{
+		Kind: &agent.Challenge_Devid{
+			Devid: &agent.DevIDChallenge{Nonce: c.nonce},
+		},
+	}, nil
+}
+
+func (c *devidChallenger) verify(a *attestation, resp *agent.ChallengeResponse) error {
+	if a.devid == nil {
+		return errors.New("no DevID to challenge")
+	}
+	if err := verifyNonceSignature(a.devid.PublicKey, c.nonce, resp.GetDevid().GetSignature()); err != nil {
+		return fmt.Errorf("DevID key: %v", err)
+	}
+	return nil
+}
+
+// verifyNonceSignature verifies an ECDSA (ASN.1) or RSA PKCS #1 v1.5 signature over the SHA256
+// digest of nonce
+func verifyNonceSignature(pub crypto.PublicKey, nonce, sig []byte) error {
+	digest := sha256.Sum256(nonce)
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("invalid nonce signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid nonce signature: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
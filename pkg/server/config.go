@@ -0,0 +1,335 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/ca"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Supported attestation types
+const (
+	// AttestorTPMActivation trusts nodes whose EK is registered
+	AttestorTPMActivation = "tpm_activation"
+
+	// AttestorTPMDevID trusts nodes with a DevID issued by a manufacturer in DevIDRoots, whose
+	// EK does not need to be registered
+	AttestorTPMDevID = "tpm_devid"
+)
+
+var (
+	defaultPerIPLimit = RateLimit{Rate: 1, Burst: 10}
+	defaultPerEKLimit = RateLimit{Rate: 0.1, Burst: 3}
+	defaultAttestors  = []string{AttestorTPMActivation}
+)
+
+const (
+	defaultTrustDomain = "spiffe_fog"
+	defaultSVIDTTL     = time.Hour
+	defaultCATTL       = 24 * time.Hour
+
+	defaultStepTimeout   = 30 * time.Second
+	defaultAttestTimeout = 2 * time.Minute
+	defaultMaxInFlight   = 64
+	defaultReplayWindow  = time.Hour
+)
+
+// FederatedBundles provides the X.509 authorities of federated trust domains by trust domain
+type FederatedBundles interface {
+	Bundles() map[string][]*x509.Certificate
+}
+
+// Config configures a Service, zero values are replaced with defaults
+type Config struct {
+	// TrustDomain SVIDs are issued in, defaults to spiffe_fog
+	TrustDomain string
+
+	// Store holds the registered nodes and registration entries, defaults to the demo nodes
+	Store registry.Store
+
+	// CA signs X509-SVIDs, defaults to an in-memory CA for TrustDomain
+	CA *ca.CA
+
+	// AKStore remembers the AK activated by every node. Agents of payload version 2 that present
+	// a known AK answer a quote instead of the activation. AKs are not reused if it is nil.
+	AKStore registry.AKStore
+
+	// FederatedBundles provides the bundles of the trust domains the server federates with, which
+	// are returned to agents with the attestation result. Nothing is federated if it is nil.
+	FederatedBundles FederatedBundles
+
+	// Attestors are the attestation types agents may use, defaults to tpm_activation
+	Attestors []string
+
+	// DevIDRoots are the manufacturer CAs that DevID certificates must chain to, they are
+	// required by tpm_devid
+	DevIDRoots []*x509.Certificate
+
+	// SVIDTTL is how long issued X509-SVIDs are valid for, defaults to an hour
+	SVIDTTL time.Duration
+
+	// StepTimeout is how long to wait for each message from an agent, defaults to 30 seconds.
+	// It must leave room for the agent to create an AK before its first message.
+	StepTimeout time.Duration
+
+	// AttestTimeout bounds an entire attestation, defaults to 2 minutes
+	AttestTimeout time.Duration
+
+	// Challenges are issued to every agent in order, one per round, defaults to a single activation.
+	// The first must be an activation, which is the only challenge agents before payload version 2
+	// understand.
+	Challenges []string
+
+	// MaxRounds bounds the number of challenges, defaults to 4
+	MaxRounds int
+
+	// ReplayWindow rejects the AK parameters and CSR of a successful attestation for this long, so
+	// that a recorded attestation cannot be replayed, defaults to an hour. The AK parameters and CSR
+	// of an attestation in progress are always rejected.
+	ReplayWindow time.Duration
+
+	// AllowUnboundCSR accepts agents of payload versions before 2, which cannot answer the binding
+	// challenge, so that their CSR is not bound to the AK. Agents of payload version 2 always get
+	// a binding challenge.
+	AllowUnboundCSR bool
+
+	// RequireTPMKeys only issues X509-SVIDs for CSR keys the AK certifies as created in and bound
+	// to the TPM. Agents may always send a certification, it is verified if present.
+	RequireTPMKeys bool
+
+	// MaxInFlight is the maximum number of concurrent attestations, defaults to 64.
+	// Additional attestations are rejected rather than queued.
+	MaxInFlight int
+
+	// PerIPLimit limits attestations per source IP, defaults to 1/s with a burst of 10
+	PerIPLimit RateLimit
+
+	// PerEKLimit limits attestations per EK hash, defaults to one every 10s with a burst of 3
+	PerEKLimit RateLimit
+
+	// TrustForwardedFor uses the last address in the x-forwarded-for header, which the proxy
+	// appends, as the source IP. Only enable this behind a single proxy that sets it, such as
+	// Cloud Run.
+	TrustForwardedFor bool
+
+	// Registerer is where metrics are registered, they are not exported if unset
+	Registerer prometheus.Registerer
+
+	// Logger defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+// settings are the parts of a Config that can be changed by Reload
+type settings struct {
+	store     registry.Store
+	attestors map[string]bool
+	svidTTL   time.Duration
+	// devIDRoots verify the DevID chains of tpm_devid attestations
+	devIDRoots *x509.CertPool
+
+	stepTimeout   time.Duration
+	attestTimeout time.Duration
+	challenges    []string
+	maxRounds     int
+	// requireTPMKeys rejects CSRs whose key is not certified by the AK
+	requireTPMKeys bool
+	// replayWindow rejects the AK parameters and CSR of successful attestations for this long
+	replayWindow time.Duration
+	// allowUnboundCSR accepts payload versions that cannot answer the binding challenge
+	allowUnboundCSR bool
+	// deferredSelectorTypes are added by the challenges, entries are only matched against them
+	// once every challenge has been answered
+	deferredSelectorTypes map[string]bool
+	// inFlight is a semaphore limiting the number of concurrent attestations
+	inFlight chan struct{}
+
+	ipLimit           RateLimit
+	ipLimiter         *keyedLimiter
+	ekLimit           RateLimit
+	ekLimiter         *keyedLimiter
+	trustForwardedFor bool
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.TrustDomain == "" {
+		cfg.TrustDomain = defaultTrustDomain
+	}
+	if cfg.Store == nil {
+		cfg.Store = defaultStore(cfg.TrustDomain)
+	}
+	if len(cfg.Attestors) == 0 {
+		cfg.Attestors = defaultAttestors
+	}
+	if cfg.SVIDTTL == 0 {
+		cfg.SVIDTTL = defaultSVIDTTL
+	}
+	if cfg.StepTimeout == 0 {
+		cfg.StepTimeout = defaultStepTimeout
+	}
+	if cfg.AttestTimeout == 0 {
+		cfg.AttestTimeout = defaultAttestTimeout
+	}
+	if len(cfg.Challenges) == 0 {
+		cfg.Challenges = defaultChallenges
+	}
+	if cfg.MaxRounds == 0 {
+		cfg.MaxRounds = defaultMaxRounds
+	}
+	if cfg.ReplayWindow == 0 {
+		cfg.ReplayWindow = defaultReplayWindow
+	}
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = defaultMaxInFlight
+	}
+	if cfg.PerIPLimit == (RateLimit{}) {
+		cfg.PerIPLimit = defaultPerIPLimit
+	}
+	if cfg.PerEKLimit == (RateLimit{}) {
+		cfg.PerEKLimit = defaultPerEKLimit
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.NewRegistry()
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+}
+
+// ValidateAttestors checks that the attestors are supported and that tpm_devid attestations, which
+// have an additional challenge round for the DevID, fit in maxRounds
+func ValidateAttestors(attestors, challenges []string, maxRounds int) error {
+	for _, a := range attestors {
+		switch a {
+		case AttestorTPMActivation:
+		case AttestorTPMDevID:
+			if rounds := challengeRounds(challenges) + 1; rounds > maxRounds {
+				return fmt.Errorf("%s adds a %s challenge, %d challenges exceed the maximum of %d rounds", a, ChallengeDevID, rounds, maxRounds)
+			}
+		default:
+			return fmt.Errorf("unsupported attestor: %s", a)
+		}
+	}
+	return nil
+}
+
+// validate checks the settings that Reload may change
+func (cfg *Config) validate() error {
+	if err := ValidateChallenges(cfg.Challenges, cfg.MaxRounds); err != nil {
+		return err
+	}
+	if err := ValidateAttestors(cfg.Attestors, cfg.Challenges, cfg.MaxRounds); err != nil {
+		return err
+	}
+	if cfg.ReplayWindow < 0 {
+		return fmt.Errorf("replay window must not be negative")
+	}
+	if slices.Contains(cfg.Attestors, AttestorTPMDevID) && len(cfg.DevIDRoots) == 0 {
+		return fmt.Errorf("%s requires DevID roots", AttestorTPMDevID)
+	}
+	return nil
+}
+
+func New(cfg Config) (*Service, error) {
+	cfg.setDefaults()
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.CA == nil {
+		authority, err := ca.New(cfg.TrustDomain, defaultCATTL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CA = authority
+	}
+	if cfg.CA.TrustDomain() != cfg.TrustDomain {
+		return nil, fmt.Errorf("CA trust domain %s does not match %s", cfg.CA.TrustDomain(), cfg.TrustDomain)
+	}
+
+	s := &Service{
+		trustDomain: cfg.TrustDomain,
+		ca:          cfg.CA,
+		akStore:     cfg.AKStore,
+		federated:   cfg.FederatedBundles,
+		replays:     newReplayGuard(),
+		logger:      cfg.Logger,
+	}
+	s.settings.Store(newSettings(cfg, nil))
+	s.metrics = newMetrics(cfg.Registerer, func() time.Time {
+		return s.ca.Certificate().NotAfter
+	})
+
+	return s, nil
+}
+
+// Reload applies the registration store, attestors, SVID TTL, timeouts, challenges, replay window
+// and limits of cfg to new attestations. Attestations in progress are not affected. The trust
+// domain, CA, registerer and logger of a Service cannot be changed and are ignored. Invalid
+// challenges or attestors keep the current configuration.
+func (s *Service) Reload(cfg Config) {
+	cfg.TrustDomain = s.trustDomain
+	cfg.setDefaults()
+
+	if err := cfg.validate(); err != nil {
+		s.logger.Error("failed to reload configuration", "error", err)
+		return
+	}
+	s.settings.Store(newSettings(cfg, s.settings.Load()))
+	s.logger.Info("reloaded configuration")
+}
+
+// newSettings creates settings from cfg, keeping the state of the in-flight semaphore and rate
+// limiters of prev if their configuration is unchanged.
+func newSettings(cfg Config, prev *settings) *settings {
+	next := &settings{
+		store:                 cfg.Store,
+		attestors:             map[string]bool{},
+		svidTTL:               cfg.SVIDTTL,
+		devIDRoots:            x509.NewCertPool(),
+		stepTimeout:           cfg.StepTimeout,
+		attestTimeout:         cfg.AttestTimeout,
+		challenges:            cfg.Challenges,
+		maxRounds:             cfg.MaxRounds,
+		deferredSelectorTypes: map[string]bool{},
+		requireTPMKeys:        cfg.RequireTPMKeys,
+		replayWindow:          cfg.ReplayWindow,
+		allowUnboundCSR:       cfg.AllowUnboundCSR,
+		inFlight:              make(chan struct{}, cfg.MaxInFlight),
+		ipLimit:               cfg.PerIPLimit,
+		ipLimiter:             newKeyedLimiter(cfg.PerIPLimit),
+		ekLimit:               cfg.PerEKLimit,
+		ekLimiter:             newKeyedLimiter(cfg.PerEKLimit),
+		trustForwardedFor:     cfg.TrustForwardedFor,
+	}
+	for _, a := range cfg.Attestors {
+		next.attestors[a] = true
+	}
+	for _, root := range cfg.DevIDRoots {
+		next.devIDRoots.AddCert(root)
+	}
+	for _, c := range cfg.Challenges {
+		if c == ChallengeQuote {
+			next.deferredSelectorTypes[pcrSelectorType] = true
+		}
+	}
+
+	if prev != nil {
+		if cap(prev.inFlight) == cfg.MaxInFlight {
+			next.inFlight = prev.inFlight
+		}
+		if prev.ipLimit == cfg.PerIPLimit {
+			next.ipLimiter = prev.ipLimiter
+		}
+		if prev.ekLimit == cfg.PerEKLimit {
+			next.ekLimiter = prev.ekLimiter
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// workloadIDs returns the SPIFFE IDs of the entries besides the agent one, in order
+func workloadIDs(entries []registry.Entry, requested string) []string {
+	seen := map[string]bool{requested: true}
+	var ids []string
+	for _, e := range entries {
+		if !seen[e.SPIFFEID] {
+			seen[e.SPIFFEID] = true
+			ids = append(ids, e.SPIFFEID)
+		}
+	}
+	return ids
+}
+
+// requestCSRs asks the agent for a CSR of a new key for every ID, so that no X509-SVID shares the
+// key of the agent X509-SVID, and returns the key to certify for each ID. Agents that decline get
+// no X509-SVID for these IDs.
+func (s *Service) requestCSRs(ctx context.Context, stream agent.Agent_AttestAgentServer, sess *session, agentKey crypto.PublicKey, ids []string) (keys map[string]crypto.PublicKey, err error) {
+	ctx, span := tracer.Start(ctx, "CSRRoundTrip", trace.WithAttributes(attribute.Int("spiffe_fog.ids", len(ids))))
+	defer func() { telemetry.End(span, err) }()
+	defer s.metrics.observeStep(stepClientRoundTrip, time.Now())
+
+	request := &agent.CSRRequest{}
+	for _, id := range ids {
+		u, err := common.ParseSPIFFEID(id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid registration entry SPIFFE ID %s: %v", id, err)
+		}
+		request.Ids = append(request.Ids, &agent.SPIFFEID{TrustDomain: u.Host, Path: u.Path})
+	}
+
+	sess.logger.Info("requesting workload CSRs", "step", "csrs", "ids", len(ids))
+	if err := stream.Send(&agent.AttestAgentResponse{
+		Step: &agent.AttestAgentResponse_CsrRequest{CsrRequest: request},
+	}); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send CSR request: %v", err)
+	}
+
+	req, err := sess.recv(ctx, stream)
+	if err != nil {
+		return nil, recvError(err, codes.Internal, "failed to receive CSRs")
+	}
+	csrs := req.GetCsrs()
+	if csrs == nil {
+		return nil, s.reject(reasonOutOfOrder, status.Error(codes.InvalidArgument, "expected CSRs"))
+	}
+	if len(csrs.Csrs) == 0 {
+		sess.logger.Info("agent declined workload CSRs")
+		return nil, nil
+	}
+	if len(csrs.Csrs) != len(ids) {
+		return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "expected %d CSRs, got %d", len(ids), len(csrs.Csrs)))
+	}
+
+	keys = make(map[string]crypto.PublicKey, len(ids))
+	seen := []crypto.PublicKey{agentKey}
+	for i, id := range ids {
+		key, err := workloadCSRKey(csrs.Csrs[i], id, seen)
+		if err != nil {
+			return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "invalid CSR for %s: %v", id, err))
+		}
+		keys[id] = key
+		seen = append(seen, key)
+	}
+	return keys, nil
+}
+
+// workloadCSRKey returns the key of a CSR for id, which must differ from the keys already seen
+func workloadCSRKey(der []byte, id string, seen []crypto.PublicKey) (crypto.PublicKey, error) {
+	cr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %v", err)
+	}
+	if err := cr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %v", err)
+	}
+	if len(cr.URIs) != 1 || cr.URIs[0].String() != id {
+		return nil, fmt.Errorf("CSR must contain exactly the URI SAN %s", id)
+	}
+	for _, key := range seen {
+		if equalKeys(cr.PublicKey, key) {
+			return nil, errors.New("CSR key is shared with another X509-SVID")
+		}
+	}
+	return cr.PublicKey, nil
+}
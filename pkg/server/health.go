@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check returns an error if the service cannot currently issue X509-SVIDs because the CA has
+// expired or the registration store is unavailable
+func (s *Service) Check(ctx context.Context) error {
+	if notAfter := s.ca.Certificate().NotAfter; time.Now().After(notAfter) {
+		return fmt.Errorf("CA expired at %s", notAfter)
+	}
+
+	if _, err := s.settings.Load().store.ListEntries(ctx); err != nil {
+		return fmt.Errorf("registration store is unavailable: %v", err)
+	}
+
+	return nil
+}
+
+// WatchHealth runs Check every interval and reports the result for the Agent service and the
+// server as a whole to hs until ctx is done
+func (s *Service) WatchHealth(ctx context.Context, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := s.Check(checkCtx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			if healthy {
+				s.logger.Error("not serving", "error", err)
+			}
+		} else if !healthy {
+			s.logger.Info("serving again")
+		}
+		healthy = err == nil
+
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(agent.Agent_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "spiffe_fog"
+
+// Outcomes of an attestation
+const (
+	outcomeSuccess  = "success"
+	outcomeRejected = "rejected"
+	outcomeTimeout  = "timeout"
+	outcomeError    = "error"
+)
+
+// Reasons an attestation is rejected
+const (
+	reasonTooManyInFlight   = "too_many_in_flight"
+	reasonRateLimited       = "rate_limited"
+	reasonMalformedParams   = "malformed_params"
+	reasonUnsupportedType   = "unsupported_type"
+	reasonUnknownEK         = "unknown_ek"
+	reasonBadDevID          = "bad_devid"
+	reasonReplayedAK        = "replayed_ak"
+	reasonUnboundCSR        = "unbound_csr"
+	reasonAKMalformed       = "ak_malformed"
+	reasonAKExportable      = "ak_exportable"
+	reasonAKNotTPMGenerated = "ak_not_tpm_generated"
+	reasonAKNotRestricted   = "ak_not_restricted"
+	reasonAKNotSigningOnly  = "ak_not_signing_only"
+	reasonAKAlgorithm       = "ak_algorithm"
+	reasonAKCreation        = "ak_creation"
+	reasonBadCSR            = "bad_csr"
+	reasonUnauthorizedID    = "unauthorized_id"
+	reasonChallengeMismatch = "challenge_mismatch"
+	reasonOutOfOrder        = "out_of_order"
+)
+
+// Steps of an attestation whose latency is observed
+const (
+	stepChallengeGeneration = "challenge_generation"
+	stepClientRoundTrip     = "client_round_trip"
+	stepSigning             = "signing"
+)
+
+// knownTypes bounds the values of the type label, since it is chosen by the agent
+var knownTypes = map[string]bool{
+	AttestorTPMActivation: true,
+	AttestorTPMDevID:      true,
+}
+
+type metrics struct {
+	attestations  *prometheus.CounterVec
+	rejections    *prometheus.CounterVec
+	stepDuration  *prometheus.HistogramVec
+	activeStreams prometheus.Gauge
+	svidsIssued   prometheus.Counter
+}
+
+// newMetrics creates the Service collectors and registers them with reg, including
+// a gauge reporting the time left until caExpiry.
+func newMetrics(reg prometheus.Registerer, caExpiry func() time.Time) *metrics {
+	factory := promauto.With(reg)
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "ca_time_to_expiry_seconds",
+		Help:      "Seconds until the CA certificate expires.",
+	}, func() float64 {
+		return time.Until(caExpiry()).Seconds()
+	})
+
+	return &metrics{
+		attestations: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "attestations_total",
+			Help:      "Attestations by attestation type and outcome.",
+		}, []string{"type", "outcome"}),
+		rejections: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "attestation_rejections_total",
+			Help:      "Rejected attestations by reason.",
+		}, []string{"reason"}),
+		stepDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "attestation_step_duration_seconds",
+			Help:      "Latency of each attestation step.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"step"}),
+		activeStreams: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_attestation_streams",
+			Help:      "AttestAgent streams currently open.",
+		}),
+		svidsIssued: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "svids_issued_total",
+			Help:      "X509-SVIDs signed for attested agents.",
+		}),
+	}
+}
+
+// observeStep records the time since start for step
+func (m *metrics) observeStep(step string, start time.Time) {
+	m.stepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// observeAttestation counts a finished attestation of attestationType that ended with err
+func (m *metrics) observeAttestation(attestationType string, err error) {
+	if !knownTypes[attestationType] {
+		attestationType = "unknown"
+	}
+	m.attestations.WithLabelValues(attestationType, outcome(err)).Inc()
+}
+
+func outcome(err error) string {
+	switch status.Code(err) {
+	case codes.OK:
+		return outcomeSuccess
+	case codes.DeadlineExceeded:
+		return outcomeTimeout
+	case codes.InvalidArgument, codes.PermissionDenied, codes.ResourceExhausted:
+		return outcomeRejected
+	default:
+		return outcomeError
+	}
+}
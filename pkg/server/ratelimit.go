@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often idle limiters are dropped so that a flood of
+// distinct keys cannot grow the limiter map without bound.
+const sweepInterval = time.Minute
+
+// RateLimit configures a token bucket refilled with Rate tokens per second up to Burst tokens.
+// A negative Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// keyedLimiter maintains a token bucket per key, e.g. per source IP
+type keyedLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	limiters  map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// newKeyedLimiter returns nil if l disables rate limiting, a nil keyedLimiter allows everything
+func newKeyedLimiter(l RateLimit) *keyedLimiter {
+	if l.Rate < 0 {
+		return nil
+	}
+	return &keyedLimiter{
+		limit:     rate.Limit(l.Rate),
+		burst:     l.Burst,
+		limiters:  map[string]*rate.Limiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for key, returning false if it is empty
+func (k *keyedLimiter) Allow(key string) bool {
+	if k == nil {
+		return true
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastSweep) > sweepInterval {
+		k.sweep(now)
+	}
+
+	l, ok := k.limiters[key]
+	if !ok {
+		l = rate.NewLimiter(k.limit, k.burst)
+		k.limiters[key] = l
+	}
+	return l.AllowN(now, 1)
+}
+
+// sweep drops limiters that have refilled completely, they are indistinguishable from new ones
+func (k *keyedLimiter) sweep(now time.Time) {
+	for key, l := range k.limiters {
+		if l.TokensAt(now) >= float64(k.burst) {
+			delete(k.limiters, key)
+		}
+	}
+	k.lastSweep = now
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// replayGuard rejects the AK parameters and CSR that an attestation in progress uses, or that a
+// successful attestation used within the replay window, so that a relayed or recorded attestation
+// cannot be completed in parallel with or right after the original one
+type replayGuard struct {
+	mu        sync.Mutex
+	inFlight  map[string]bool
+	used      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{
+		inFlight:  map[string]bool{},
+		used:      map[string]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+// attestationReplayKey identifies the AK parameters and CSR public key of an attestation
+func attestationReplayKey(akPublic, csrSPKI []byte) string {
+	ak := sha256.Sum256(akPublic)
+	csr := sha256.Sum256(csrSPKI)
+	sum := sha256.Sum256(append(ak[:], csr[:]...))
+	return hex.EncodeToString(sum[:])
+}
+
+// begin marks key as in use, returning false if it is in use or was used too recently
+func (g *replayGuard) begin(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) > sweepInterval {
+		g.sweep(now)
+	}
+
+	if g.inFlight[key] {
+		return false
+	}
+	if expiry, ok := g.used[key]; ok && now.Before(expiry) {
+		return false
+	}
+	g.inFlight[key] = true
+	return true
+}
+
+// end releases key, which is rejected for window if the attestation succeeded
+func (g *replayGuard) end(key string, succeeded bool, window time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.inFlight, key)
+	if succeeded && window > 0 {
+		g.used[key] = time.Now().Add(window)
+	}
+}
+
+// sweep drops keys whose window has passed
+func (g *replayGuard) sweep(now time.Time) {
+	for key, expiry := range g.used {
+		if !now.Before(expiry) {
+			delete(g.used, key)
+		}
+	}
+	g.lastSweep = now
+}
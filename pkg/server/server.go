@@ -2,48 +2,167 @@ package server
 
 import (
 	"context"
-	"crypto/subtle"
+	"crypto"
+	"crypto/rand"
 	"crypto/x509"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/mjlshen/spiffe_fog/pkg/ca"
 	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/telemetry"
 	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
+var tracer = otel.Tracer("github.com/mjlshen/spiffe_fog/pkg/server")
+
+const (
+	// tpmSelectorType is the selector type produced by TPM node attestation
+	tpmSelectorType = "tpm"
+
+	// devidSelectorType is the selector type produced by tpm_devid attestation
+	devidSelectorType = "tpm_devid"
+)
+
 type Service struct {
 	agent.UnimplementedAgentServer
+
+	trustDomain string
+	ca          *ca.CA
+	akStore     registry.AKStore
+	federated   FederatedBundles
+	replays     *replayGuard
+
+	// settings can be replaced by Reload, attestations in progress keep the settings they started with
+	settings atomic.Pointer[settings]
+
+	metrics *metrics
+	logger  *slog.Logger
+}
+
+// session is the state of a single AttestAgent stream
+type session struct {
+	id              string
+	logger          *slog.Logger
+	settings        *settings
+	attestationType string
+}
+
+// newSession creates a session with a random ID that correlates its logs
+func (s *Service) newSession(ctx context.Context) (*session, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+
+	logger := s.logger.With("session_id", id, "peer", peerAddr(ctx))
+	if caller, ok := CallerID(ctx); ok {
+		logger = logger.With("caller_id", caller.String())
+	}
+
+	return &session{
+		id:       id,
+		logger:   logger,
+		settings: s.settings.Load(),
+	}, nil
 }
 
 // AttestAgent handles TPM credential activation
-func (s *Service) AttestAgent(stream agent.Agent_AttestAgentServer) error {
-	ctx := stream.Context()
+func (s *Service) AttestAgent(stream agent.Agent_AttestAgentServer) (err error) {
+	s.metrics.activeStreams.Inc()
+	defer s.metrics.activeStreams.Dec()
+
+	sess, err := s.newSession(stream.Context())
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create session: %v", err)
+	}
+
+	trace.SpanFromContext(stream.Context()).SetAttributes(attribute.String("spiffe_fog.session_id", sess.id))
+
+	start := time.Now()
+	defer func() {
+		s.metrics.observeAttestation(sess.attestationType, err)
+		if err != nil {
+			sess.logger.Warn("attestation failed", "code", status.Code(err).String(), "error", status.Convert(err).Message(), "duration", time.Since(start))
+		}
+	}()
+
+	// Echo the session ID so that agent and server logs can be correlated, and advertise the
+	// payload versions so that the agent can pick the newest one both sides understand
+	if err := stream.SendHeader(metadata.Pairs(
+		common.SessionIDHeader, sess.id,
+		common.PayloadVersionsHeader, common.FormatPayloadVersions(common.PayloadVersions),
+	)); err != nil {
+		return status.Errorf(codes.Internal, "failed to send header: %v", err)
+	}
+
+	select {
+	case sess.settings.inFlight <- struct{}{}:
+		defer func() { <-sess.settings.inFlight }()
+	default:
+		return s.reject(reasonTooManyInFlight, status.Error(codes.ResourceExhausted, "too many attestations in progress"))
+	}
 
-	req, err := stream.Recv()
+	if ip := sourceIP(stream.Context(), sess.settings.trustForwardedFor); !sess.settings.ipLimiter.Allow(ip) {
+		sess.logger.Warn("rate limited attestation", "source_ip", ip)
+		return s.reject(reasonRateLimited, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s", ip))
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), sess.settings.attestTimeout)
+	defer cancel()
+
+	return s.attestAgent(ctx, stream, sess)
+}
+
+// reject counts a rejected attestation by reason and returns err
+func (s *Service) reject(reason string, err error) error {
+	s.metrics.rejections.WithLabelValues(reason).Inc()
+	return err
+}
+
+func (s *Service) attestAgent(ctx context.Context, stream agent.Agent_AttestAgentServer, sess *session) error {
+	req, err := sess.recv(ctx, stream)
 	if err != nil {
-		return status.Errorf(codes.InvalidArgument, "failed to receive request from stream: %v", err)
+		return recvError(err, codes.InvalidArgument, "failed to receive request from stream")
 	}
 
 	// The first communication with an agent must contain attestation parameters
 	params := req.GetParams()
 	if err := validateAttestAgentParams(params); err != nil {
-		return status.Errorf(codes.InvalidArgument, "malformed attestation param: %v", err)
+		return s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "malformed attestation param: %v", err))
 	}
+	sess.attestationType = params.Data.Type
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("spiffe_fog.attestation_type", params.Data.Type))
 
 	// Gather the params, send a challenge and receive a challenge response
-	attestResult, err := s.attestChallengeResponse(ctx, stream, params)
+	attestResult, err := s.attestChallengeResponse(ctx, stream, sess, params)
 	if err != nil {
 		return err
 	}
 
 	// If there's no error, then this node checks out!
 	if err := stream.Send(attestResult); err != nil {
-		return status.Errorf(codes.Internal, "failed to send response over stream", err)
+		return status.Errorf(codes.Internal, "failed to send response over stream: %v", err)
 	}
 
 	return nil
@@ -51,132 +170,475 @@ func (s *Service) AttestAgent(stream agent.Agent_AttestAgentServer) error {
 
 func (s *Service) attestChallengeResponse(ctx context.Context,
 	stream agent.Agent_AttestAgentServer,
+	sess *session,
 	params *agent.AttestAgentRequest_Params,
 ) (*agent.AttestAgentResponse, error) {
-	if params.Data.Type != "tpm_activation" {
-		return nil, status.Errorf(codes.InvalidArgument, "unsupported type: %s", params.Data.Type)
+	if !sess.settings.attestors[params.Data.Type] {
+		return nil, s.reject(reasonUnsupportedType, status.Errorf(codes.InvalidArgument, "unsupported type: %s", params.Data.Type))
 	}
 
-	log.Println("received attestation request")
+	sess.logger.Info("received attestation request", "step", "params", "type", params.Data.Type)
 	payload := params.Data.GetPayload()
 	if payload == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing attestation payload")
+		return nil, s.reject(reasonMalformedParams, status.Error(codes.InvalidArgument, "missing attestation payload"))
 	}
 
-	var tpmAttestationData common.AttestationData
-	if err := json.Unmarshal(payload, &tpmAttestationData); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "malformed activation param: %v", err)
+	version := params.Data.GetPayloadVersion()
+	var (
+		tpmAttestationData *common.AttestationData
+		devid              *common.DevIDData
+		err                error
+	)
+	if params.Data.Type == AttestorTPMDevID {
+		devid, err = common.UnmarshalDevIDData(version, payload)
+		if err != nil {
+			return nil, s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "malformed DevID param: %v", err))
+		}
+		tpmAttestationData = &devid.AttestationData
+	} else {
+		tpmAttestationData, err = common.UnmarshalAttestationData(version, payload)
+		if err != nil {
+			return nil, s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "malformed activation param: %v", err))
+		}
+	}
+	if tpmAttestationData.AK == nil {
+		return nil, s.reject(reasonMalformedParams, status.Error(codes.InvalidArgument, "missing AK attestation parameters"))
+	}
+	if version < common.PayloadVersionTyped && !sess.settings.allowUnboundCSR {
+		return nil, s.reject(reasonUnboundCSR, status.Errorf(codes.InvalidArgument, "payload version %d cannot bind the CSR to the AK, version %d is required", version, common.PayloadVersionTyped))
+	}
+	if version < common.PayloadVersionTyped && len(sess.settings.challenges) > 1 {
+		return nil, s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "payload version %d does not support %s challenges", version, strings.Join(sess.settings.challenges[1:], ", ")))
 	}
 
 	ek, err := common.DecodeEK(tpmAttestationData.EK)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "malformed EK: %v", err)
+		return nil, s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "malformed EK: %v", err))
 	}
 
-	cr, err := x509.ParseCertificateRequest(params.Params.Csr)
+	ekHash, err := common.GetPubHash(ek)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to parse CSR: %v", err)
+		return nil, s.reject(reasonMalformedParams, status.Errorf(codes.InvalidArgument, "invalid EK: %v", err))
 	}
 
-	path := cr.URIs[0].String()
+	// Reject unknown or overly eager EKs before doing any expensive work. Nodes with a DevID are
+	// trusted through their manufacturer and do not need a registered EK.
+	selectors, err := s.nodeSelectors(ctx, sess, ekHash, devid != nil)
+	if err != nil {
+		return nil, err
+	}
 
-	if ok, err := isValidEK(ek, path); !ok {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid EK: %v", err)
+	// Activation only proves that the AK is in the same TPM as the EK, not what kind of key it is
+	if err := validateAK(ek, tpmAttestationData.AK); err != nil {
+		sess.logger.Warn("rejected AK", "reason", err.reason, "error", err)
+		return nil, s.reject(err.reason, status.Errorf(codes.InvalidArgument, "invalid AK: %v", err))
 	}
 
-	// Collect TPM activation parameters to generate a challenge
-	ap := attest.ActivationParameters{
-		TPMVersion: attest.TPMVersion20,
-		EK:         ek.Public,
-		AK:         *tpmAttestationData.AK,
+	var devidCert *x509.Certificate
+	if devid != nil {
+		devidCert, err = verifyDevID(sess.settings.devIDRoots, tpmAttestationData.AK, devid)
+		if err != nil {
+			return nil, s.reject(reasonBadDevID, status.Errorf(codes.PermissionDenied, "invalid DevID: %v", err))
+		}
+		sess.logger = sess.logger.With("devid_serial", devidCert.SerialNumber.Text(16))
+		selectors = append(selectors, devidSelectors(devidCert)...)
 	}
 
-	secret, challenge, err := ap.Generate()
+	cr, err := x509.ParseCertificateRequest(params.Params.Csr)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to generate activation challenge: %v", err)
+		return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "failed to parse CSR: %v", err))
 	}
 
-	challengeBytes, err := json.Marshal(challenge)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to marshal challenge: %v", err)
+	// A relayed or recorded attestation presents the AK parameters and CSR of the original one,
+	// while an agent reusing its AK creates a new CSR key for every attestation
+	replayKey := attestationReplayKey(tpmAttestationData.AK.Public, cr.RawSubjectPublicKeyInfo)
+	if !s.replays.begin(replayKey) {
+		sess.logger.Warn("rejected replayed AK parameters")
+		return nil, s.reject(reasonReplayedAK, status.Error(codes.PermissionDenied, "AK parameters and CSR were used by another attestation"))
+	}
+	succeeded := false
+	defer func() { s.replays.end(replayKey, succeeded, sess.settings.replayWindow) }()
+	if err := cr.CheckSignature(); err != nil {
+		return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "invalid CSR signature: %v", err))
 	}
+	if len(cr.URIs) != 1 {
+		return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "CSR must contain exactly one URI SAN, found %d", len(cr.URIs)))
+	}
+	requested := cr.URIs[0]
 
-	log.Println("sending attestation challenge")
-	if err := stream.Send(&agent.AttestAgentResponse{
-		Step: &agent.AttestAgentResponse_Challenge{
-			Challenge: challengeBytes,
-		},
-	}); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to send challenge: %v", err)
+	tpmKey, err := verifyKeyCertification(tpmAttestationData.AK, params.Params.KeyCertification, cr.PublicKey)
+	if err != nil {
+		return nil, s.reject(reasonBadCSR, status.Errorf(codes.InvalidArgument, "invalid key certification: %v", err))
+	}
+	if !tpmKey && sess.settings.requireTPMKeys {
+		return nil, s.reject(reasonBadCSR, status.Error(codes.InvalidArgument, "CSR key must be certified as TPM-resident by the AK"))
 	}
 
-	resp, err := stream.Recv()
+	all, err := sess.settings.store.ListEntries(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to receive challenge response: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to list registration entries: %v", err)
+	}
+
+	// Reject IDs that no entry can grant before issuing any challenge, the selectors added by the
+	// challenges are only known once they have been answered
+	if !isEntitled(matchingEntries(all, selectors, sess.settings.deferredSelectorTypes), requested.String()) {
+		return nil, s.reject(reasonUnauthorizedID, status.Errorf(codes.InvalidArgument, "invalid SPIFFE ID requested: %s", requested))
 	}
 
-	challengeResp := resp.GetChallengeResponse()
-	if challengeResp == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing challenge response")
+	a := &attestation{
+		version: version,
+		ek:      ek,
+		ak:      *tpmAttestationData.AK,
+		csr:     cr,
+		devid:   devidCert,
 	}
 
-	//Verify the challenge response
-	if subtle.ConstantTimeCompare(secret, challengeResp) == 0 {
-		return nil, status.Errorf(codes.PermissionDenied, "challenge response does not match")
+	sess.logger = sess.logger.With("spiffe_id", requested.String())
+	challenges, reused := s.plan(ctx, sess, a, ekHash)
+	if err := s.challenge(ctx, stream, sess, a, challenges); err != nil {
+		return nil, err
 	}
 
-	log.Printf("successful attestation for %s", cr.URIs[0].String())
+	entries := matchingEntries(all, append(selectors, a.selectors...), nil)
+	if !isEntitled(entries, requested.String()) {
+		return nil, s.reject(reasonUnauthorizedID, status.Errorf(codes.InvalidArgument, "invalid SPIFFE ID requested: %s", requested))
+	}
+
+	if s.akStore != nil && !reused {
+		if err := s.akStore.StoreAK(ctx, ekHash, a.ak.Public); err != nil {
+			sess.logger.Warn("failed to remember AK", "error", err)
+		}
+	}
+
+	// Older agents only know the agent CSR, and no X509-SVID may share its key
+	var keys map[string]crypto.PublicKey
+	if ids := workloadIDs(entries, requested.String()); len(ids) > 0 && version >= common.PayloadVersionTyped {
+		keys, err = s.requestCSRs(ctx, stream, sess, cr.PublicKey, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sess.logger.Info("attestation succeeded", "step", "result", "tpm_key", tpmKey)
+	result, err := s.newResult(ctx, sess, cr.PublicKey, requested, entries, keys)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to issue X509-SVIDs: %v", err)
+	}
+	succeeded = true
+
 	return &agent.AttestAgentResponse{
 		Step: &agent.AttestAgentResponse_Result_{
-			Result: &agent.AttestAgentResponse_Result{
-				Svid: &agent.X509SVID{
-					CertChain: nil,
-					Id: &agent.SPIFFEID{
-						TrustDomain: "spiffe_fog_demo",
-						Path:        path,
-					},
-					ExpiresAt: 0,
-				},
-			},
+			Result: result,
 		},
 	}, nil
 }
 
-// validEKHashes returns a map of hashes keys with values indicating the corresponding SPIFFE ID
-// TODO: Allow other backing stores
-func validEKHashes() map[string]string {
-	return map[string]string{
+// recv waits for the next request from the agent, giving up once the step timeout or ctx expire.
+// The pending stream.Recv returns as soon as the handler does, since that cancels the stream.
+func (sess *session) recv(ctx context.Context, stream agent.Agent_AttestAgentServer) (*agent.AttestAgentRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, sess.settings.stepTimeout)
+	defer cancel()
+
+	type recvResult struct {
+		req *agent.AttestAgentRequest
+		err error
+	}
+	done := make(chan recvResult, 1)
+	go func() {
+		req, err := stream.Recv()
+		done <- recvResult{req: req, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.req, r.err
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// recvError converts an error from recv into a gRPC status, preserving deadline and cancellation codes
+func recvError(err error, code codes.Code, msg string) error {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		return status.Errorf(status.Code(err), "%s: %v", msg, status.Convert(err).Message())
+	default:
+		return status.Errorf(code, "%s: %v", msg, err)
+	}
+}
+
+// sourceIP returns the IP address of the agent for rate limiting
+func sourceIP(ctx context.Context, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		md, _ := metadata.FromIncomingContext(ctx)
+		// The proxy appends the address it received the request from, everything to the left of it
+		// is set by the client and can be spoofed
+		if v := md.Get("x-forwarded-for"); len(v) > 0 {
+			last := v[len(v)-1]
+			return strings.TrimSpace(last[strings.LastIndex(last, ",")+1:])
+		}
+	}
+
+	addr := peerAddr(ctx)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown peer"
+}
+
+// defaultStore returns the demo nodes, each entitled to a single SPIFFE ID chosen by its EK
+func defaultStore(trustDomain string) *registry.MemoryStore {
+	store := registry.NewMemoryStore()
+	for ekHash, id := range map[string]string{
 		// GCP TPM
 		"ae76715da45c546d57473816bb7402b467ac7e11d76ae43205769b65e3821f9d": "gcp",
 		// RPi Infineon TPM
 		"ae8dec3321f80ab68bdde38e3cf7d59612be0c0a608def2c3d55a63fd875e32c": "rpi",
+	} {
+		store.AddNode(registry.Node{EKHash: ekHash})
+		store.AddEntry(registry.Entry{
+			ID:        id,
+			SPIFFEID:  fmt.Sprintf("spiffe://%s/%s", trustDomain, id),
+			Selectors: []registry.Selector{ekHashSelector(ekHash)},
+		})
 	}
+	return store
 }
 
-// isValidEK returns true if the provided EK is trusted by comparing the sha256 hash
-// of the EK public key after it has been converted to the ASN.1 DER format with
-// "valid" EK hashes.
-func isValidEK(ek *attest.EK, path string) (bool, error) {
-	ekHash, err := common.GetPubHash(ek)
+func ekHashSelector(ekHash string) registry.Selector {
+	return registry.Selector{Type: tpmSelectorType, Value: "ek_hash:" + ekHash}
+}
+
+// nodeSelectors returns the selectors of the node with the provided EK hash if it is trusted.
+// An EK is trusted if the sha256 hash of its public key, after it has been converted to
+// the ASN.1 DER format, belongs to a registered node. Unknown EKs are only accepted with optional
+// set, for tpm_devid attestations whose node is trusted through its DevID, and then only have
+// their ek_hash selector.
+func (s *Service) nodeSelectors(ctx context.Context, sess *session, ekHash string, optional bool) ([]registry.Selector, error) {
+	sess.logger = sess.logger.With("ek_hash", ekHash)
+	if !sess.settings.ekLimiter.Allow(ekHash) {
+		sess.logger.Warn("rate limited attestation")
+		return nil, s.reject(reasonRateLimited, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for EK: %s", ekHash))
+	}
+
+	node, err := sess.settings.store.FetchNode(ctx, ekHash)
 	if err != nil {
-		return false, err
+		if errors.Is(err, registry.ErrNotFound) && optional {
+			return []registry.Selector{ekHashSelector(ekHash)}, nil
+		}
+		if errors.Is(err, registry.ErrNotFound) {
+			return nil, s.reject(reasonUnknownEK, status.Errorf(codes.InvalidArgument, "invalid EK: invalid EK hash: %s", ekHash))
+		}
+		return nil, status.Errorf(codes.Internal, "failed to fetch node: %v", err)
 	}
 
-	valid := validEKHashes()
-	id, ok := valid[ekHash]
-	if !ok {
-		return false, fmt.Errorf("invalid EK hash: %s", ekHash)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("spiffe_fog.ek_hash", ekHash))
+	sess.logger.Debug("processing EK", "step", "params")
+	return append([]registry.Selector{ekHashSelector(ekHash)}, node.Selectors...), nil
+}
+
+// matchingEntries returns the entries whose selectors are all in selectors, ignoring selectors of
+// the deferred types
+func matchingEntries(entries []registry.Entry, selectors []registry.Selector, deferred map[string]bool) []registry.Entry {
+	var matched []registry.Entry
+	for _, e := range entries {
+		required := make([]registry.Selector, 0, len(e.Selectors))
+		for _, sel := range e.Selectors {
+			if !deferred[sel.Type] {
+				required = append(required, sel)
+			}
+		}
+		if registry.IsSubset(required, selectors) {
+			matched = append(matched, e)
+		}
 	}
+	return matched
+}
 
-	expectedPath := fmt.Sprintf("spiffe://spiffe_fog/%s", id)
-	if expectedPath != path {
-		return false, fmt.Errorf("invalid SPIFFE ID requested: %s", path)
+// isEntitled returns true if one of the entries grants the SPIFFE ID
+func isEntitled(entries []registry.Entry, id string) bool {
+	for _, e := range entries {
+		if e.SPIFFEID == id {
+			return true
+		}
 	}
+	return false
+}
+
+// newResult signs the agent X509-SVID for requested certifying pub, and an X509-SVID for every other
+// entry that has a key of its own in keys
+func (s *Service) newResult(ctx context.Context, sess *session, pub crypto.PublicKey, requested *url.URL, entries []registry.Entry, keys map[string]crypto.PublicKey) (result *agent.AttestAgentResponse_Result, err error) {
+	_, span := tracer.Start(ctx, "SignX509SVIDs")
+	defer func() { telemetry.End(span, err) }()
+	defer s.metrics.observeStep(stepSigning, time.Now())
+
+	result = &agent.AttestAgentResponse_Result{
+		Bundle: [][]byte{s.ca.Certificate().Raw},
+	}
+	if s.federated != nil {
+		bundles := s.federated.Bundles()
+		for _, td := range slices.Sorted(maps.Keys(bundles)) {
+			federated := &agent.FederatedBundle{TrustDomain: td}
+			for _, cert := range bundles[td] {
+				federated.Bundle = append(federated.Bundle, cert.Raw)
+			}
+			result.FederatedBundles = append(result.FederatedBundles, federated)
+		}
+	}
+
+	issued := map[string]bool{}
+	for _, e := range entries {
+		if issued[e.SPIFFEID] {
+			continue
+		}
+		key := pub
+		if e.SPIFFEID != requested.String() {
+			if key = keys[e.SPIFFEID]; key == nil {
+				continue
+			}
+		}
+		issued[e.SPIFFEID] = true
 
-	log.Printf("processing EK: %s", ekHash)
+		id, err := common.ParseSPIFFEID(e.SPIFFEID)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %v", e.ID, err)
+		}
+
+		cert, err := s.ca.SignX509SVID(key, id, sess.settings.svidTTL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %v", e.ID, err)
+		}
+
+		svid := &agent.X509SVID{
+			CertChain: [][]byte{cert.Raw},
+			Id: &agent.SPIFFEID{
+				TrustDomain: id.Host,
+				Path:        id.Path,
+			},
+			ExpiresAt: cert.NotAfter.Unix(),
+		}
+		result.Svids = append(result.Svids, svid)
+
+		if id.String() == requested.String() {
+			result.Svid = svid
+		}
+
+		if len(e.WorkloadSelectors) > 0 {
+			entry := &agent.RegistrationEntry{
+				Id:       e.ID,
+				SpiffeId: svid.Id,
+			}
+			for _, sel := range e.WorkloadSelectors {
+				entry.Selectors = append(entry.Selectors, &agent.Selector{
+					Type:  sel.Type,
+					Value: sel.Value,
+				})
+			}
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+
+	s.metrics.svidsIssued.Add(float64(len(result.Svids)))
+	span.SetAttributes(attribute.Int("svids", len(result.Svids)))
+	return result, nil
+}
+
+// verifyKeyCertification checks that the CSR key was created in the TPM and cannot leave it, as
+// certified by the AK. It returns false if the agent did not send a certification. The AK itself is
+// only trusted once the challenges succeed.
+func verifyKeyCertification(ak *attest.AttestationParameters, cert *agent.KeyCertification, csrKey crypto.PublicKey) (bool, error) {
+	if cert == nil {
+		return false, nil
+	}
+
+	params := &attest.CertificationParameters{
+		Public:            cert.Public,
+		CreateData:        cert.CreateData,
+		CreateAttestation: cert.CreateAttestation,
+		CreateSignature:   cert.CreateSignature,
+	}
+	certified, err := certifiedKey(ak, params)
+	if err != nil {
+		return false, err
+	}
+	if !equalKeys(certified, csrKey) {
+		return false, errors.New("certified key does not match the CSR")
+	}
 	return true, nil
 }
 
+// certifiedKey returns the key that the AK certified as created in the TPM and unable to leave it
+func certifiedKey(ak *attest.AttestationParameters, params *attest.CertificationParameters) (crypto.PublicKey, error) {
+	akPub, err := attest.ParseAKPublic(attest.TPMVersion20, ak.Public)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AK: %v", err)
+	}
+
+	if err := params.Verify(attest.VerifyOpts{Public: akPub.Public, Hash: akPub.Hash}); err != nil {
+		return nil, err
+	}
+
+	pub, err := tpm2.DecodePublic(params.Public)
+	if err != nil {
+		return nil, err
+	}
+	return pub.Key()
+}
+
+func equalKeys(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// verifyDevID checks that the DevID certificate chains to roots and that its key is in the same TPM
+// as the AK, and returns the DevID certificate. Possession of the key is proven by a challenge.
+func verifyDevID(roots *x509.CertPool, ak *attest.AttestationParameters, data *common.DevIDData) (*x509.Certificate, error) {
+	leaf := data.Chain[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range data.Chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// DevIDs are not issued for a particular usage
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, err
+	}
+
+	certified, err := certifiedKey(ak, data.Certification)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certification: %v", err)
+	}
+	if !equalKeys(certified, leaf.PublicKey) {
+		return nil, errors.New("certified key does not match the DevID certificate")
+	}
+	return leaf, nil
+}
+
+// devidSelectors returns the tpm_devid selectors of a DevID certificate, e.g.
+// tpm_devid:subject:serialnumber:<serial number of the device>
+func devidSelectors(cert *x509.Certificate) []registry.Selector {
+	selectors := []registry.Selector{
+		{Type: devidSelectorType, Value: "subject:cn:" + cert.Subject.CommonName},
+		{Type: devidSelectorType, Value: "issuer:cn:" + cert.Issuer.CommonName},
+		{Type: devidSelectorType, Value: "serialnumber:" + cert.SerialNumber.Text(16)},
+	}
+	if cert.Subject.SerialNumber != "" {
+		selectors = append(selectors, registry.Selector{Type: devidSelectorType, Value: "subject:serialnumber:" + cert.Subject.SerialNumber})
+	}
+	return selectors
+}
+
 func validateAttestAgentParams(params *agent.AttestAgentRequest_Params) error {
 	switch {
 	case params == nil:
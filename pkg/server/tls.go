@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Supported client certificate policies
+const (
+	// ClientAuthNone does not ask agents for a certificate
+	ClientAuthNone = "none"
+
+	// ClientAuthVerifyIfGiven verifies X509-SVIDs presented by agents against the trust bundle,
+	// agents attesting for the first time don't have one yet and are still accepted.
+	ClientAuthVerifyIfGiven = "verify_if_given"
+
+	// ClientAuthRequire rejects connections without an X509-SVID from the trust domain. Agents
+	// attesting for the first time have none, so it only suits servers renewing X509-SVIDs.
+	ClientAuthRequire = "require"
+)
+
+const defaultServingCertTTL = 24 * time.Hour
+
+// TLSConfig configures how the server terminates TLS
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded serving certificate and key
+	CertFile string
+	KeyFile  string
+
+	// Bootstrap issues the serving certificate from the server CA instead of reading CertFile
+	// and KeyFile. It is renewed when half of its lifetime has passed.
+	Bootstrap bool
+
+	// SPIFFEID of a bootstrapped serving certificate, defaults to spiffe://<trust domain>/server
+	SPIFFEID string
+
+	// DNSNames of a bootstrapped serving certificate
+	DNSNames []string
+
+	// ClientAuth is one of none, verify_if_given or require, defaults to none
+	ClientAuth string
+}
+
+// TLSConfig returns the TLS configuration used to serve the Agent service
+func (s *Service) TLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch {
+	case cfg.Bootstrap:
+		id := cfg.SPIFFEID
+		if id == "" {
+			id = fmt.Sprintf("spiffe://%s/server", s.trustDomain)
+		}
+		u, err := common.ParseSPIFFEID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		b := &bootstrapCertificate{svc: s, id: u, dnsNames: cfg.DNSNames}
+		if _, err := b.GetCertificate(nil); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = b.GetCertificate
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load serving certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	default:
+		return nil, fmt.Errorf("either a certificate and key or bootstrapping is required to serve TLS")
+	}
+
+	bundle := x509.NewCertPool()
+	bundle.AddCert(s.ca.Certificate())
+	switch cfg.ClientAuth {
+	case "", ClientAuthNone:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case ClientAuthVerifyIfGiven:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = bundle
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = bundle
+	default:
+		return nil, fmt.Errorf("unsupported client auth: %s", cfg.ClientAuth)
+	}
+
+	return tlsConfig, nil
+}
+
+// Bundle returns the X.509 authorities of the trust domain, e.g. to serve them on a bundle endpoint
+func (s *Service) Bundle() []*x509.Certificate {
+	return []*x509.Certificate{s.ca.Certificate()}
+}
+
+// bootstrapCertificate is a serving certificate issued by the server's own CA
+type bootstrapCertificate struct {
+	svc      *Service
+	id       *url.URL
+	dnsNames []string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// GetCertificate returns the serving certificate, issuing a new one if it is past half of its lifetime
+func (b *bootstrapCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.cert != nil {
+		leaf := b.cert.Leaf
+		if now.Before(leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)) {
+			return b.cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serving key: %v", err)
+	}
+	leaf, err := b.svc.ca.SignServingCertificate(key.Public(), b.id, b.dnsNames, defaultServingCertTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue serving certificate: %v", err)
+	}
+
+	b.cert = &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	b.svc.logger.Info("issued serving certificate", "spiffe_id", b.id.String(), "expires_at", leaf.NotAfter)
+
+	return b.cert, nil
+}
+
+// CallerID returns the SPIFFE ID of the X509-SVID the caller authenticated with, if any
+func CallerID(ctx context.Context) (*url.URL, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return nil, false
+	}
+
+	for _, uri := range info.State.PeerCertificates[0].URIs {
+		if uri.Scheme == "spiffe" {
+			return uri, true
+		}
+	}
+	return nil, false
+}
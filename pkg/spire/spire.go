@@ -0,0 +1,289 @@
+// Package spire translates between the Agent service of SPIFFE Fog and the AttestAgent method of
+// SPIRE's Agent API, so that SPIRE agents can attest against the server and the agent can attest
+// against SPIRE servers.
+//
+// SPIRE only carries opaque challenges, so the typed challenges of payload version 2 and their
+// responses are sent as encoded agent.Challenge and agent.ChallengeResponse messages. SPIRE has no
+// payload version either, it is read from the TPMActivationParams of tpm_activation and tpm_devid
+// payloads, which rules out the legacy JSON payloads. SPIRE agents only receive the agent X509-SVID.
+package spire
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	agentv1 "github.com/mjlshen/spiffe_fog/proto/spire/api/server/agent/v1"
+	"github.com/mjlshen/spiffe_fog/proto/spire/api/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Attestation data types of the payloads encoded by pkg/common
+const (
+	tpmActivationType = "tpm_activation"
+	tpmDevIDType      = "tpm_devid"
+)
+
+// NewAgentServer serves SPIRE's AttestAgent with srv
+func NewAgentServer(srv agent.AgentServer) agentv1.AgentServer {
+	return &agentServer{srv: srv}
+}
+
+type agentServer struct {
+	agentv1.UnimplementedAgentServer
+	srv agent.AgentServer
+}
+
+func (s *agentServer) AttestAgent(stream agentv1.Agent_AttestAgentServer) error {
+	return s.srv.AttestAgent(&serverStream{ServerStream: stream, stream: stream})
+}
+
+// serverStream presents a SPIRE AttestAgent stream as the one of the Agent service
+type serverStream struct {
+	grpc.ServerStream
+	stream agentv1.Agent_AttestAgentServer
+
+	// version is the payload version of the attestation data the agent sent
+	version uint32
+
+	// declineCSRs is set once the server asked for workload CSRs, which SPIRE agents cannot send
+	declineCSRs bool
+}
+
+func (s *serverStream) Recv() (*agent.AttestAgentRequest, error) {
+	if s.declineCSRs {
+		s.declineCSRs = false
+		return &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_Csrs{Csrs: &agent.CSRs{}},
+		}, nil
+	}
+
+	req, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	switch step := req.Step.(type) {
+	case *agentv1.AttestAgentRequest_Params_:
+		params := &agent.AttestAgentRequest_Params{}
+		if data := step.Params.GetData(); data != nil {
+			s.version = payloadVersion(data)
+			params.Data = &agent.AttestationData{
+				Type:           data.GetType(),
+				Payload:        data.GetPayload(),
+				PayloadVersion: s.version,
+			}
+		}
+		if svidParams := step.Params.GetParams(); svidParams != nil {
+			params.Params = &agent.AgentX509SVIDParams{Csr: svidParams.GetCsr()}
+		}
+		return &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_Params_{Params: params},
+		}, nil
+	case *agentv1.AttestAgentRequest_ChallengeResponse:
+		if s.version < common.PayloadVersionTyped {
+			return &agent.AttestAgentRequest{
+				Step: &agent.AttestAgentRequest_ChallengeResponse{ChallengeResponse: step.ChallengeResponse},
+			}, nil
+		}
+		var resp agent.ChallengeResponse
+		if err := proto.Unmarshal(step.ChallengeResponse, &resp); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "malformed challenge response: %v", err)
+		}
+		return &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_TypedChallengeResponse{TypedChallengeResponse: &resp},
+		}, nil
+	default:
+		// Rejected by the server like any other request without a step
+		return &agent.AttestAgentRequest{}, nil
+	}
+}
+
+func (s *serverStream) Send(resp *agent.AttestAgentResponse) error {
+	out := &agentv1.AttestAgentResponse{}
+	switch step := resp.Step.(type) {
+	case *agent.AttestAgentResponse_Result_:
+		out.Step = &agentv1.AttestAgentResponse_Result_{
+			Result: &agentv1.AttestAgentResponse_Result{
+				Svid: toSPIRE(step.Result.GetSvid()),
+				// Agents renew their X509-SVIDs by attesting again
+				Reattestable: true,
+			},
+		}
+	case *agent.AttestAgentResponse_Challenge:
+		out.Step = &agentv1.AttestAgentResponse_Challenge{Challenge: step.Challenge}
+	case *agent.AttestAgentResponse_TypedChallenge:
+		b, err := proto.Marshal(step.TypedChallenge)
+		if err != nil {
+			return fmt.Errorf("failed to encode challenge: %v", err)
+		}
+		out.Step = &agentv1.AttestAgentResponse_Challenge{Challenge: b}
+	case *agent.AttestAgentResponse_CsrRequest:
+		// Answered by Recv without a round trip, SPIRE agents only receive the agent X509-SVID
+		s.declineCSRs = true
+		return nil
+	default:
+		return fmt.Errorf("unsupported response %T", resp.Step)
+	}
+	return s.stream.Send(out)
+}
+
+// payloadVersion reads the payload version of tpm_activation and tpm_devid data from its
+// TPMActivationParams. Payloads that cannot be decoded are passed on as version 1, for the server
+// to report why.
+func payloadVersion(data *types.AttestationData) uint32 {
+	var params *agent.TPMActivationParams
+	switch data.GetType() {
+	case tpmActivationType:
+		params = &agent.TPMActivationParams{}
+		if err := proto.Unmarshal(data.GetPayload(), params); err != nil {
+			return common.PayloadVersionProto
+		}
+	case tpmDevIDType:
+		var devid agent.TPMDevIDParams
+		if err := proto.Unmarshal(data.GetPayload(), &devid); err != nil {
+			return common.PayloadVersionProto
+		}
+		params = devid.GetActivation()
+	default:
+		return common.PayloadVersionJSON
+	}
+
+	if params.GetVersion() == common.PayloadVersionJSON {
+		return common.PayloadVersionProto
+	}
+	return params.GetVersion()
+}
+
+// NewAttestAgentClient presents a stream of SPIRE's AttestAgent as the one of the Agent service,
+// so that client.New can attest against a SPIRE server. SPIRE servers do not advertise payload
+// versions, so payload version 1 is used unless the server advertises others.
+func NewAttestAgentClient(stream agentv1.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+	return &clientStream{ClientStream: stream, stream: stream}
+}
+
+// clientStream presents a SPIRE AttestAgent stream as the one of the Agent service
+type clientStream struct {
+	grpc.ClientStream
+	stream agentv1.Agent_AttestAgentClient
+
+	// version is the payload version of the attestation data sent to the server
+	version uint32
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	md, err := s.stream.Header()
+	if err != nil {
+		return nil, err
+	}
+	if len(md.Get(common.PayloadVersionsHeader)) == 0 {
+		md = md.Copy()
+		md.Set(common.PayloadVersionsHeader, common.FormatPayloadVersions([]uint32{common.PayloadVersionProto}))
+	}
+	return md, nil
+}
+
+func (s *clientStream) Send(req *agent.AttestAgentRequest) error {
+	out := &agentv1.AttestAgentRequest{}
+	switch step := req.Step.(type) {
+	case *agent.AttestAgentRequest_Params_:
+		data := step.Params.GetData()
+		if data.GetType() == tpmActivationType && data.GetPayloadVersion() == common.PayloadVersionJSON {
+			return errors.New("legacy JSON payloads cannot be sent over the SPIRE Agent API")
+		}
+		if step.Params.GetParams().GetKeyCertification() != nil {
+			return errors.New("the SPIRE Agent API cannot carry the certification of TPM keys")
+		}
+		s.version = data.GetPayloadVersion()
+		out.Step = &agentv1.AttestAgentRequest_Params_{
+			Params: &agentv1.AttestAgentRequest_Params{
+				Data: &types.AttestationData{
+					Type:    data.GetType(),
+					Payload: data.GetPayload(),
+				},
+				Params: &agentv1.AgentX509SVIDParams{Csr: step.Params.GetParams().GetCsr()},
+			},
+		}
+	case *agent.AttestAgentRequest_ChallengeResponse:
+		out.Step = &agentv1.AttestAgentRequest_ChallengeResponse{ChallengeResponse: step.ChallengeResponse}
+	case *agent.AttestAgentRequest_TypedChallengeResponse:
+		b, err := proto.Marshal(step.TypedChallengeResponse)
+		if err != nil {
+			return fmt.Errorf("failed to encode challenge response: %v", err)
+		}
+		out.Step = &agentv1.AttestAgentRequest_ChallengeResponse{ChallengeResponse: b}
+	default:
+		return fmt.Errorf("unsupported request %T", req.Step)
+	}
+	return s.stream.Send(out)
+}
+
+// Recv returns the result of a SPIRE server with the agent X509-SVID as its only X509-SVID. SPIRE
+// servers do not return the bundle, which the agent must already trust to connect to them.
+func (s *clientStream) Recv() (*agent.AttestAgentResponse, error) {
+	resp, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	switch step := resp.Step.(type) {
+	case *agentv1.AttestAgentResponse_Result_:
+		svid := fromSPIRE(step.Result.GetSvid())
+		return &agent.AttestAgentResponse{
+			Step: &agent.AttestAgentResponse_Result_{
+				Result: &agent.AttestAgentResponse_Result{
+					Svid:  svid,
+					Svids: []*agent.X509SVID{svid},
+				},
+			},
+		}, nil
+	case *agentv1.AttestAgentResponse_Challenge:
+		if s.version < common.PayloadVersionTyped {
+			return &agent.AttestAgentResponse{
+				Step: &agent.AttestAgentResponse_Challenge{Challenge: step.Challenge},
+			}, nil
+		}
+		var challenge agent.Challenge
+		if err := proto.Unmarshal(step.Challenge, &challenge); err != nil {
+			return nil, fmt.Errorf("malformed challenge: %v", err)
+		}
+		return &agent.AttestAgentResponse{
+			Step: &agent.AttestAgentResponse_TypedChallenge{TypedChallenge: &challenge},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported response %T", resp.Step)
+	}
+}
+
+func toSPIRE(svid *agent.X509SVID) *types.X509SVID {
+	if svid == nil {
+		return nil
+	}
+	return &types.X509SVID{
+		Id: &types.SPIFFEID{
+			TrustDomain: svid.GetId().GetTrustDomain(),
+			Path:        svid.GetId().GetPath(),
+		},
+		CertChain: svid.GetCertChain(),
+		ExpiresAt: svid.GetExpiresAt(),
+	}
+}
+
+func fromSPIRE(svid *types.X509SVID) *agent.X509SVID {
+	if svid == nil {
+		return nil
+	}
+	return &agent.X509SVID{
+		Id: &agent.SPIFFEID{
+			TrustDomain: svid.GetId().GetTrustDomain(),
+			Path:        svid.GetId().GetPath(),
+		},
+		CertChain: svid.GetCertChain(),
+		ExpiresAt: svid.GetExpiresAt(),
+	}
+}
@@ -0,0 +1,36 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Supported log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// ParseLevel parses a log level, which is one of debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+	return l, nil
+}
+
+// NewLogger returns a logger writing to w that drops records below level. Passing a
+// *slog.LevelVar allows the level to be changed later. format is either text or json.
+func NewLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
@@ -0,0 +1,198 @@
+package workload
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+)
+
+const (
+	defaultProcRoot = "/proc"
+
+	unixSelectorType   = "unix"
+	cgroupSelectorType = "cgroup"
+)
+
+// containerIDPattern matches the 64 character IDs used by docker, containerd and cri-o in cgroup paths
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Attestor produces selectors for the process identified by cred
+type Attestor interface {
+	Attest(ctx context.Context, cred PeerCred) ([]registry.Selector, error)
+}
+
+// procRooter is implemented by attestors that read procfs from a configurable mount point
+type procRooter interface {
+	procRoot() string
+}
+
+// Attest runs every attestor against the process identified by cred. It fails if the process
+// exits or its PID is reused while being attested, since the selectors could describe another
+// process. The process is identified in the procfs of every attestor, so that attestors reading
+// another procfs, e.g. of the host from a container, check the process they describe.
+func Attest(ctx context.Context, attestors []Attestor, cred PeerCred) ([]registry.Selector, error) {
+	started := map[string]uint64{}
+	for _, a := range attestors {
+		root := defaultProcRoot
+		if r, ok := a.(procRooter); ok {
+			root = r.procRoot()
+		}
+		started[root] = 0
+	}
+	if len(started) == 0 {
+		started[defaultProcRoot] = 0
+	}
+	for root := range started {
+		t, err := processStartTime(root, cred.PID)
+		if err != nil {
+			return nil, err
+		}
+		started[root] = t
+	}
+
+	var selectors []registry.Selector
+	for _, a := range attestors {
+		s, err := a.Attest(ctx, cred)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, s...)
+	}
+
+	for root, t := range started {
+		if after, err := processStartTime(root, cred.PID); err != nil || after != t {
+			return nil, fmt.Errorf("process %d exited during attestation", cred.PID)
+		}
+	}
+
+	return selectors, nil
+}
+
+// UnixAttestor produces unix:uid, unix:gid, unix:path and unix:sha256 selectors
+type UnixAttestor struct {
+	// ProcRoot is where procfs is mounted, defaults to /proc
+	ProcRoot string
+}
+
+func (a UnixAttestor) procRoot() string {
+	return procRoot(a.ProcRoot)
+}
+
+func (a UnixAttestor) Attest(_ context.Context, cred PeerCred) ([]registry.Selector, error) {
+	exe := procPath(a.ProcRoot, cred.PID, "exe")
+
+	path, err := os.Readlink(exe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve binary of process %d: %v", cred.PID, err)
+	}
+
+	// Hash through /proc/<pid>/exe rather than path so that the binary that is
+	// actually running is measured, even if it was replaced on disk.
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open binary of process %d: %v", cred.PID, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash binary of process %d: %v", cred.PID, err)
+	}
+
+	return []registry.Selector{
+		{Type: unixSelectorType, Value: fmt.Sprintf("uid:%d", cred.UID)},
+		{Type: unixSelectorType, Value: fmt.Sprintf("gid:%d", cred.GID)},
+		{Type: unixSelectorType, Value: "path:" + strings.TrimSuffix(path, " (deleted)")},
+		{Type: unixSelectorType, Value: fmt.Sprintf("sha256:%x", h.Sum(nil))},
+	}, nil
+}
+
+// CgroupAttestor produces cgroup:path selectors for every cgroup of the process and a
+// cgroup:container_id selector if the process runs in a container.
+type CgroupAttestor struct {
+	// ProcRoot is where procfs is mounted, defaults to /proc
+	ProcRoot string
+}
+
+func (a CgroupAttestor) procRoot() string {
+	return procRoot(a.ProcRoot)
+}
+
+func (a CgroupAttestor) Attest(_ context.Context, cred PeerCred) ([]registry.Selector, error) {
+	f, err := os.Open(procPath(a.ProcRoot, cred.PID, "cgroup"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroups of process %d: %v", cred.PID, err)
+	}
+	defer f.Close()
+
+	var selectors []registry.Selector
+	var containerID string
+	seen := map[string]bool{}
+
+	// Each line is hierarchy-ID:controller-list:cgroup-path
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 || seen[parts[2]] {
+			continue
+		}
+		seen[parts[2]] = true
+
+		selectors = append(selectors, registry.Selector{Type: cgroupSelectorType, Value: "path:" + parts[2]})
+		if id := containerIDPattern.FindString(parts[2]); id != "" && containerID == "" {
+			containerID = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cgroups of process %d: %v", cred.PID, err)
+	}
+
+	if containerID != "" {
+		selectors = append(selectors, registry.Selector{Type: cgroupSelectorType, Value: "container_id:" + containerID})
+	}
+	return selectors, nil
+}
+
+// procRoot returns where procfs is mounted, given the configured root
+func procRoot(root string) string {
+	if root == "" {
+		return defaultProcRoot
+	}
+	return root
+}
+
+func procPath(root string, pid int32, name string) string {
+	return filepath.Join(procRoot(root), strconv.Itoa(int(pid)), name)
+}
+
+// processStartTime returns the start time of the process in clock ticks since boot, which
+// together with the PID uniquely identifies a process.
+func processStartTime(root string, pid int32) (uint64, error) {
+	stat, err := os.ReadFile(procPath(root, pid, "stat"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process %d: %v", pid, err)
+	}
+
+	// The command name is wrapped in parentheses and may itself contain spaces
+	// or parentheses, so the remaining fields start after the last ')'.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+
+	// starttime is field 22, the fields after the command name start at field 3
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
@@ -0,0 +1,63 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// PeerCred identifies the process on the other end of a unix socket
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// AuthInfo is attached to connections accepted with Credentials and carries the caller's PeerCred
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+	PeerCred
+}
+
+func (AuthInfo) AuthType() string {
+	return "peercred"
+}
+
+type peerCredentials struct{}
+
+// Credentials returns server transport credentials that record the SO_PEERCRED
+// of every connection made over a unix socket.
+func Credentials() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+func (peerCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are only supported by servers")
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cred, err := getPeerCred(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity},
+		PeerCred:       cred,
+	}, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package workload
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func getPeerCred(conn net.Conn) (PeerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, fmt.Errorf("peer credentials require a unix socket, got %T", conn)
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return PeerCred{}, fmt.Errorf("failed to access socket: %v", err)
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, fmt.Errorf("failed to access socket: %v", err)
+	}
+	if credErr != nil {
+		return PeerCred{}, fmt.Errorf("failed to read SO_PEERCRED: %v", credErr)
+	}
+
+	return PeerCred{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
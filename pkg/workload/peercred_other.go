@@ -0,0 +1,12 @@
+//go:build !linux
+
+package workload
+
+import (
+	"errors"
+	"net"
+)
+
+func getPeerCred(net.Conn) (PeerCred, error) {
+	return PeerCred{}, errors.New("peer credentials are only supported on linux")
+}
@@ -0,0 +1,176 @@
+package workload
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	workloadapi "github.com/mjlshen/spiffe_fog/proto/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// securityHeader must be set by Workload API clients to protect against SSRF
+const securityHeader = "workload.spiffe.io"
+
+// Cache holds the result of the latest attestation and notifies readers when it changes
+type Cache struct {
+	mu      sync.RWMutex
+	result  *client.Result
+	changed chan struct{}
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		changed: make(chan struct{}),
+	}
+}
+
+// Update replaces the cached result, e.g. after the agent re-attests
+func (c *Cache) Update(r *client.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result = r
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Result returns the cached result and a channel that is closed when it is replaced
+func (c *Cache) Result() (*client.Result, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.result, c.changed
+}
+
+// Server implements the SPIFFE Workload API, handing each caller only the
+// X509-SVIDs whose selectors are satisfied by the attested caller.
+type Server struct {
+	workloadapi.UnimplementedSpiffeWorkloadAPIServer
+
+	cache     *Cache
+	attestors []Attestor
+}
+
+func NewServer(cache *Cache, attestors ...Attestor) *Server {
+	return &Server{
+		cache:     cache,
+		attestors: attestors,
+	}
+}
+
+// ListenAndServe serves the Workload API on a unix socket at path, replacing any stale socket
+func ListenAndServe(path string, srv *Server) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer(grpc.Creds(Credentials()))
+	workloadapi.RegisterSpiffeWorkloadAPIServer(s, srv)
+	return s.Serve(listener)
+}
+
+func (s *Server) FetchX509SVID(_ *workloadapi.X509SVIDRequest, stream workloadapi.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	ctx := stream.Context()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(securityHeader); len(v) != 1 || v[0] != "true" {
+		return status.Error(codes.InvalidArgument, "security header missing from request")
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Internal, "missing peer information")
+	}
+	info, ok := p.AuthInfo.(AuthInfo)
+	if !ok {
+		return status.Error(codes.Internal, "missing peer credentials")
+	}
+
+	selectors, err := Attest(ctx, s.attestors, info.PeerCred)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "workload attestation failed: %v", err)
+	}
+
+	for {
+		result, changed := s.cache.Result()
+		resp, err := x509SVIDResponse(result, selectors)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to build response: %v", err)
+		}
+		if len(resp.Svids) == 0 {
+			return status.Error(codes.PermissionDenied, "no identity issued")
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// x509SVIDResponse collects every X509-SVID in result whose selectors are satisfied by selectors,
+// along with the federated bundles. The agent X509-SVID is never handed out, its key proves the
+// identity of the node.
+func x509SVIDResponse(result *client.Result, selectors []registry.Selector) (*workloadapi.X509SVIDResponse, error) {
+	resp := &workloadapi.X509SVIDResponse{}
+	if result == nil {
+		return resp, nil
+	}
+
+	var bundle []byte
+	for _, cert := range result.Bundle {
+		bundle = append(bundle, cert.Raw...)
+	}
+	for td, certs := range result.FederatedBundles {
+		if resp.FederatedBundles == nil {
+			resp.FederatedBundles = map[string][]byte{}
+		}
+		var federated []byte
+		for _, cert := range certs {
+			federated = append(federated, cert.Raw...)
+		}
+		resp.FederatedBundles["spiffe://"+td] = federated
+	}
+
+	for _, svid := range result.SVIDs {
+		if svid == result.SVID || !registry.IsSubset(svid.Selectors, selectors) {
+			continue
+		}
+
+		key, err := x509.MarshalPKCS8PrivateKey(svid.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal key for %s: %v", svid.ID, err)
+		}
+
+		var chain []byte
+		for _, cert := range svid.Certificates {
+			chain = append(chain, cert.Raw...)
+		}
+
+		resp.Svids = append(resp.Svids, &workloadapi.X509SVID{
+			SpiffeId:    svid.ID,
+			X509Svid:    chain,
+			X509SvidKey: key,
+			Bundle:      bundle,
+		})
+	}
+
+	return resp, nil
+}
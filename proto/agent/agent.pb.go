@@ -26,26 +26,903 @@ type AttestationData struct {
 	// that produced that data.
 	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
 	// The attestation data payload.
-	Payload       []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	// The encoding of the payload and of the challenges that follow it. 0 is
+	// the legacy JSON encoding of go-attestation structs, 1 is
+	// TPMActivationParams and TPMActivationChallenge, 2 is TPMActivationParams
+	// followed by any number of typed Challenge rounds.
+	PayloadVersion uint32 `protobuf:"varint,3,opt,name=payload_version,json=payloadVersion,proto3" json:"payload_version,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *AttestationData) Reset() {
+	*x = AttestationData{}
+	mi := &file_agent_agent_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestationData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestationData) ProtoMessage() {}
+
+func (x *AttestationData) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestationData.ProtoReflect.Descriptor instead.
+func (*AttestationData) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AttestationData) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AttestationData) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *AttestationData) GetPayloadVersion() uint32 {
+	if x != nil {
+		return x.PayloadVersion
+	}
+	return 0
+}
+
+// The payload of tpm_activation attestation data from payload version 1.
+type TPMActivationParams struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The payload version, which must match AttestationData.payload_version.
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// Required. The endorsement key (ASN.1 DER encoded).
+	//
+	// Types that are valid to be assigned to Ek:
+	//
+	//	*TPMActivationParams_EkCertificate
+	//	*TPMActivationParams_EkPublicKey
+	Ek isTPMActivationParams_Ek `protobuf_oneof:"ek"`
+	// Required. The TPMT_PUBLIC area of the attestation key.
+	AkPublic []byte `protobuf:"bytes,4,opt,name=ak_public,json=akPublic,proto3" json:"ak_public,omitempty"`
+	// Required. The TPMS_CREATION_DATA of the attestation key.
+	AkCreateData []byte `protobuf:"bytes,5,opt,name=ak_create_data,json=akCreateData,proto3" json:"ak_create_data,omitempty"`
+	// Required. The TPMS_ATTEST structure certifying the creation data.
+	AkCreateAttestation []byte `protobuf:"bytes,6,opt,name=ak_create_attestation,json=akCreateAttestation,proto3" json:"ak_create_attestation,omitempty"`
+	// Required. The TPMT_SIGNATURE of ak_create_attestation by the
+	// attestation key.
+	AkCreateSignature []byte `protobuf:"bytes,7,opt,name=ak_create_signature,json=akCreateSignature,proto3" json:"ak_create_signature,omitempty"`
+	// Whether the AK expects the TrouSerS activation format, only used by
+	// TPM 1.2.
+	AkTcsdActivationFormat bool `protobuf:"varint,8,opt,name=ak_tcsd_activation_format,json=akTcsdActivationFormat,proto3" json:"ak_tcsd_activation_format,omitempty"`
+	unknownFields          protoimpl.UnknownFields
+	sizeCache              protoimpl.SizeCache
+}
+
+func (x *TPMActivationParams) Reset() {
+	*x = TPMActivationParams{}
+	mi := &file_agent_agent_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TPMActivationParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TPMActivationParams) ProtoMessage() {}
+
+func (x *TPMActivationParams) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TPMActivationParams.ProtoReflect.Descriptor instead.
+func (*TPMActivationParams) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TPMActivationParams) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *TPMActivationParams) GetEk() isTPMActivationParams_Ek {
+	if x != nil {
+		return x.Ek
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetEkCertificate() []byte {
+	if x != nil {
+		if x, ok := x.Ek.(*TPMActivationParams_EkCertificate); ok {
+			return x.EkCertificate
+		}
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetEkPublicKey() []byte {
+	if x != nil {
+		if x, ok := x.Ek.(*TPMActivationParams_EkPublicKey); ok {
+			return x.EkPublicKey
+		}
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetAkPublic() []byte {
+	if x != nil {
+		return x.AkPublic
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetAkCreateData() []byte {
+	if x != nil {
+		return x.AkCreateData
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetAkCreateAttestation() []byte {
+	if x != nil {
+		return x.AkCreateAttestation
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetAkCreateSignature() []byte {
+	if x != nil {
+		return x.AkCreateSignature
+	}
+	return nil
+}
+
+func (x *TPMActivationParams) GetAkTcsdActivationFormat() bool {
+	if x != nil {
+		return x.AkTcsdActivationFormat
+	}
+	return false
+}
+
+type isTPMActivationParams_Ek interface {
+	isTPMActivationParams_Ek()
+}
+
+type TPMActivationParams_EkCertificate struct {
+	// The EK certificate, if the TPM was provisioned with one.
+	EkCertificate []byte `protobuf:"bytes,2,opt,name=ek_certificate,json=ekCertificate,proto3,oneof"`
+}
+
+type TPMActivationParams_EkPublicKey struct {
+	// The PKIX public key of the EK, if the TPM has no EK certificate.
+	EkPublicKey []byte `protobuf:"bytes,3,opt,name=ek_public_key,json=ekPublicKey,proto3,oneof"`
+}
+
+func (*TPMActivationParams_EkCertificate) isTPMActivationParams_Ek() {}
+
+func (*TPMActivationParams_EkPublicKey) isTPMActivationParams_Ek() {}
+
+// The payload of tpm_devid attestation data, from payload version 2. The
+// DevID is an IEEE 802.1AR device identity provisioned in the TPM.
+type TPMDevIDParams struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The EK and AK, the AK is bound to the EK by an activation
+	// challenge. Its version must match AttestationData.payload_version.
+	Activation *TPMActivationParams `protobuf:"bytes,1,opt,name=activation,proto3" json:"activation,omitempty"`
+	// Required. The DevID certificate followed by its intermediates (ASN.1 DER
+	// encoded).
+	DevidChain [][]byte `protobuf:"bytes,2,rep,name=devid_chain,json=devidChain,proto3" json:"devid_chain,omitempty"`
+	// Required. The certification of the DevID key by the AK, which proves
+	// that the DevID key lives in the same TPM as the AK.
+	DevidCertification *KeyCertification `protobuf:"bytes,3,opt,name=devid_certification,json=devidCertification,proto3" json:"devid_certification,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *TPMDevIDParams) Reset() {
+	*x = TPMDevIDParams{}
+	mi := &file_agent_agent_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TPMDevIDParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TPMDevIDParams) ProtoMessage() {}
+
+func (x *TPMDevIDParams) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TPMDevIDParams.ProtoReflect.Descriptor instead.
+func (*TPMDevIDParams) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TPMDevIDParams) GetActivation() *TPMActivationParams {
+	if x != nil {
+		return x.Activation
+	}
+	return nil
+}
+
+func (x *TPMDevIDParams) GetDevidChain() [][]byte {
+	if x != nil {
+		return x.DevidChain
+	}
+	return nil
+}
+
+func (x *TPMDevIDParams) GetDevidCertification() *KeyCertification {
+	if x != nil {
+		return x.DevidCertification
+	}
+	return nil
+}
+
+// A tpm_activation challenge from payload version 1. The agent answers it
+// with the secret recovered by TPM2_ActivateCredential.
+type TPMActivationChallenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The payload version, which matches the one of the params.
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// The TPM2B_ID_OBJECT credential blob.
+	Credential []byte `protobuf:"bytes,2,opt,name=credential,proto3" json:"credential,omitempty"`
+	// The TPM2B_ENCRYPTED_SECRET seed, encrypted to the EK.
+	Secret        []byte `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TPMActivationChallenge) Reset() {
+	*x = TPMActivationChallenge{}
+	mi := &file_agent_agent_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TPMActivationChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TPMActivationChallenge) ProtoMessage() {}
+
+func (x *TPMActivationChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TPMActivationChallenge.ProtoReflect.Descriptor instead.
+func (*TPMActivationChallenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TPMActivationChallenge) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *TPMActivationChallenge) GetCredential() []byte {
+	if x != nil {
+		return x.Credential
+	}
+	return nil
+}
+
+func (x *TPMActivationChallenge) GetSecret() []byte {
+	if x != nil {
+		return x.Secret
+	}
+	return nil
+}
+
+// A challenge issued by the server from payload version 2. Every round is
+// answered by a ChallengeResponse of the same round and kind before the
+// server issues the next one or the result.
+type Challenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The round of the challenge, starting at 1.
+	Round uint32 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	// Required. The kind of challenge.
+	//
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*Challenge_Activation
+	//	*Challenge_Quote
+	//	*Challenge_Nonce
+	//	*Challenge_Devid
+	//	*Challenge_Binding
+	Kind          isChallenge_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Challenge) Reset() {
+	*x = Challenge{}
+	mi := &file_agent_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Challenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Challenge) ProtoMessage() {}
+
+func (x *Challenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Challenge.ProtoReflect.Descriptor instead.
+func (*Challenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Challenge) GetRound() uint32 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *Challenge) GetKind() isChallenge_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *Challenge) GetActivation() *TPMActivationChallenge {
+	if x != nil {
+		if x, ok := x.Kind.(*Challenge_Activation); ok {
+			return x.Activation
+		}
+	}
+	return nil
+}
+
+func (x *Challenge) GetQuote() *QuoteChallenge {
+	if x != nil {
+		if x, ok := x.Kind.(*Challenge_Quote); ok {
+			return x.Quote
+		}
+	}
+	return nil
+}
+
+func (x *Challenge) GetNonce() *NonceChallenge {
+	if x != nil {
+		if x, ok := x.Kind.(*Challenge_Nonce); ok {
+			return x.Nonce
+		}
+	}
+	return nil
+}
+
+func (x *Challenge) GetDevid() *DevIDChallenge {
+	if x != nil {
+		if x, ok := x.Kind.(*Challenge_Devid); ok {
+			return x.Devid
+		}
+	}
+	return nil
+}
+
+func (x *Challenge) GetBinding() *BindingChallenge {
+	if x != nil {
+		if x, ok := x.Kind.(*Challenge_Binding); ok {
+			return x.Binding
+		}
+	}
+	return nil
+}
+
+type isChallenge_Kind interface {
+	isChallenge_Kind()
+}
+
+type Challenge_Activation struct {
+	// Prove the AK and EK live in the same TPM with TPM2_ActivateCredential.
+	Activation *TPMActivationChallenge `protobuf:"bytes,2,opt,name=activation,proto3,oneof"`
+}
+
+type Challenge_Quote struct {
+	// Quote the PCRs with the AK.
+	Quote *QuoteChallenge `protobuf:"bytes,3,opt,name=quote,proto3,oneof"`
+}
+
+type Challenge_Nonce struct {
+	// Sign a nonce with the private key of the CSR.
+	Nonce *NonceChallenge `protobuf:"bytes,4,opt,name=nonce,proto3,oneof"`
+}
+
+type Challenge_Devid struct {
+	// Sign a nonce with the DevID key, only issued for tpm_devid.
+	Devid *DevIDChallenge `protobuf:"bytes,5,opt,name=devid,proto3,oneof"`
+}
+
+type Challenge_Binding struct {
+	// Bind the CSR to the AK with a quote.
+	Binding *BindingChallenge `protobuf:"bytes,6,opt,name=binding,proto3,oneof"`
+}
+
+func (*Challenge_Activation) isChallenge_Kind() {}
+
+func (*Challenge_Quote) isChallenge_Kind() {}
+
+func (*Challenge_Nonce) isChallenge_Kind() {}
+
+func (*Challenge_Devid) isChallenge_Kind() {}
+
+func (*Challenge_Binding) isChallenge_Kind() {}
+
+// Asks the agent for a TPM2_Quote over all PCRs of a bank, signed by the AK.
+type QuoteChallenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The qualifying data to include in the quote.
+	Nonce []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Required. The TPM_ALG_ID of the PCR bank to quote, e.g. 0x000B for
+	// SHA256.
+	HashAlgorithm uint32 `protobuf:"varint,2,opt,name=hash_algorithm,json=hashAlgorithm,proto3" json:"hash_algorithm,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QuoteChallenge) Reset() {
+	*x = QuoteChallenge{}
+	mi := &file_agent_agent_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuoteChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuoteChallenge) ProtoMessage() {}
+
+func (x *QuoteChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuoteChallenge.ProtoReflect.Descriptor instead.
+func (*QuoteChallenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QuoteChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *QuoteChallenge) GetHashAlgorithm() uint32 {
+	if x != nil {
+		return x.HashAlgorithm
+	}
+	return 0
+}
+
+// Asks the agent to prove it holds the private key of its CSR right now.
+type NonceChallenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The nonce to sign.
+	Nonce         []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NonceChallenge) Reset() {
+	*x = NonceChallenge{}
+	mi := &file_agent_agent_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NonceChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NonceChallenge) ProtoMessage() {}
+
+func (x *NonceChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NonceChallenge.ProtoReflect.Descriptor instead.
+func (*NonceChallenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *NonceChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+// Asks the agent to prove it holds the DevID key right now.
+type DevIDChallenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The nonce to sign.
+	Nonce         []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DevIDChallenge) Reset() {
+	*x = DevIDChallenge{}
+	mi := &file_agent_agent_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DevIDChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DevIDChallenge) ProtoMessage() {}
+
+func (x *DevIDChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DevIDChallenge.ProtoReflect.Descriptor instead.
+func (*DevIDChallenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DevIDChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+// Asks the agent for a TPM2_Quote over all PCRs of the SHA256 bank, signed by
+// the AK, whose qualifying data is SHA256(SHA256(CSR public key) || nonce).
+// The CSR public key is its DER encoded SubjectPublicKeyInfo. This binds the
+// CSR to the TPM holding the AK, so that a relayed attestation cannot be
+// completed with another CSR.
+type BindingChallenge struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The nonce to bind the CSR with.
+	Nonce         []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BindingChallenge) Reset() {
+	*x = BindingChallenge{}
+	mi := &file_agent_agent_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BindingChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BindingChallenge) ProtoMessage() {}
+
+func (x *BindingChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BindingChallenge.ProtoReflect.Descriptor instead.
+func (*BindingChallenge) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BindingChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type ChallengeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The round of the challenge being answered.
+	Round uint32 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	// Required. Matches the kind of the challenge.
+	//
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*ChallengeResponse_Activation
+	//	*ChallengeResponse_Quote
+	//	*ChallengeResponse_Nonce
+	//	*ChallengeResponse_Devid
+	//	*ChallengeResponse_Binding
+	Kind          isChallengeResponse_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChallengeResponse) Reset() {
+	*x = ChallengeResponse{}
+	mi := &file_agent_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChallengeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChallengeResponse) ProtoMessage() {}
+
+func (x *ChallengeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChallengeResponse.ProtoReflect.Descriptor instead.
+func (*ChallengeResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ChallengeResponse) GetRound() uint32 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *ChallengeResponse) GetKind() isChallengeResponse_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *ChallengeResponse) GetActivation() *ActivationResponse {
+	if x != nil {
+		if x, ok := x.Kind.(*ChallengeResponse_Activation); ok {
+			return x.Activation
+		}
+	}
+	return nil
+}
+
+func (x *ChallengeResponse) GetQuote() *QuoteResponse {
+	if x != nil {
+		if x, ok := x.Kind.(*ChallengeResponse_Quote); ok {
+			return x.Quote
+		}
+	}
+	return nil
+}
+
+func (x *ChallengeResponse) GetNonce() *NonceResponse {
+	if x != nil {
+		if x, ok := x.Kind.(*ChallengeResponse_Nonce); ok {
+			return x.Nonce
+		}
+	}
+	return nil
+}
+
+func (x *ChallengeResponse) GetDevid() *DevIDResponse {
+	if x != nil {
+		if x, ok := x.Kind.(*ChallengeResponse_Devid); ok {
+			return x.Devid
+		}
+	}
+	return nil
+}
+
+func (x *ChallengeResponse) GetBinding() *QuoteResponse {
+	if x != nil {
+		if x, ok := x.Kind.(*ChallengeResponse_Binding); ok {
+			return x.Binding
+		}
+	}
+	return nil
+}
+
+type isChallengeResponse_Kind interface {
+	isChallengeResponse_Kind()
+}
+
+type ChallengeResponse_Activation struct {
+	Activation *ActivationResponse `protobuf:"bytes,2,opt,name=activation,proto3,oneof"`
+}
+
+type ChallengeResponse_Quote struct {
+	Quote *QuoteResponse `protobuf:"bytes,3,opt,name=quote,proto3,oneof"`
+}
+
+type ChallengeResponse_Nonce struct {
+	Nonce *NonceResponse `protobuf:"bytes,4,opt,name=nonce,proto3,oneof"`
+}
+
+type ChallengeResponse_Devid struct {
+	Devid *DevIDResponse `protobuf:"bytes,5,opt,name=devid,proto3,oneof"`
+}
+
+type ChallengeResponse_Binding struct {
+	Binding *QuoteResponse `protobuf:"bytes,6,opt,name=binding,proto3,oneof"`
+}
+
+func (*ChallengeResponse_Activation) isChallengeResponse_Kind() {}
+
+func (*ChallengeResponse_Quote) isChallengeResponse_Kind() {}
+
+func (*ChallengeResponse_Nonce) isChallengeResponse_Kind() {}
+
+func (*ChallengeResponse_Devid) isChallengeResponse_Kind() {}
+
+func (*ChallengeResponse_Binding) isChallengeResponse_Kind() {}
+
+type ActivationResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The secret recovered by TPM2_ActivateCredential.
+	Secret        []byte `protobuf:"bytes,1,opt,name=secret,proto3" json:"secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActivationResponse) Reset() {
+	*x = ActivationResponse{}
+	mi := &file_agent_agent_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActivationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActivationResponse) ProtoMessage() {}
+
+func (x *ActivationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActivationResponse.ProtoReflect.Descriptor instead.
+func (*ActivationResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ActivationResponse) GetSecret() []byte {
+	if x != nil {
+		return x.Secret
+	}
+	return nil
+}
+
+type QuoteResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The TPMS_ATTEST structure of the quote.
+	Quote []byte `protobuf:"bytes,1,opt,name=quote,proto3" json:"quote,omitempty"`
+	// Required. The TPMT_SIGNATURE of quote by the AK.
+	Signature []byte `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Required. The value of every quoted PCR.
+	Pcrs          []*PCR `protobuf:"bytes,3,rep,name=pcrs,proto3" json:"pcrs,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AttestationData) Reset() {
-	*x = AttestationData{}
-	mi := &file_agent_agent_proto_msgTypes[0]
+func (x *QuoteResponse) Reset() {
+	*x = QuoteResponse{}
+	mi := &file_agent_agent_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AttestationData) String() string {
+func (x *QuoteResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AttestationData) ProtoMessage() {}
+func (*QuoteResponse) ProtoMessage() {}
 
-func (x *AttestationData) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[0]
+func (x *QuoteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -56,21 +933,173 @@ func (x *AttestationData) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AttestationData.ProtoReflect.Descriptor instead.
-func (*AttestationData) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use QuoteResponse.ProtoReflect.Descriptor instead.
+func (*QuoteResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{11}
 }
 
-func (x *AttestationData) GetType() string {
+func (x *QuoteResponse) GetQuote() []byte {
 	if x != nil {
-		return x.Type
+		return x.Quote
 	}
-	return ""
+	return nil
 }
 
-func (x *AttestationData) GetPayload() []byte {
+func (x *QuoteResponse) GetSignature() []byte {
 	if x != nil {
-		return x.Payload
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *QuoteResponse) GetPcrs() []*PCR {
+	if x != nil {
+		return x.Pcrs
+	}
+	return nil
+}
+
+// The value of a PCR of the quoted bank.
+type PCR struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint32                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Digest        []byte                 `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PCR) Reset() {
+	*x = PCR{}
+	mi := &file_agent_agent_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PCR) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PCR) ProtoMessage() {}
+
+func (x *PCR) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PCR.ProtoReflect.Descriptor instead.
+func (*PCR) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PCR) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *PCR) GetDigest() []byte {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+type NonceResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The ASN.1 signature of the SHA256 digest of the nonce by the
+	// private key of the CSR.
+	Signature     []byte `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NonceResponse) Reset() {
+	*x = NonceResponse{}
+	mi := &file_agent_agent_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NonceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NonceResponse) ProtoMessage() {}
+
+func (x *NonceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NonceResponse.ProtoReflect.Descriptor instead.
+func (*NonceResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *NonceResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type DevIDResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The ASN.1 signature of the SHA256 digest of the nonce by the
+	// DevID key.
+	Signature     []byte `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DevIDResponse) Reset() {
+	*x = DevIDResponse{}
+	mi := &file_agent_agent_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DevIDResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DevIDResponse) ProtoMessage() {}
+
+func (x *DevIDResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DevIDResponse.ProtoReflect.Descriptor instead.
+func (*DevIDResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DevIDResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
 	}
 	return nil
 }
@@ -83,6 +1112,8 @@ type AttestAgentRequest struct {
 	//
 	//	*AttestAgentRequest_Params_
 	//	*AttestAgentRequest_ChallengeResponse
+	//	*AttestAgentRequest_TypedChallengeResponse
+	//	*AttestAgentRequest_Csrs
 	Step          isAttestAgentRequest_Step `protobuf_oneof:"step"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -90,7 +1121,7 @@ type AttestAgentRequest struct {
 
 func (x *AttestAgentRequest) Reset() {
 	*x = AttestAgentRequest{}
-	mi := &file_agent_agent_proto_msgTypes[1]
+	mi := &file_agent_agent_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -102,7 +1133,7 @@ func (x *AttestAgentRequest) String() string {
 func (*AttestAgentRequest) ProtoMessage() {}
 
 func (x *AttestAgentRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[1]
+	mi := &file_agent_agent_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -115,7 +1146,7 @@ func (x *AttestAgentRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AttestAgentRequest.ProtoReflect.Descriptor instead.
 func (*AttestAgentRequest) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{1}
+	return file_agent_agent_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *AttestAgentRequest) GetStep() isAttestAgentRequest_Step {
@@ -143,6 +1174,24 @@ func (x *AttestAgentRequest) GetChallengeResponse() []byte {
 	return nil
 }
 
+func (x *AttestAgentRequest) GetTypedChallengeResponse() *ChallengeResponse {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentRequest_TypedChallengeResponse); ok {
+			return x.TypedChallengeResponse
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentRequest) GetCsrs() *CSRs {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentRequest_Csrs); ok {
+			return x.Csrs
+		}
+	}
+	return nil
+}
+
 type isAttestAgentRequest_Step interface {
 	isAttestAgentRequest_Step()
 }
@@ -158,36 +1207,171 @@ type AttestAgentRequest_ChallengeResponse struct {
 	ChallengeResponse []byte `protobuf:"bytes,2,opt,name=challenge_response,json=challengeResponse,proto3,oneof"`
 }
 
-func (*AttestAgentRequest_Params_) isAttestAgentRequest_Step() {}
+type AttestAgentRequest_TypedChallengeResponse struct {
+	// The response to a typed challenge, from payload version 2.
+	TypedChallengeResponse *ChallengeResponse `protobuf:"bytes,3,opt,name=typed_challenge_response,json=typedChallengeResponse,proto3,oneof"`
+}
+
+type AttestAgentRequest_Csrs struct {
+	// The answer to a csr_request, from payload version 2.
+	Csrs *CSRs `protobuf:"bytes,4,opt,name=csrs,proto3,oneof"`
+}
+
+func (*AttestAgentRequest_Params_) isAttestAgentRequest_Step() {}
+
+func (*AttestAgentRequest_ChallengeResponse) isAttestAgentRequest_Step() {}
+
+func (*AttestAgentRequest_TypedChallengeResponse) isAttestAgentRequest_Step() {}
+
+func (*AttestAgentRequest_Csrs) isAttestAgentRequest_Step() {}
+
+type AttestAgentResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Step:
+	//
+	//	*AttestAgentResponse_Result_
+	//	*AttestAgentResponse_Challenge
+	//	*AttestAgentResponse_TypedChallenge
+	//	*AttestAgentResponse_CsrRequest
+	Step          isAttestAgentResponse_Step `protobuf_oneof:"step"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestAgentResponse) Reset() {
+	*x = AttestAgentResponse{}
+	mi := &file_agent_agent_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestAgentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestAgentResponse) ProtoMessage() {}
+
+func (x *AttestAgentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestAgentResponse.ProtoReflect.Descriptor instead.
+func (*AttestAgentResponse) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *AttestAgentResponse) GetStep() isAttestAgentResponse_Step {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetResult() *AttestAgentResponse_Result {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_Result_); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetChallenge() []byte {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_Challenge); ok {
+			return x.Challenge
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetTypedChallenge() *Challenge {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_TypedChallenge); ok {
+			return x.TypedChallenge
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetCsrRequest() *CSRRequest {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_CsrRequest); ok {
+			return x.CsrRequest
+		}
+	}
+	return nil
+}
+
+type isAttestAgentResponse_Step interface {
+	isAttestAgentResponse_Step()
+}
+
+type AttestAgentResponse_Result_ struct {
+	// Attestation results. If set, attestation has completed.
+	Result *AttestAgentResponse_Result `protobuf:"bytes,1,opt,name=result,proto3,oneof"`
+}
+
+type AttestAgentResponse_Challenge struct {
+	// A challenge issued by the attestor. If set, the caller is expected
+	// to send another request on the stream with the challenge response.
+	Challenge []byte `protobuf:"bytes,2,opt,name=challenge,proto3,oneof"`
+}
+
+type AttestAgentResponse_TypedChallenge struct {
+	// A typed challenge, from payload version 2. If set, the caller is
+	// expected to answer it with a typed_challenge_response.
+	TypedChallenge *Challenge `protobuf:"bytes,3,opt,name=typed_challenge,json=typedChallenge,proto3,oneof"`
+}
+
+type AttestAgentResponse_CsrRequest struct {
+	// Sent once every challenge was answered, from payload version 2, if the
+	// agent is entitled to SPIFFE IDs besides the agent X509-SVID. The caller
+	// is expected to answer it with csrs.
+	CsrRequest *CSRRequest `protobuf:"bytes,4,opt,name=csr_request,json=csrRequest,proto3,oneof"`
+}
+
+func (*AttestAgentResponse_Result_) isAttestAgentResponse_Step() {}
 
-func (*AttestAgentRequest_ChallengeResponse) isAttestAgentRequest_Step() {}
+func (*AttestAgentResponse_Challenge) isAttestAgentResponse_Step() {}
 
-type AttestAgentResponse struct {
+func (*AttestAgentResponse_TypedChallenge) isAttestAgentResponse_Step() {}
+
+func (*AttestAgentResponse_CsrRequest) isAttestAgentResponse_Step() {}
+
+// Asks the agent for a CSR of a new key for every SPIFFE ID, so that no two
+// X509-SVIDs share a key.
+type CSRRequest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Step:
-	//
-	//	*AttestAgentResponse_Result_
-	//	*AttestAgentResponse_Challenge
-	Step          isAttestAgentResponse_Step `protobuf_oneof:"step"`
+	// The SPIFFE IDs to send a CSR for.
+	Ids           []*SPIFFEID `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AttestAgentResponse) Reset() {
-	*x = AttestAgentResponse{}
-	mi := &file_agent_agent_proto_msgTypes[2]
+func (x *CSRRequest) Reset() {
+	*x = CSRRequest{}
+	mi := &file_agent_agent_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AttestAgentResponse) String() string {
+func (x *CSRRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AttestAgentResponse) ProtoMessage() {}
+func (*CSRRequest) ProtoMessage() {}
 
-func (x *AttestAgentResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[2]
+func (x *CSRRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -198,54 +1382,119 @@ func (x *AttestAgentResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AttestAgentResponse.ProtoReflect.Descriptor instead.
-func (*AttestAgentResponse) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use CSRRequest.ProtoReflect.Descriptor instead.
+func (*CSRRequest) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{17}
 }
 
-func (x *AttestAgentResponse) GetStep() isAttestAgentResponse_Step {
+func (x *CSRRequest) GetIds() []*SPIFFEID {
 	if x != nil {
-		return x.Step
+		return x.Ids
 	}
 	return nil
 }
 
-func (x *AttestAgentResponse) GetResult() *AttestAgentResponse_Result {
+type CSRs struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// A DER encoded CSR for every SPIFFE ID of the request, in the same order,
+	// each for a distinct key that is not the key of the agent CSR. It may be
+	// empty, in which case only the agent X509-SVID is issued.
+	Csrs          [][]byte `protobuf:"bytes,1,rep,name=csrs,proto3" json:"csrs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CSRs) Reset() {
+	*x = CSRs{}
+	mi := &file_agent_agent_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CSRs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CSRs) ProtoMessage() {}
+
+func (x *CSRs) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[18]
 	if x != nil {
-		if x, ok := x.Step.(*AttestAgentResponse_Result_); ok {
-			return x.Result
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
 		}
+		return ms
 	}
-	return nil
+	return mi.MessageOf(x)
 }
 
-func (x *AttestAgentResponse) GetChallenge() []byte {
+// Deprecated: Use CSRs.ProtoReflect.Descriptor instead.
+func (*CSRs) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CSRs) GetCsrs() [][]byte {
 	if x != nil {
-		if x, ok := x.Step.(*AttestAgentResponse_Challenge); ok {
-			return x.Challenge
-		}
+		return x.Csrs
 	}
 	return nil
 }
 
-type isAttestAgentResponse_Step interface {
-	isAttestAgentResponse_Step()
+// The bundle of a federated trust domain.
+type FederatedBundle struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The name of the trust domain, e.g. example.org.
+	TrustDomain string `protobuf:"bytes,1,opt,name=trust_domain,json=trustDomain,proto3" json:"trust_domain,omitempty"`
+	// The X.509 authorities of the trust domain (ASN.1 DER encoded).
+	Bundle        [][]byte `protobuf:"bytes,2,rep,name=bundle,proto3" json:"bundle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-type AttestAgentResponse_Result_ struct {
-	// Attestation results. If set, attestation has completed.
-	Result *AttestAgentResponse_Result `protobuf:"bytes,1,opt,name=result,proto3,oneof"`
+func (x *FederatedBundle) Reset() {
+	*x = FederatedBundle{}
+	mi := &file_agent_agent_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-type AttestAgentResponse_Challenge struct {
-	// A challenge issued by the attestor. If set, the caller is expected
-	// to send another request on the stream with the challenge response.
-	Challenge []byte `protobuf:"bytes,2,opt,name=challenge,proto3,oneof"`
+func (x *FederatedBundle) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AttestAgentResponse_Result_) isAttestAgentResponse_Step() {}
+func (*FederatedBundle) ProtoMessage() {}
 
-func (*AttestAgentResponse_Challenge) isAttestAgentResponse_Step() {}
+func (x *FederatedBundle) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FederatedBundle.ProtoReflect.Descriptor instead.
+func (*FederatedBundle) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *FederatedBundle) GetTrustDomain() string {
+	if x != nil {
+		return x.TrustDomain
+	}
+	return ""
+}
+
+func (x *FederatedBundle) GetBundle() [][]byte {
+	if x != nil {
+		return x.Bundle
+	}
+	return nil
+}
 
 // A SPIFFE ID, consisting of the trust domain name and a path portions of
 // the SPIFFE ID URI.
@@ -263,7 +1512,7 @@ type SPIFFEID struct {
 
 func (x *SPIFFEID) Reset() {
 	*x = SPIFFEID{}
-	mi := &file_agent_agent_proto_msgTypes[3]
+	mi := &file_agent_agent_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -275,7 +1524,7 @@ func (x *SPIFFEID) String() string {
 func (*SPIFFEID) ProtoMessage() {}
 
 func (x *SPIFFEID) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[3]
+	mi := &file_agent_agent_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -288,7 +1537,7 @@ func (x *SPIFFEID) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SPIFFEID.ProtoReflect.Descriptor instead.
 func (*SPIFFEID) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{3}
+	return file_agent_agent_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *SPIFFEID) GetTrustDomain() string {
@@ -322,7 +1571,7 @@ type X509SVID struct {
 
 func (x *X509SVID) Reset() {
 	*x = X509SVID{}
-	mi := &file_agent_agent_proto_msgTypes[4]
+	mi := &file_agent_agent_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -334,7 +1583,7 @@ func (x *X509SVID) String() string {
 func (*X509SVID) ProtoMessage() {}
 
 func (x *X509SVID) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[4]
+	mi := &file_agent_agent_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -347,7 +1596,7 @@ func (x *X509SVID) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use X509SVID.ProtoReflect.Descriptor instead.
 func (*X509SVID) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{4}
+	return file_agent_agent_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *X509SVID) GetCertChain() [][]byte {
@@ -371,19 +1620,144 @@ func (x *X509SVID) GetExpiresAt() int64 {
 	return 0
 }
 
+// A property of a workload, such as unix:uid:1000.
+type Selector struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The type of the selector. This is typically the name of the attestor
+	// that produced it.
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// The value of the selector.
+	Value         string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Selector) Reset() {
+	*x = Selector{}
+	mi := &file_agent_agent_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Selector) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Selector) ProtoMessage() {}
+
+func (x *Selector) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Selector.ProtoReflect.Descriptor instead.
+func (*Selector) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *Selector) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Selector) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// A registration entry that grants a SPIFFE ID to workloads running on the
+// agent.
+type RegistrationEntry struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The ID of the registration entry.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The SPIFFE ID granted by the entry.
+	SpiffeId *SPIFFEID `protobuf:"bytes,2,opt,name=spiffe_id,json=spiffeId,proto3" json:"spiffe_id,omitempty"`
+	// The selectors a workload must present to be handed the X509-SVID.
+	Selectors     []*Selector `protobuf:"bytes,3,rep,name=selectors,proto3" json:"selectors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegistrationEntry) Reset() {
+	*x = RegistrationEntry{}
+	mi := &file_agent_agent_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegistrationEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegistrationEntry) ProtoMessage() {}
+
+func (x *RegistrationEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegistrationEntry.ProtoReflect.Descriptor instead.
+func (*RegistrationEntry) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *RegistrationEntry) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *RegistrationEntry) GetSpiffeId() *SPIFFEID {
+	if x != nil {
+		return x.SpiffeId
+	}
+	return nil
+}
+
+func (x *RegistrationEntry) GetSelectors() []*Selector {
+	if x != nil {
+		return x.Selectors
+	}
+	return nil
+}
+
 type AgentX509SVIDParams struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Required. The ASN.1 DER encoded Certificate Signing Request (CSR). The
 	// CSR is only used to convey the public key; other fields in the CSR are
 	// ignored. The agent X509-SVID attributes are determined by the server.
-	Csr           []byte `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	Csr []byte `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	// The certification of the CSR key by the attestation key, if the key
+	// was created in the TPM. Servers may require it to only issue X509-SVIDs
+	// for non-exportable keys.
+	KeyCertification *KeyCertification `protobuf:"bytes,2,opt,name=key_certification,json=keyCertification,proto3" json:"key_certification,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *AgentX509SVIDParams) Reset() {
 	*x = AgentX509SVIDParams{}
-	mi := &file_agent_agent_proto_msgTypes[5]
+	mi := &file_agent_agent_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -395,7 +1769,7 @@ func (x *AgentX509SVIDParams) String() string {
 func (*AgentX509SVIDParams) ProtoMessage() {}
 
 func (x *AgentX509SVIDParams) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[5]
+	mi := &file_agent_agent_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -408,7 +1782,7 @@ func (x *AgentX509SVIDParams) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AgentX509SVIDParams.ProtoReflect.Descriptor instead.
 func (*AgentX509SVIDParams) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{5}
+	return file_agent_agent_proto_rawDescGZIP(), []int{24}
 }
 
 func (x *AgentX509SVIDParams) GetCsr() []byte {
@@ -418,6 +1792,87 @@ func (x *AgentX509SVIDParams) GetCsr() []byte {
 	return nil
 }
 
+func (x *AgentX509SVIDParams) GetKeyCertification() *KeyCertification {
+	if x != nil {
+		return x.KeyCertification
+	}
+	return nil
+}
+
+// The TPM2_Certify of a TPM-resident key by the attestation key.
+type KeyCertification struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The TPMT_PUBLIC area of the certified key.
+	Public []byte `protobuf:"bytes,1,opt,name=public,proto3" json:"public,omitempty"`
+	// Required. The TPMS_CREATION_DATA of the certified key.
+	CreateData []byte `protobuf:"bytes,2,opt,name=create_data,json=createData,proto3" json:"create_data,omitempty"`
+	// Required. The TPMS_ATTEST structure certifying the key.
+	CreateAttestation []byte `protobuf:"bytes,3,opt,name=create_attestation,json=createAttestation,proto3" json:"create_attestation,omitempty"`
+	// Required. The TPMT_SIGNATURE of create_attestation by the attestation
+	// key.
+	CreateSignature []byte `protobuf:"bytes,4,opt,name=create_signature,json=createSignature,proto3" json:"create_signature,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *KeyCertification) Reset() {
+	*x = KeyCertification{}
+	mi := &file_agent_agent_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyCertification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyCertification) ProtoMessage() {}
+
+func (x *KeyCertification) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_agent_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyCertification.ProtoReflect.Descriptor instead.
+func (*KeyCertification) Descriptor() ([]byte, []int) {
+	return file_agent_agent_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *KeyCertification) GetPublic() []byte {
+	if x != nil {
+		return x.Public
+	}
+	return nil
+}
+
+func (x *KeyCertification) GetCreateData() []byte {
+	if x != nil {
+		return x.CreateData
+	}
+	return nil
+}
+
+func (x *KeyCertification) GetCreateAttestation() []byte {
+	if x != nil {
+		return x.CreateAttestation
+	}
+	return nil
+}
+
+func (x *KeyCertification) GetCreateSignature() []byte {
+	if x != nil {
+		return x.CreateSignature
+	}
+	return nil
+}
+
 type AttestAgentRequest_Params struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Required. The attestation data.
@@ -430,7 +1885,7 @@ type AttestAgentRequest_Params struct {
 
 func (x *AttestAgentRequest_Params) Reset() {
 	*x = AttestAgentRequest_Params{}
-	mi := &file_agent_agent_proto_msgTypes[6]
+	mi := &file_agent_agent_proto_msgTypes[26]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -442,7 +1897,7 @@ func (x *AttestAgentRequest_Params) String() string {
 func (*AttestAgentRequest_Params) ProtoMessage() {}
 
 func (x *AttestAgentRequest_Params) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[6]
+	mi := &file_agent_agent_proto_msgTypes[26]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -455,7 +1910,7 @@ func (x *AttestAgentRequest_Params) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AttestAgentRequest_Params.ProtoReflect.Descriptor instead.
 func (*AttestAgentRequest_Params) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{1, 0}
+	return file_agent_agent_proto_rawDescGZIP(), []int{15, 0}
 }
 
 func (x *AttestAgentRequest_Params) GetData() *AttestationData {
@@ -475,14 +1930,26 @@ func (x *AttestAgentRequest_Params) GetParams() *AgentX509SVIDParams {
 type AttestAgentResponse_Result struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// The agent X509-SVID.
-	Svid          *X509SVID `protobuf:"bytes,1,opt,name=svid,proto3" json:"svid,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	Svid *X509SVID `protobuf:"bytes,1,opt,name=svid,proto3" json:"svid,omitempty"`
+	// An X509-SVID for every registration entry the agent is entitled to,
+	// including the agent X509-SVID. Only the agent X509-SVID certifies the
+	// key of the agent CSR, the others certify the keys of the CSRs sent in
+	// answer to a csr_request.
+	Svids []*X509SVID `protobuf:"bytes,2,rep,name=svids,proto3" json:"svids,omitempty"`
+	// The X.509 authorities of the trust domain (ASN.1 DER encoded).
+	Bundle [][]byte `protobuf:"bytes,3,rep,name=bundle,proto3" json:"bundle,omitempty"`
+	// The registration entries the agent may hand out X509-SVIDs for, along
+	// with the selectors a workload must present to receive them.
+	Entries []*RegistrationEntry `protobuf:"bytes,4,rep,name=entries,proto3" json:"entries,omitempty"`
+	// The X.509 authorities of the trust domains the server federates with.
+	FederatedBundles []*FederatedBundle `protobuf:"bytes,5,rep,name=federated_bundles,json=federatedBundles,proto3" json:"federated_bundles,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *AttestAgentResponse_Result) Reset() {
 	*x = AttestAgentResponse_Result{}
-	mi := &file_agent_agent_proto_msgTypes[7]
+	mi := &file_agent_agent_proto_msgTypes[27]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -494,7 +1961,7 @@ func (x *AttestAgentResponse_Result) String() string {
 func (*AttestAgentResponse_Result) ProtoMessage() {}
 
 func (x *AttestAgentResponse_Result) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_agent_proto_msgTypes[7]
+	mi := &file_agent_agent_proto_msgTypes[27]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -507,7 +1974,7 @@ func (x *AttestAgentResponse_Result) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AttestAgentResponse_Result.ProtoReflect.Descriptor instead.
 func (*AttestAgentResponse_Result) Descriptor() ([]byte, []int) {
-	return file_agent_agent_proto_rawDescGZIP(), []int{2, 0}
+	return file_agent_agent_proto_rawDescGZIP(), []int{16, 0}
 }
 
 func (x *AttestAgentResponse_Result) GetSvid() *X509SVID {
@@ -517,60 +1984,253 @@ func (x *AttestAgentResponse_Result) GetSvid() *X509SVID {
 	return nil
 }
 
+func (x *AttestAgentResponse_Result) GetSvids() []*X509SVID {
+	if x != nil {
+		return x.Svids
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse_Result) GetBundle() [][]byte {
+	if x != nil {
+		return x.Bundle
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse_Result) GetEntries() []*RegistrationEntry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse_Result) GetFederatedBundles() []*FederatedBundle {
+	if x != nil {
+		return x.FederatedBundles
+	}
+	return nil
+}
+
 var File_agent_agent_proto protoreflect.FileDescriptor
 
 var file_agent_agent_proto_rawDesc = []byte{
 	0x0a, 0x11, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x22, 0x3f, 0x0a, 0x0f, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69,
+	0x6f, 0x74, 0x6f, 0x22, 0x68, 0x0a, 0x0f, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69,
 	0x6f, 0x6e, 0x44, 0x61, 0x74, 0x61, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01,
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61,
 	0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x70, 0x61, 0x79,
-	0x6c, 0x6f, 0x61, 0x64, 0x22, 0xe1, 0x01, 0x0a, 0x12, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41,
-	0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x34, 0x0a, 0x06, 0x70,
-	0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x41, 0x74,
+	0x6c, 0x6f, 0x61, 0x64, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x5f,
+	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x70,
+	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0xe6, 0x02,
+	0x0a, 0x13, 0x54, 0x50, 0x4d, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50,
+	0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x27, 0x0a, 0x0e, 0x65, 0x6b, 0x5f, 0x63, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x0d, 0x65, 0x6b, 0x43, 0x65, 0x72,
+	0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x65, 0x6b, 0x5f, 0x70,
+	0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x48,
+	0x00, 0x52, 0x0b, 0x65, 0x6b, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x12, 0x1b,
+	0x0a, 0x09, 0x61, 0x6b, 0x5f, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x08, 0x61, 0x6b, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x12, 0x24, 0x0a, 0x0e, 0x61,
+	0x6b, 0x5f, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x0c, 0x61, 0x6b, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x44, 0x61, 0x74,
+	0x61, 0x12, 0x32, 0x0a, 0x15, 0x61, 0x6b, 0x5f, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x5f, 0x61,
+	0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x13, 0x61, 0x6b, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2e, 0x0a, 0x13, 0x61, 0x6b, 0x5f, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x5f, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x11, 0x61, 0x6b, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x53, 0x69, 0x67, 0x6e,
+	0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x39, 0x0a, 0x19, 0x61, 0x6b, 0x5f, 0x74, 0x63, 0x73, 0x64,
+	0x5f, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x66, 0x6f, 0x72, 0x6d,
+	0x61, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x16, 0x61, 0x6b, 0x54, 0x63, 0x73, 0x64,
+	0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74,
+	0x42, 0x04, 0x0a, 0x02, 0x65, 0x6b, 0x22, 0xab, 0x01, 0x0a, 0x0e, 0x54, 0x50, 0x4d, 0x44, 0x65,
+	0x76, 0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x34, 0x0a, 0x0a, 0x61, 0x63, 0x74,
+	0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e,
+	0x54, 0x50, 0x4d, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x61, 0x72,
+	0x61, 0x6d, 0x73, 0x52, 0x0a, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x1f, 0x0a, 0x0b, 0x64, 0x65, 0x76, 0x69, 0x64, 0x5f, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x0c, 0x52, 0x0a, 0x64, 0x65, 0x76, 0x69, 0x64, 0x43, 0x68, 0x61, 0x69, 0x6e,
+	0x12, 0x42, 0x0a, 0x13, 0x64, 0x65, 0x76, 0x69, 0x64, 0x5f, 0x63, 0x65, 0x72, 0x74, 0x69, 0x66,
+	0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e,
+	0x4b, 0x65, 0x79, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x12, 0x64, 0x65, 0x76, 0x69, 0x64, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x22, 0x6a, 0x0a, 0x16, 0x54, 0x50, 0x4d, 0x41, 0x63, 0x74, 0x69, 0x76,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x63, 0x72,
+	0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x63, 0x72,
+	0x65, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74,
+	0x22, 0x8e, 0x02, 0x0a, 0x09, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x72,
+	0x6f, 0x75, 0x6e, 0x64, 0x12, 0x39, 0x0a, 0x0a, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x54, 0x50, 0x4d, 0x41, 0x63,
+	0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67,
+	0x65, 0x48, 0x00, 0x52, 0x0a, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x27, 0x0a, 0x05, 0x71, 0x75, 0x6f, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f,
+	0x2e, 0x51, 0x75, 0x6f, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x48,
+	0x00, 0x52, 0x05, 0x71, 0x75, 0x6f, 0x74, 0x65, 0x12, 0x27, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63,
+	0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43,
+	0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x48, 0x00, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63,
+	0x65, 0x12, 0x27, 0x0a, 0x05, 0x64, 0x65, 0x76, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0f, 0x2e, 0x44, 0x65, 0x76, 0x49, 0x44, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67,
+	0x65, 0x48, 0x00, 0x52, 0x05, 0x64, 0x65, 0x76, 0x69, 0x64, 0x12, 0x2d, 0x0a, 0x07, 0x62, 0x69,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x42, 0x69,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x48, 0x00,
+	0x52, 0x07, 0x62, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x42, 0x06, 0x0a, 0x04, 0x6b, 0x69, 0x6e,
+	0x64, 0x22, 0x4d, 0x0a, 0x0e, 0x51, 0x75, 0x6f, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65,
+	0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x68, 0x61, 0x73,
+	0x68, 0x5f, 0x61, 0x6c, 0x67, 0x6f, 0x72, 0x69, 0x74, 0x68, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x0d, 0x68, 0x61, 0x73, 0x68, 0x41, 0x6c, 0x67, 0x6f, 0x72, 0x69, 0x74, 0x68, 0x6d,
+	0x22, 0x26, 0x0a, 0x0e, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e,
+	0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x22, 0x26, 0x0a, 0x0e, 0x44, 0x65, 0x76, 0x49,
+	0x44, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f,
+	0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65,
+	0x22, 0x28, 0x0a, 0x10, 0x42, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x43, 0x68, 0x61, 0x6c, 0x6c,
+	0x65, 0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x22, 0x8c, 0x02, 0x0a, 0x11, 0x43,
+	0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x14, 0x0a, 0x05, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x35, 0x0a, 0x0a, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x41, 0x63, 0x74,
+	0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48,
+	0x00, 0x52, 0x0a, 0x61, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x26, 0x0a,
+	0x05, 0x71, 0x75, 0x6f, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x51,
+	0x75, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x05,
+	0x71, 0x75, 0x6f, 0x74, 0x65, 0x12, 0x26, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x12, 0x26, 0x0a,
+	0x05, 0x64, 0x65, 0x76, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x44,
+	0x65, 0x76, 0x49, 0x44, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x05,
+	0x64, 0x65, 0x76, 0x69, 0x64, 0x12, 0x2a, 0x0a, 0x07, 0x62, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x51, 0x75, 0x6f, 0x74, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x07, 0x62, 0x69, 0x6e, 0x64, 0x69, 0x6e,
+	0x67, 0x42, 0x06, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x22, 0x2c, 0x0a, 0x12, 0x41, 0x63, 0x74,
+	0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x16, 0x0a, 0x06, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x06, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x22, 0x5d, 0x0a, 0x0d, 0x51, 0x75, 0x6f, 0x74, 0x65,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x71, 0x75, 0x6f, 0x74,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x71, 0x75, 0x6f, 0x74, 0x65, 0x12, 0x1c,
+	0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x18, 0x0a, 0x04,
+	0x70, 0x63, 0x72, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x04, 0x2e, 0x50, 0x43, 0x52,
+	0x52, 0x04, 0x70, 0x63, 0x72, 0x73, 0x22, 0x33, 0x0a, 0x03, 0x50, 0x43, 0x52, 0x12, 0x14, 0x0a,
+	0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x69, 0x6e,
+	0x64, 0x65, 0x78, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x22, 0x2d, 0x0a, 0x0d, 0x4e,
+	0x6f, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09,
+	0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x22, 0x2d, 0x0a, 0x0d, 0x44, 0x65,
+	0x76, 0x49, 0x44, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73,
+	0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09,
+	0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x22, 0xce, 0x02, 0x0a, 0x12, 0x41, 0x74,
 	0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x48, 0x00, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d,
-	0x73, 0x12, 0x2f, 0x0a, 0x12, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x5f, 0x72,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52,
-	0x11, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
-	0x73, 0x65, 0x1a, 0x5c, 0x0a, 0x06, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x24, 0x0a, 0x04,
-	0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x41, 0x74, 0x74,
-	0x65, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x44, 0x61, 0x74, 0x61, 0x52, 0x04, 0x64, 0x61,
-	0x74, 0x61, 0x12, 0x2c, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0b, 0x32, 0x14, 0x2e, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56,
-	0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73,
-	0x42, 0x06, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0x9d, 0x01, 0x0a, 0x13, 0x41, 0x74, 0x74,
-	0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
-	0x12, 0x35, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
-	0x32, 0x1b, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65,
-	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x48, 0x00, 0x52,
-	0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1e, 0x0a, 0x09, 0x63, 0x68, 0x61, 0x6c, 0x6c,
-	0x65, 0x6e, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x09, 0x63, 0x68,
-	0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x1a, 0x27, 0x0a, 0x06, 0x52, 0x65, 0x73, 0x75, 0x6c,
-	0x74, 0x12, 0x1d, 0x0a, 0x04, 0x73, 0x76, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
-	0x09, 0x2e, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56, 0x49, 0x44, 0x52, 0x04, 0x73, 0x76, 0x69, 0x64,
-	0x42, 0x06, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0x41, 0x0a, 0x08, 0x53, 0x50, 0x49, 0x46,
-	0x46, 0x45, 0x49, 0x44, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x72, 0x75, 0x73, 0x74, 0x5f, 0x64, 0x6f,
-	0x6d, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x74, 0x72, 0x75, 0x73,
-	0x74, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x22, 0x63, 0x0a, 0x08, 0x58,
-	0x35, 0x30, 0x39, 0x53, 0x56, 0x49, 0x44, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x65, 0x72, 0x74, 0x5f,
-	0x63, 0x68, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x63, 0x65, 0x72,
-	0x74, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x12, 0x19, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x50, 0x49, 0x46, 0x46, 0x45, 0x49, 0x44, 0x52, 0x02, 0x69,
-	0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x5f, 0x61, 0x74, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x41, 0x74,
-	0x22, 0x27, 0x0a, 0x13, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56, 0x49,
-	0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x63, 0x73, 0x72, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x63, 0x73, 0x72, 0x32, 0x45, 0x0a, 0x05, 0x41, 0x67, 0x65,
-	0x6e, 0x74, 0x12, 0x3c, 0x0a, 0x0b, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e,
-	0x74, 0x12, 0x13, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41,
-	0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28, 0x01, 0x30, 0x01,
-	0x42, 0x2b, 0x5a, 0x29, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d,
-	0x6a, 0x6c, 0x73, 0x68, 0x65, 0x6e, 0x2f, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x66, 0x6f,
-	0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x12, 0x34, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x48, 0x00, 0x52, 0x06,
+	0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x2f, 0x0a, 0x12, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65,
+	0x6e, 0x67, 0x65, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x48, 0x00, 0x52, 0x11, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4e, 0x0a, 0x18, 0x74, 0x79, 0x70, 0x65, 0x64,
+	0x5f, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x43, 0x68, 0x61, 0x6c,
+	0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52,
+	0x16, 0x74, 0x79, 0x70, 0x65, 0x64, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x04, 0x63, 0x73, 0x72, 0x73, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x05, 0x2e, 0x43, 0x53, 0x52, 0x73, 0x48, 0x00, 0x52, 0x04,
+	0x63, 0x73, 0x72, 0x73, 0x1a, 0x5c, 0x0a, 0x06, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x24,
+	0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x41,
+	0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x44, 0x61, 0x74, 0x61, 0x52, 0x04,
+	0x64, 0x61, 0x74, 0x61, 0x12, 0x2c, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58, 0x35, 0x30, 0x39,
+	0x53, 0x56, 0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61,
+	0x6d, 0x73, 0x42, 0x06, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0xab, 0x03, 0x0a, 0x13, 0x41,
+	0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x35, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x48,
+	0x00, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1e, 0x0a, 0x09, 0x63, 0x68, 0x61,
+	0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x09,
+	0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x12, 0x35, 0x0a, 0x0f, 0x74, 0x79, 0x70,
+	0x65, 0x64, 0x5f, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x48, 0x00,
+	0x52, 0x0e, 0x74, 0x79, 0x70, 0x65, 0x64, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65,
+	0x12, 0x2e, 0x0a, 0x0b, 0x63, 0x73, 0x72, 0x5f, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x43, 0x53, 0x52, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x48, 0x00, 0x52, 0x0a, 0x63, 0x73, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0xcd, 0x01, 0x0a, 0x06, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1d, 0x0a, 0x04, 0x73,
+	0x76, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x58, 0x35, 0x30, 0x39,
+	0x53, 0x56, 0x49, 0x44, 0x52, 0x04, 0x73, 0x76, 0x69, 0x64, 0x12, 0x1f, 0x0a, 0x05, 0x73, 0x76,
+	0x69, 0x64, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x58, 0x35, 0x30, 0x39,
+	0x53, 0x56, 0x49, 0x44, 0x52, 0x05, 0x73, 0x76, 0x69, 0x64, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x62,
+	0x75, 0x6e, 0x64, 0x6c, 0x65, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x75, 0x6e,
+	0x64, 0x6c, 0x65, 0x12, 0x2c, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x04,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65,
+	0x73, 0x12, 0x3d, 0x0a, 0x11, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x62,
+	0x75, 0x6e, 0x64, 0x6c, 0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x46,
+	0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x65, 0x64, 0x42, 0x75, 0x6e, 0x64, 0x6c, 0x65, 0x52, 0x10,
+	0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x65, 0x64, 0x42, 0x75, 0x6e, 0x64, 0x6c, 0x65, 0x73,
+	0x42, 0x06, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0x29, 0x0a, 0x0a, 0x43, 0x53, 0x52, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x50, 0x49, 0x46, 0x46, 0x45, 0x49, 0x44, 0x52, 0x03,
+	0x69, 0x64, 0x73, 0x22, 0x1a, 0x0a, 0x04, 0x43, 0x53, 0x52, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x63,
+	0x73, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x63, 0x73, 0x72, 0x73, 0x22,
+	0x4c, 0x0a, 0x0f, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x65, 0x64, 0x42, 0x75, 0x6e, 0x64,
+	0x6c, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x72, 0x75, 0x73, 0x74, 0x5f, 0x64, 0x6f, 0x6d, 0x61,
+	0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x74, 0x72, 0x75, 0x73, 0x74, 0x44,
+	0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x62, 0x75, 0x6e, 0x64, 0x6c, 0x65, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x75, 0x6e, 0x64, 0x6c, 0x65, 0x22, 0x41, 0x0a,
+	0x08, 0x53, 0x50, 0x49, 0x46, 0x46, 0x45, 0x49, 0x44, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x72, 0x75,
+	0x73, 0x74, 0x5f, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0b, 0x74, 0x72, 0x75, 0x73, 0x74, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x12, 0x0a, 0x04,
+	0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68,
+	0x22, 0x63, 0x0a, 0x08, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56, 0x49, 0x44, 0x12, 0x1d, 0x0a, 0x0a,
+	0x63, 0x65, 0x72, 0x74, 0x5f, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c,
+	0x52, 0x09, 0x63, 0x65, 0x72, 0x74, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x12, 0x19, 0x0a, 0x02, 0x69,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x50, 0x49, 0x46, 0x46, 0x45,
+	0x49, 0x44, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65,
+	0x73, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69,
+	0x72, 0x65, 0x73, 0x41, 0x74, 0x22, 0x34, 0x0a, 0x08, 0x53, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f,
+	0x72, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x74, 0x0a, 0x11, 0x52,
+	0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
+	0x12, 0x26, 0x0a, 0x09, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x50, 0x49, 0x46, 0x46, 0x45, 0x49, 0x44, 0x52, 0x08,
+	0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x49, 0x64, 0x12, 0x27, 0x0a, 0x09, 0x73, 0x65, 0x6c, 0x65,
+	0x63, 0x74, 0x6f, 0x72, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x65,
+	0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x52, 0x09, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72,
+	0x73, 0x22, 0x67, 0x0a, 0x13, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56,
+	0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x63, 0x73, 0x72, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x63, 0x73, 0x72, 0x12, 0x3e, 0x0a, 0x11, 0x6b, 0x65,
+	0x79, 0x5f, 0x63, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x4b, 0x65, 0x79, 0x43, 0x65, 0x72, 0x74, 0x69,
+	0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x10, 0x6b, 0x65, 0x79, 0x43, 0x65, 0x72,
+	0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0xa5, 0x01, 0x0a, 0x10, 0x4b,
+	0x65, 0x79, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x16, 0x0a, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x72, 0x65, 0x61, 0x74,
+	0x65, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x63, 0x72,
+	0x65, 0x61, 0x74, 0x65, 0x44, 0x61, 0x74, 0x61, 0x12, 0x2d, 0x0a, 0x12, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x5f, 0x61, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x11, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x41, 0x74, 0x74, 0x65,
+	0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x29, 0x0a, 0x10, 0x63, 0x72, 0x65, 0x61, 0x74,
+	0x65, 0x5f, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x0f, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75,
+	0x72, 0x65, 0x32, 0x45, 0x0a, 0x05, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x12, 0x3c, 0x0a, 0x0b, 0x41,
+	0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x12, 0x13, 0x2e, 0x41, 0x74, 0x74,
+	0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x14, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28, 0x01, 0x30, 0x01, 0x42, 0x2b, 0x5a, 0x29, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x6a, 0x6c, 0x73, 0x68, 0x65, 0x6e, 0x2f,
+	0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x66, 0x6f, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -585,31 +2245,75 @@ func file_agent_agent_proto_rawDescGZIP() []byte {
 	return file_agent_agent_proto_rawDescData
 }
 
-var file_agent_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_agent_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 28)
 var file_agent_agent_proto_goTypes = []any{
 	(*AttestationData)(nil),            // 0: AttestationData
-	(*AttestAgentRequest)(nil),         // 1: AttestAgentRequest
-	(*AttestAgentResponse)(nil),        // 2: AttestAgentResponse
-	(*SPIFFEID)(nil),                   // 3: SPIFFEID
-	(*X509SVID)(nil),                   // 4: X509SVID
-	(*AgentX509SVIDParams)(nil),        // 5: AgentX509SVIDParams
-	(*AttestAgentRequest_Params)(nil),  // 6: AttestAgentRequest.Params
-	(*AttestAgentResponse_Result)(nil), // 7: AttestAgentResponse.Result
+	(*TPMActivationParams)(nil),        // 1: TPMActivationParams
+	(*TPMDevIDParams)(nil),             // 2: TPMDevIDParams
+	(*TPMActivationChallenge)(nil),     // 3: TPMActivationChallenge
+	(*Challenge)(nil),                  // 4: Challenge
+	(*QuoteChallenge)(nil),             // 5: QuoteChallenge
+	(*NonceChallenge)(nil),             // 6: NonceChallenge
+	(*DevIDChallenge)(nil),             // 7: DevIDChallenge
+	(*BindingChallenge)(nil),           // 8: BindingChallenge
+	(*ChallengeResponse)(nil),          // 9: ChallengeResponse
+	(*ActivationResponse)(nil),         // 10: ActivationResponse
+	(*QuoteResponse)(nil),              // 11: QuoteResponse
+	(*PCR)(nil),                        // 12: PCR
+	(*NonceResponse)(nil),              // 13: NonceResponse
+	(*DevIDResponse)(nil),              // 14: DevIDResponse
+	(*AttestAgentRequest)(nil),         // 15: AttestAgentRequest
+	(*AttestAgentResponse)(nil),        // 16: AttestAgentResponse
+	(*CSRRequest)(nil),                 // 17: CSRRequest
+	(*CSRs)(nil),                       // 18: CSRs
+	(*FederatedBundle)(nil),            // 19: FederatedBundle
+	(*SPIFFEID)(nil),                   // 20: SPIFFEID
+	(*X509SVID)(nil),                   // 21: X509SVID
+	(*Selector)(nil),                   // 22: Selector
+	(*RegistrationEntry)(nil),          // 23: RegistrationEntry
+	(*AgentX509SVIDParams)(nil),        // 24: AgentX509SVIDParams
+	(*KeyCertification)(nil),           // 25: KeyCertification
+	(*AttestAgentRequest_Params)(nil),  // 26: AttestAgentRequest.Params
+	(*AttestAgentResponse_Result)(nil), // 27: AttestAgentResponse.Result
 }
 var file_agent_agent_proto_depIdxs = []int32{
-	6, // 0: AttestAgentRequest.params:type_name -> AttestAgentRequest.Params
-	7, // 1: AttestAgentResponse.result:type_name -> AttestAgentResponse.Result
-	3, // 2: X509SVID.id:type_name -> SPIFFEID
-	0, // 3: AttestAgentRequest.Params.data:type_name -> AttestationData
-	5, // 4: AttestAgentRequest.Params.params:type_name -> AgentX509SVIDParams
-	4, // 5: AttestAgentResponse.Result.svid:type_name -> X509SVID
-	1, // 6: Agent.AttestAgent:input_type -> AttestAgentRequest
-	2, // 7: Agent.AttestAgent:output_type -> AttestAgentResponse
-	7, // [7:8] is the sub-list for method output_type
-	6, // [6:7] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	1,  // 0: TPMDevIDParams.activation:type_name -> TPMActivationParams
+	25, // 1: TPMDevIDParams.devid_certification:type_name -> KeyCertification
+	3,  // 2: Challenge.activation:type_name -> TPMActivationChallenge
+	5,  // 3: Challenge.quote:type_name -> QuoteChallenge
+	6,  // 4: Challenge.nonce:type_name -> NonceChallenge
+	7,  // 5: Challenge.devid:type_name -> DevIDChallenge
+	8,  // 6: Challenge.binding:type_name -> BindingChallenge
+	10, // 7: ChallengeResponse.activation:type_name -> ActivationResponse
+	11, // 8: ChallengeResponse.quote:type_name -> QuoteResponse
+	13, // 9: ChallengeResponse.nonce:type_name -> NonceResponse
+	14, // 10: ChallengeResponse.devid:type_name -> DevIDResponse
+	11, // 11: ChallengeResponse.binding:type_name -> QuoteResponse
+	12, // 12: QuoteResponse.pcrs:type_name -> PCR
+	26, // 13: AttestAgentRequest.params:type_name -> AttestAgentRequest.Params
+	9,  // 14: AttestAgentRequest.typed_challenge_response:type_name -> ChallengeResponse
+	18, // 15: AttestAgentRequest.csrs:type_name -> CSRs
+	27, // 16: AttestAgentResponse.result:type_name -> AttestAgentResponse.Result
+	4,  // 17: AttestAgentResponse.typed_challenge:type_name -> Challenge
+	17, // 18: AttestAgentResponse.csr_request:type_name -> CSRRequest
+	20, // 19: CSRRequest.ids:type_name -> SPIFFEID
+	20, // 20: X509SVID.id:type_name -> SPIFFEID
+	20, // 21: RegistrationEntry.spiffe_id:type_name -> SPIFFEID
+	22, // 22: RegistrationEntry.selectors:type_name -> Selector
+	25, // 23: AgentX509SVIDParams.key_certification:type_name -> KeyCertification
+	0,  // 24: AttestAgentRequest.Params.data:type_name -> AttestationData
+	24, // 25: AttestAgentRequest.Params.params:type_name -> AgentX509SVIDParams
+	21, // 26: AttestAgentResponse.Result.svid:type_name -> X509SVID
+	21, // 27: AttestAgentResponse.Result.svids:type_name -> X509SVID
+	23, // 28: AttestAgentResponse.Result.entries:type_name -> RegistrationEntry
+	19, // 29: AttestAgentResponse.Result.federated_bundles:type_name -> FederatedBundle
+	15, // 30: Agent.AttestAgent:input_type -> AttestAgentRequest
+	16, // 31: Agent.AttestAgent:output_type -> AttestAgentResponse
+	31, // [31:32] is the sub-list for method output_type
+	30, // [30:31] is the sub-list for method input_type
+	30, // [30:30] is the sub-list for extension type_name
+	30, // [30:30] is the sub-list for extension extendee
+	0,  // [0:30] is the sub-list for field type_name
 }
 
 func init() { file_agent_agent_proto_init() }
@@ -618,12 +2322,34 @@ func file_agent_agent_proto_init() {
 		return
 	}
 	file_agent_agent_proto_msgTypes[1].OneofWrappers = []any{
+		(*TPMActivationParams_EkCertificate)(nil),
+		(*TPMActivationParams_EkPublicKey)(nil),
+	}
+	file_agent_agent_proto_msgTypes[4].OneofWrappers = []any{
+		(*Challenge_Activation)(nil),
+		(*Challenge_Quote)(nil),
+		(*Challenge_Nonce)(nil),
+		(*Challenge_Devid)(nil),
+		(*Challenge_Binding)(nil),
+	}
+	file_agent_agent_proto_msgTypes[9].OneofWrappers = []any{
+		(*ChallengeResponse_Activation)(nil),
+		(*ChallengeResponse_Quote)(nil),
+		(*ChallengeResponse_Nonce)(nil),
+		(*ChallengeResponse_Devid)(nil),
+		(*ChallengeResponse_Binding)(nil),
+	}
+	file_agent_agent_proto_msgTypes[15].OneofWrappers = []any{
 		(*AttestAgentRequest_Params_)(nil),
 		(*AttestAgentRequest_ChallengeResponse)(nil),
+		(*AttestAgentRequest_TypedChallengeResponse)(nil),
+		(*AttestAgentRequest_Csrs)(nil),
 	}
-	file_agent_agent_proto_msgTypes[2].OneofWrappers = []any{
+	file_agent_agent_proto_msgTypes[16].OneofWrappers = []any{
 		(*AttestAgentResponse_Result_)(nil),
 		(*AttestAgentResponse_Challenge)(nil),
+		(*AttestAgentResponse_TypedChallenge)(nil),
+		(*AttestAgentResponse_CsrRequest)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -631,7 +2357,7 @@ func file_agent_agent_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_agent_agent_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   28,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
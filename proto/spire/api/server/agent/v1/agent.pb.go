@@ -0,0 +1,483 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.1
+// 	protoc        v5.29.2
+// source: spire/api/server/agent/v1/agent.proto
+
+// The AttestAgent method of SPIRE's Agent API. Package, message and field
+// numbers match SPIRE so that SPIRE agents can attest against SPIFFE Fog and
+// the other way around. The other methods of the API are not implemented.
+
+package agentv1
+
+import (
+	types "github.com/mjlshen/spiffe_fog/proto/spire/api/types"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AttestAgentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The data for the step in the attestation flow.
+	//
+	// Types that are valid to be assigned to Step:
+	//
+	//	*AttestAgentRequest_Params_
+	//	*AttestAgentRequest_ChallengeResponse
+	Step          isAttestAgentRequest_Step `protobuf_oneof:"step"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestAgentRequest) Reset() {
+	*x = AttestAgentRequest{}
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestAgentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestAgentRequest) ProtoMessage() {}
+
+func (x *AttestAgentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestAgentRequest.ProtoReflect.Descriptor instead.
+func (*AttestAgentRequest) Descriptor() ([]byte, []int) {
+	return file_spire_api_server_agent_v1_agent_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AttestAgentRequest) GetStep() isAttestAgentRequest_Step {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *AttestAgentRequest) GetParams() *AttestAgentRequest_Params {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentRequest_Params_); ok {
+			return x.Params
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentRequest) GetChallengeResponse() []byte {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentRequest_ChallengeResponse); ok {
+			return x.ChallengeResponse
+		}
+	}
+	return nil
+}
+
+type isAttestAgentRequest_Step interface {
+	isAttestAgentRequest_Step()
+}
+
+type AttestAgentRequest_Params_ struct {
+	// Attestation parameters. These are only sent in the initial request.
+	Params *AttestAgentRequest_Params `protobuf:"bytes,1,opt,name=params,proto3,oneof"`
+}
+
+type AttestAgentRequest_ChallengeResponse struct {
+	// The response to a challenge issued by the attestor. Only sent in
+	// response to a challenge received by the issuer.
+	ChallengeResponse []byte `protobuf:"bytes,2,opt,name=challenge_response,json=challengeResponse,proto3,oneof"`
+}
+
+func (*AttestAgentRequest_Params_) isAttestAgentRequest_Step() {}
+
+func (*AttestAgentRequest_ChallengeResponse) isAttestAgentRequest_Step() {}
+
+type AttestAgentResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Step:
+	//
+	//	*AttestAgentResponse_Result_
+	//	*AttestAgentResponse_Challenge
+	Step          isAttestAgentResponse_Step `protobuf_oneof:"step"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestAgentResponse) Reset() {
+	*x = AttestAgentResponse{}
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestAgentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestAgentResponse) ProtoMessage() {}
+
+func (x *AttestAgentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestAgentResponse.ProtoReflect.Descriptor instead.
+func (*AttestAgentResponse) Descriptor() ([]byte, []int) {
+	return file_spire_api_server_agent_v1_agent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AttestAgentResponse) GetStep() isAttestAgentResponse_Step {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetResult() *AttestAgentResponse_Result {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_Result_); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse) GetChallenge() []byte {
+	if x != nil {
+		if x, ok := x.Step.(*AttestAgentResponse_Challenge); ok {
+			return x.Challenge
+		}
+	}
+	return nil
+}
+
+type isAttestAgentResponse_Step interface {
+	isAttestAgentResponse_Step()
+}
+
+type AttestAgentResponse_Result_ struct {
+	// Attestation results. If set, attestation has completed.
+	Result *AttestAgentResponse_Result `protobuf:"bytes,1,opt,name=result,proto3,oneof"`
+}
+
+type AttestAgentResponse_Challenge struct {
+	// A challenge issued by the attestor. If set, the caller is expected
+	// to send another request on the stream with the challenge response.
+	Challenge []byte `protobuf:"bytes,2,opt,name=challenge,proto3,oneof"`
+}
+
+func (*AttestAgentResponse_Result_) isAttestAgentResponse_Step() {}
+
+func (*AttestAgentResponse_Challenge) isAttestAgentResponse_Step() {}
+
+type AgentX509SVIDParams struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The ASN.1 DER encoded Certificate Signing Request (CSR). The
+	// CSR is only used to convey the public key; other fields in the CSR are
+	// ignored. The agent X509-SVID attributes are determined by the server.
+	Csr           []byte `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentX509SVIDParams) Reset() {
+	*x = AgentX509SVIDParams{}
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentX509SVIDParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentX509SVIDParams) ProtoMessage() {}
+
+func (x *AgentX509SVIDParams) ProtoReflect() protoreflect.Message {
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentX509SVIDParams.ProtoReflect.Descriptor instead.
+func (*AgentX509SVIDParams) Descriptor() ([]byte, []int) {
+	return file_spire_api_server_agent_v1_agent_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AgentX509SVIDParams) GetCsr() []byte {
+	if x != nil {
+		return x.Csr
+	}
+	return nil
+}
+
+type AttestAgentRequest_Params struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required. The attestation data.
+	Data *types.AttestationData `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// Required. The X509-SVID parameters.
+	Params        *AgentX509SVIDParams `protobuf:"bytes,2,opt,name=params,proto3" json:"params,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestAgentRequest_Params) Reset() {
+	*x = AttestAgentRequest_Params{}
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestAgentRequest_Params) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestAgentRequest_Params) ProtoMessage() {}
+
+func (x *AttestAgentRequest_Params) ProtoReflect() protoreflect.Message {
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestAgentRequest_Params.ProtoReflect.Descriptor instead.
+func (*AttestAgentRequest_Params) Descriptor() ([]byte, []int) {
+	return file_spire_api_server_agent_v1_agent_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *AttestAgentRequest_Params) GetData() *types.AttestationData {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *AttestAgentRequest_Params) GetParams() *AgentX509SVIDParams {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type AttestAgentResponse_Result struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The agent X509-SVID.
+	Svid *types.X509SVID `protobuf:"bytes,1,opt,name=svid,proto3" json:"svid,omitempty"`
+	// Whether or not the attested agent can reattest to renew its X509-SVID.
+	Reattestable  bool `protobuf:"varint,2,opt,name=reattestable,proto3" json:"reattestable,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestAgentResponse_Result) Reset() {
+	*x = AttestAgentResponse_Result{}
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestAgentResponse_Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestAgentResponse_Result) ProtoMessage() {}
+
+func (x *AttestAgentResponse_Result) ProtoReflect() protoreflect.Message {
+	mi := &file_spire_api_server_agent_v1_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestAgentResponse_Result.ProtoReflect.Descriptor instead.
+func (*AttestAgentResponse_Result) Descriptor() ([]byte, []int) {
+	return file_spire_api_server_agent_v1_agent_proto_rawDescGZIP(), []int{1, 0}
+}
+
+func (x *AttestAgentResponse_Result) GetSvid() *types.X509SVID {
+	if x != nil {
+		return x.Svid
+	}
+	return nil
+}
+
+func (x *AttestAgentResponse_Result) GetReattestable() bool {
+	if x != nil {
+		return x.Reattestable
+	}
+	return false
+}
+
+var File_spire_api_server_agent_v1_agent_proto protoreflect.FileDescriptor
+
+var file_spire_api_server_agent_v1_agent_proto_rawDesc = []byte{
+	0x0a, 0x25, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x73, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x2f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2f, 0x76, 0x31, 0x2f, 0x61, 0x67, 0x65, 0x6e,
+	0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x19, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e, 0x61,
+	0x70, 0x69, 0x2e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e,
+	0x76, 0x31, 0x1a, 0x1b, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x74, 0x79,
+	0x70, 0x65, 0x73, 0x2f, 0x74, 0x79, 0x70, 0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22,
+	0xa6, 0x02, 0x0a, 0x12, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x4e, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x34, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e, 0x61,
+	0x70, 0x69, 0x2e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e,
+	0x76, 0x31, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x48, 0x00, 0x52, 0x06,
+	0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x2f, 0x0a, 0x12, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65,
+	0x6e, 0x67, 0x65, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x48, 0x00, 0x52, 0x11, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x1a, 0x86, 0x01, 0x0a, 0x06, 0x50, 0x61, 0x72, 0x61,
+	0x6d, 0x73, 0x12, 0x34, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x20, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x74, 0x79, 0x70,
+	0x65, 0x73, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x44, 0x61,
+	0x74, 0x61, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x46, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61,
+	0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x2e, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65,
+	0x2e, 0x61, 0x70, 0x69, 0x2e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e,
+	0x74, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56,
+	0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73,
+	0x42, 0x06, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0xeb, 0x01, 0x0a, 0x13, 0x41, 0x74, 0x74,
+	0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x4f, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x35, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x73, 0x65, 0x72,
+	0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x74, 0x74,
+	0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x2e, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x48, 0x00, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c,
+	0x74, 0x12, 0x1e, 0x0a, 0x09, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x09, 0x63, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67,
+	0x65, 0x1a, 0x5b, 0x0a, 0x06, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x2d, 0x0a, 0x04, 0x73,
+	0x76, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x73, 0x70, 0x69, 0x72,
+	0x65, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x74, 0x79, 0x70, 0x65, 0x73, 0x2e, 0x58, 0x35, 0x30, 0x39,
+	0x53, 0x56, 0x49, 0x44, 0x52, 0x04, 0x73, 0x76, 0x69, 0x64, 0x12, 0x22, 0x0a, 0x0c, 0x72, 0x65,
+	0x61, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0c, 0x72, 0x65, 0x61, 0x74, 0x74, 0x65, 0x73, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x42, 0x06,
+	0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x22, 0x27, 0x0a, 0x13, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x58,
+	0x35, 0x30, 0x39, 0x53, 0x56, 0x49, 0x44, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x10, 0x0a,
+	0x03, 0x63, 0x73, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x63, 0x73, 0x72, 0x32,
+	0x79, 0x0a, 0x05, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x12, 0x70, 0x0a, 0x0b, 0x41, 0x74, 0x74, 0x65,
+	0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x12, 0x2d, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e,
+	0x61, 0x70, 0x69, 0x2e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e, 0x74,
+	0x2e, 0x76, 0x31, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2e, 0x2e, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2e, 0x61,
+	0x70, 0x69, 0x2e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2e, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e,
+	0x76, 0x31, 0x2e, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28, 0x01, 0x30, 0x01, 0x42, 0x47, 0x5a, 0x45, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x6a, 0x6c, 0x73, 0x68, 0x65, 0x6e,
+	0x2f, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x66, 0x6f, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2f, 0x73, 0x70, 0x69, 0x72, 0x65, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x73, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x2f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2f, 0x76, 0x31, 0x3b, 0x61, 0x67, 0x65, 0x6e,
+	0x74, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_spire_api_server_agent_v1_agent_proto_rawDescOnce sync.Once
+	file_spire_api_server_agent_v1_agent_proto_rawDescData = file_spire_api_server_agent_v1_agent_proto_rawDesc
+)
+
+func file_spire_api_server_agent_v1_agent_proto_rawDescGZIP() []byte {
+	file_spire_api_server_agent_v1_agent_proto_rawDescOnce.Do(func() {
+		file_spire_api_server_agent_v1_agent_proto_rawDescData = protoimpl.X.CompressGZIP(file_spire_api_server_agent_v1_agent_proto_rawDescData)
+	})
+	return file_spire_api_server_agent_v1_agent_proto_rawDescData
+}
+
+var file_spire_api_server_agent_v1_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_spire_api_server_agent_v1_agent_proto_goTypes = []any{
+	(*AttestAgentRequest)(nil),         // 0: spire.api.server.agent.v1.AttestAgentRequest
+	(*AttestAgentResponse)(nil),        // 1: spire.api.server.agent.v1.AttestAgentResponse
+	(*AgentX509SVIDParams)(nil),        // 2: spire.api.server.agent.v1.AgentX509SVIDParams
+	(*AttestAgentRequest_Params)(nil),  // 3: spire.api.server.agent.v1.AttestAgentRequest.Params
+	(*AttestAgentResponse_Result)(nil), // 4: spire.api.server.agent.v1.AttestAgentResponse.Result
+	(*types.AttestationData)(nil),      // 5: spire.api.types.AttestationData
+	(*types.X509SVID)(nil),             // 6: spire.api.types.X509SVID
+}
+var file_spire_api_server_agent_v1_agent_proto_depIdxs = []int32{
+	3, // 0: spire.api.server.agent.v1.AttestAgentRequest.params:type_name -> spire.api.server.agent.v1.AttestAgentRequest.Params
+	4, // 1: spire.api.server.agent.v1.AttestAgentResponse.result:type_name -> spire.api.server.agent.v1.AttestAgentResponse.Result
+	5, // 2: spire.api.server.agent.v1.AttestAgentRequest.Params.data:type_name -> spire.api.types.AttestationData
+	2, // 3: spire.api.server.agent.v1.AttestAgentRequest.Params.params:type_name -> spire.api.server.agent.v1.AgentX509SVIDParams
+	6, // 4: spire.api.server.agent.v1.AttestAgentResponse.Result.svid:type_name -> spire.api.types.X509SVID
+	0, // 5: spire.api.server.agent.v1.Agent.AttestAgent:input_type -> spire.api.server.agent.v1.AttestAgentRequest
+	1, // 6: spire.api.server.agent.v1.Agent.AttestAgent:output_type -> spire.api.server.agent.v1.AttestAgentResponse
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_spire_api_server_agent_v1_agent_proto_init() }
+func file_spire_api_server_agent_v1_agent_proto_init() {
+	if File_spire_api_server_agent_v1_agent_proto != nil {
+		return
+	}
+	file_spire_api_server_agent_v1_agent_proto_msgTypes[0].OneofWrappers = []any{
+		(*AttestAgentRequest_Params_)(nil),
+		(*AttestAgentRequest_ChallengeResponse)(nil),
+	}
+	file_spire_api_server_agent_v1_agent_proto_msgTypes[1].OneofWrappers = []any{
+		(*AttestAgentResponse_Result_)(nil),
+		(*AttestAgentResponse_Challenge)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_spire_api_server_agent_v1_agent_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_spire_api_server_agent_v1_agent_proto_goTypes,
+		DependencyIndexes: file_spire_api_server_agent_v1_agent_proto_depIdxs,
+		MessageInfos:      file_spire_api_server_agent_v1_agent_proto_msgTypes,
+	}.Build()
+	File_spire_api_server_agent_v1_agent_proto = out.File
+	file_spire_api_server_agent_v1_agent_proto_rawDesc = nil
+	file_spire_api_server_agent_v1_agent_proto_goTypes = nil
+	file_spire_api_server_agent_v1_agent_proto_depIdxs = nil
+}
@@ -0,0 +1,78 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.1
+// 	protoc        v5.29.2
+// source: workload/workload.proto
+
+package workload
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+var File_workload_workload_proto protoreflect.FileDescriptor
+
+var file_workload_workload_proto_rawDesc = []byte{
+	0x0a, 0x17, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x2f, 0x77, 0x6f, 0x72, 0x6b, 0x6c,
+	0x6f, 0x61, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x14, 0x77, 0x6f, 0x72, 0x6b, 0x6c,
+	0x6f, 0x61, 0x64, 0x2f, 0x74, 0x79, 0x70, 0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x32,
+	0x73, 0x0a, 0x11, 0x53, 0x70, 0x69, 0x66, 0x66, 0x65, 0x57, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61,
+	0x64, 0x41, 0x50, 0x49, 0x12, 0x5e, 0x0a, 0x0d, 0x46, 0x65, 0x74, 0x63, 0x68, 0x58, 0x35, 0x30,
+	0x39, 0x53, 0x56, 0x49, 0x44, 0x12, 0x24, 0x2e, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x66,
+	0x6f, 0x67, 0x2e, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x58, 0x35, 0x30, 0x39,
+	0x53, 0x56, 0x49, 0x44, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x25, 0x2e, 0x73, 0x70,
+	0x69, 0x66, 0x66, 0x65, 0x5f, 0x66, 0x6f, 0x67, 0x2e, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61,
+	0x64, 0x2e, 0x58, 0x35, 0x30, 0x39, 0x53, 0x56, 0x49, 0x44, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x30, 0x01, 0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x6d, 0x6a, 0x6c, 0x73, 0x68, 0x65, 0x6e, 0x2f, 0x73, 0x70, 0x69, 0x66, 0x66,
+	0x65, 0x5f, 0x66, 0x6f, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x77, 0x6f, 0x72, 0x6b,
+	0x6c, 0x6f, 0x61, 0x64, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var file_workload_workload_proto_goTypes = []any{
+	(*X509SVIDRequest)(nil),  // 0: spiffe_fog.workload.X509SVIDRequest
+	(*X509SVIDResponse)(nil), // 1: spiffe_fog.workload.X509SVIDResponse
+}
+var file_workload_workload_proto_depIdxs = []int32{
+	0, // 0: SpiffeWorkloadAPI.FetchX509SVID:input_type -> spiffe_fog.workload.X509SVIDRequest
+	1, // 1: SpiffeWorkloadAPI.FetchX509SVID:output_type -> spiffe_fog.workload.X509SVIDResponse
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_workload_workload_proto_init() }
+func file_workload_workload_proto_init() {
+	if File_workload_workload_proto != nil {
+		return
+	}
+	file_workload_types_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_workload_workload_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   0,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_workload_workload_proto_goTypes,
+		DependencyIndexes: file_workload_workload_proto_depIdxs,
+	}.Build()
+	File_workload_workload_proto = out.File
+	file_workload_workload_proto_rawDesc = nil
+	file_workload_workload_proto_goTypes = nil
+	file_workload_workload_proto_depIdxs = nil
+}
@@ -0,0 +1,115 @@
+//go:build linux && cgo
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/conformance"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runReferenceAgent attests once with the reference client and a fresh simulator
+func runReferenceAgent(ctx context.Context, addr string) error {
+	sim, err := simulator.Get()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !sim.IsClosed() {
+			sim.Close()
+		}
+	}()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := agent.NewAgentClient(conn).AttestAgent(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.New(stream, agentID, client.WithTPM(func() (*attest.TPM, error) {
+		return attest.InjectSimulatedTPMForTest(sim), nil
+	})).Attest(ctx)
+	return err
+}
+
+// TestConformanceReferenceAgent makes sure the reference client passes its own conformance suite
+// and that recorded transcripts evaluate the same way
+func TestConformanceReferenceAgent(t *testing.T) {
+	dir := t.TempDir()
+	suite := &conformance.Suite{
+		SPIFFEID:    "spiffe://" + trustDomain + "/" + agentID,
+		RunAgent:    runReferenceAgent,
+		StepTimeout: 10 * time.Second,
+		WaitTimeout: time.Minute,
+		RecordDir:   dir,
+	}
+
+	report, err := suite.Run(context.Background())
+	if err != nil {
+		t.Fatalf("conformance run failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := report.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + out.String())
+	if !report.Passed() {
+		t.Fatal("reference agent does not conform")
+	}
+
+	replayed := &conformance.Report{}
+	for _, sc := range conformance.Scenarios {
+		transcript, err := conformance.LoadTranscript(filepath.Join(dir, sc.Name+".json"))
+		if err != nil {
+			t.Fatalf("failed to load transcript: %v", err)
+		}
+		replayed.Add(sc, transcript, suite.StepTimeout)
+	}
+	if !replayed.Passed() {
+		t.Fatal("recorded transcripts do not conform")
+	}
+}
+
+// TestConformanceDetectsViolations evaluates a transcript of an agent that skips its params
+func TestConformanceDetectsViolations(t *testing.T) {
+	sc, _ := conformance.ScenarioByName("success")
+	transcript := &conformance.Transcript{
+		Scenario: sc.Name,
+		Messages: []conformance.Message{{
+			From: conformance.FromAgent,
+			Data: []byte(`{"challengeResponse":"c2VjcmV0"}`),
+		}},
+		Code:   codes.InvalidArgument,
+		Status: "malformed attestation param: missing params",
+	}
+
+	failed := map[string]bool{}
+	for _, r := range conformance.Evaluate(sc, transcript, time.Second) {
+		failed[r.Check] = r.Outcome == conformance.Fail
+	}
+	for _, check := range []string{"ordering", "params_schema", "challenge_schema", "status_code"} {
+		if !failed[check] {
+			t.Errorf("expected %s to fail", check)
+		}
+	}
+	if failed["timeouts"] {
+		t.Error("expected timeouts to pass")
+	}
+}
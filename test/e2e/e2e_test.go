@@ -0,0 +1,2110 @@
+//go:build linux && cgo
+
+// Package e2e drives client.Client against server.Service over an in-memory gRPC connection,
+// using a software TPM so that the tests run without hardware or root.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/mjlshen/spiffe_fog/pkg/ca"
+	"github.com/mjlshen/spiffe_fog/pkg/client"
+	"github.com/mjlshen/spiffe_fog/pkg/common"
+	"github.com/mjlshen/spiffe_fog/pkg/federation"
+	"github.com/mjlshen/spiffe_fog/pkg/fogtls"
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/sds"
+	"github.com/mjlshen/spiffe_fog/pkg/server"
+	"github.com/mjlshen/spiffe_fog/pkg/spire"
+	"github.com/mjlshen/spiffe_fog/pkg/workload"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	agentv1 "github.com/mjlshen/spiffe_fog/proto/spire/api/server/agent/v1"
+	spiretypes "github.com/mjlshen/spiffe_fog/proto/spire/api/types"
+	workloadapi "github.com/mjlshen/spiffe_fog/proto/workload"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	trustDomain = "spiffe_fog"
+	agentID     = "edge"
+)
+
+// harness is a server and a simulated TPM for a single test. Only one simulator may be open
+// at a time, so tests using it must not run in parallel.
+type harness struct {
+	sim    *simulator.Simulator
+	ekHash string
+	store  *registry.MemoryStore
+	svc    *server.Service
+	conn   *grpc.ClientConn
+}
+
+// newHarness starts a server with a step timeout of 10 seconds, configured further by the options
+func newHarness(t *testing.T, opts ...func(*server.Config)) *harness {
+	t.Helper()
+
+	sim, ekHash := newSimulator(t)
+
+	store := registry.NewMemoryStore()
+	cfg := server.Config{
+		TrustDomain: trustDomain,
+		Store:       store,
+		StepTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	svc, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	agent.RegisterAgentServer(s, svc)
+	agentv1.RegisterAgentServer(s, spire.NewAgentServer(svc))
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &harness{
+		sim:    sim,
+		ekHash: ekHash,
+		store:  store,
+		svc:    svc,
+		conn:   conn,
+	}
+}
+
+// newSimulator starts the TPM simulator and returns it with the hash of its EK
+func newSimulator(t *testing.T) (*simulator.Simulator, string) {
+	t.Helper()
+
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Fatalf("failed to start TPM simulator: %v", err)
+	}
+	t.Cleanup(func() {
+		if !sim.IsClosed() {
+			sim.Close()
+		}
+	})
+
+	ek, err := common.GetEK(attest.InjectSimulatedTPMForTest(sim))
+	if err != nil {
+		t.Fatalf("failed to read simulator EK: %v", err)
+	}
+	ekHash, err := common.GetPubHash(ek)
+	if err != nil {
+		t.Fatalf("failed to hash simulator EK: %v", err)
+	}
+	return sim, ekHash
+}
+
+// withChallenges makes the server issue challenges in order
+func withChallenges(challenges ...string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Challenges = challenges
+	}
+}
+
+// allowUnboundCSR accepts agents of payload versions before 2
+func allowUnboundCSR(cfg *server.Config) {
+	cfg.AllowUnboundCSR = true
+}
+
+// register trusts the simulator's EK and entitles it to spiffe://spiffe_fog/<id>, if it also
+// presents the extra selectors
+func (h *harness) register(id string, extra ...registry.Selector) {
+	selector := registry.Selector{Type: "tpm", Value: "ek_hash:" + h.ekHash}
+	h.store.AddNode(registry.Node{EKHash: h.ekHash})
+	h.store.AddEntry(registry.Entry{
+		SPIFFEID:  "spiffe://" + trustDomain + "/" + id,
+		Selectors: append([]registry.Selector{selector}, extra...),
+	})
+}
+
+// pcrSelector returns the selector a quote challenge adds for the current value of a SHA256 PCR
+func (h *harness) pcrSelector(t *testing.T, index int) registry.Selector {
+	t.Helper()
+
+	pcrs, err := attest.InjectSimulatedTPMForTest(h.sim).PCRs(attest.HashSHA256)
+	if err != nil {
+		t.Fatalf("failed to read simulator PCRs: %v", err)
+	}
+	for _, p := range pcrs {
+		if p.Index == index {
+			return registry.Selector{Type: "tpm_pcr", Value: fmt.Sprintf("sha256:%d:%x", index, p.Digest)}
+		}
+	}
+	t.Fatalf("simulator has no PCR %d", index)
+	return registry.Selector{}
+}
+
+func (h *harness) stream(t *testing.T, ctx context.Context) agent.Agent_AttestAgentClient {
+	t.Helper()
+
+	stream, err := agent.NewAgentClient(h.conn).AttestAgent(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	return stream
+}
+
+// attest runs the client against the server with the simulator, optionally wrapping the stream
+func (h *harness) attest(t *testing.T, id string, wrap func(agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient, opts ...client.Option) (*client.Result, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return h.attestContext(t, ctx, id, wrap, opts...)
+}
+
+// attestContext is attest with the context of the caller
+func (h *harness) attestContext(t *testing.T, ctx context.Context, id string, wrap func(agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient, opts ...client.Option) (*client.Result, error) {
+	t.Helper()
+
+	stream := h.stream(t, ctx)
+	if wrap != nil {
+		stream = wrap(stream)
+	}
+
+	opts = append(opts, client.WithTPM(func() (*attest.TPM, error) {
+		return attest.InjectSimulatedTPMForTest(keepOpen{h.sim}), nil
+	}))
+	return client.New(stream, id, opts...).Attest(ctx)
+}
+
+// attestSPIRE runs the client against the server over SPIRE's Agent API
+func (h *harness) attestSPIRE(t *testing.T, id string, opts ...client.Option) (*client.Result, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	stream, err := agentv1.NewAgentClient(h.conn).AttestAgent(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	opts = append(opts, client.WithTPM(func() (*attest.TPM, error) {
+		return attest.InjectSimulatedTPMForTest(keepOpen{h.sim}), nil
+	}))
+	return client.New(spire.NewAttestAgentClient(stream), id, opts...).Attest(ctx)
+}
+
+// keepOpen lets the client close its TPM without closing the simulator, so that a test can
+// attest more than once
+type keepOpen struct {
+	io.ReadWriteCloser
+}
+
+func (keepOpen) Close() error {
+	return nil
+}
+
+// recordingStream records the kind of every typed challenge the agent receives
+type recordingStream struct {
+	agent.Agent_AttestAgentClient
+	kinds *[]string
+}
+
+func (s recordingStream) Recv() (*agent.AttestAgentResponse, error) {
+	resp, err := s.Agent_AttestAgentClient.Recv()
+	if c := resp.GetTypedChallenge(); c != nil {
+		switch {
+		case c.GetActivation() != nil:
+			*s.kinds = append(*s.kinds, server.ChallengeActivation)
+		case c.GetQuote() != nil:
+			*s.kinds = append(*s.kinds, server.ChallengeQuote)
+		case c.GetNonce() != nil:
+			*s.kinds = append(*s.kinds, server.ChallengeNonce)
+		case c.GetDevid() != nil:
+			*s.kinds = append(*s.kinds, server.ChallengeDevID)
+		case c.GetBinding() != nil:
+			*s.kinds = append(*s.kinds, server.ChallengeBinding)
+		}
+	}
+	return resp, err
+}
+
+// tamperingStream flips a bit of the activation challenge response before it is sent to the server
+type tamperingStream struct {
+	agent.Agent_AttestAgentClient
+}
+
+func (s tamperingStream) Send(req *agent.AttestAgentRequest) error {
+	if resp := req.GetChallengeResponse(); len(resp) > 0 {
+		req = &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_ChallengeResponse{ChallengeResponse: flipBit(resp)},
+		}
+	}
+	if secret := req.GetTypedChallengeResponse().GetActivation().GetSecret(); len(secret) > 0 {
+		req = &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_TypedChallengeResponse{
+				TypedChallengeResponse: &agent.ChallengeResponse{
+					Round: req.GetTypedChallengeResponse().GetRound(),
+					Kind: &agent.ChallengeResponse_Activation{
+						Activation: &agent.ActivationResponse{Secret: flipBit(secret)},
+					},
+				},
+			},
+		}
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+func flipBit(b []byte) []byte {
+	tampered := append([]byte(nil), b...)
+	tampered[0] ^= 0x01
+	return tampered
+}
+
+// delayingStream waits before sending every challenge response, as a slow agent would
+type delayingStream struct {
+	agent.Agent_AttestAgentClient
+	delay time.Duration
+}
+
+func (s delayingStream) Send(req *agent.AttestAgentRequest) error {
+	if req.GetParams() == nil {
+		time.Sleep(s.delay)
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+// replayingStream answers every typed challenge as if it were the first round
+type replayingStream struct {
+	agent.Agent_AttestAgentClient
+}
+
+func (s replayingStream) Send(req *agent.AttestAgentRequest) error {
+	if resp := req.GetTypedChallengeResponse(); resp != nil {
+		req = &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_TypedChallengeResponse{
+				TypedChallengeResponse: &agent.ChallengeResponse{Round: 1, Kind: resp.Kind},
+			},
+		}
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+// csrSwappingStream replaces the CSR of the params with one for a key the agent does not hold, like
+// an attacker relaying the attestation of a legitimate agent would
+type csrSwappingStream struct {
+	agent.Agent_AttestAgentClient
+	csr []byte
+}
+
+func (s csrSwappingStream) Send(req *agent.AttestAgentRequest) error {
+	if params := req.GetParams(); params != nil {
+		swapped := proto.Clone(params).(*agent.AttestAgentRequest_Params)
+		swapped.Params.Csr = s.csr
+		req = &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_Params_{Params: swapped},
+		}
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+// csrRecordingStream records the CSR of the params
+type csrRecordingStream struct {
+	agent.Agent_AttestAgentClient
+	csr *[]byte
+}
+
+func (s csrRecordingStream) Send(req *agent.AttestAgentRequest) error {
+	if params := req.GetParams(); params != nil {
+		*s.csr = params.GetParams().GetCsr()
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+// akTamperingStream modifies the AK parameters of the params before they are sent to the server
+type akTamperingStream struct {
+	agent.Agent_AttestAgentClient
+	t      *testing.T
+	tamper func(*attest.AttestationParameters)
+}
+
+func (s akTamperingStream) Send(req *agent.AttestAgentRequest) error {
+	if params := req.GetParams(); params != nil {
+		version := params.GetData().GetPayloadVersion()
+		data, err := common.UnmarshalAttestationData(version, params.GetData().GetPayload())
+		if err != nil {
+			s.t.Fatalf("failed to decode attestation data: %v", err)
+		}
+		s.tamper(data.AK)
+		payload, err := common.MarshalAttestationData(version, data)
+		if err != nil {
+			s.t.Fatalf("failed to encode attestation data: %v", err)
+		}
+
+		tampered := proto.Clone(params).(*agent.AttestAgentRequest_Params)
+		tampered.Data.Payload = payload
+		req = &agent.AttestAgentRequest{
+			Step: &agent.AttestAgentRequest_Params_{Params: tampered},
+		}
+	}
+	return s.Agent_AttestAgentClient.Send(req)
+}
+
+// requireStatus fails the test unless err has code want and a message containing msg
+func requireStatus(t *testing.T, err error, want codes.Code, msg string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("expected %s, attestation succeeded", want)
+	}
+	s := status.Convert(err)
+	if s.Code() != want || !strings.Contains(s.Message(), msg) {
+		t.Fatalf("expected %s containing %q, got %s: %s", want, msg, s.Code(), s.Message())
+	}
+}
+
+func TestAttestSuccess(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+
+	want := "spiffe://" + trustDomain + "/" + agentID
+	if result.SVID.ID != want {
+		t.Fatalf("expected X509-SVID for %s, got %s", want, result.SVID.ID)
+	}
+	if len(result.Bundle) != 1 {
+		t.Fatalf("expected a bundle with one CA, got %d", len(result.Bundle))
+	}
+
+	leaf := result.SVID.Certificates[0]
+	if !leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(result.SVID.PrivateKey.Public()) {
+		t.Fatal("X509-SVID does not certify the private key")
+	}
+	if err := leaf.CheckSignatureFrom(result.Bundle[0]); err != nil {
+		t.Fatalf("X509-SVID is not signed by the bundle: %v", err)
+	}
+}
+
+func TestAttestLegacyJSONPayload(t *testing.T) {
+	h := newHarness(t, allowUnboundCSR)
+	h.register(agentID)
+
+	if _, err := h.attest(t, agentID, nil, client.WithPayloadVersion(common.PayloadVersionJSON)); err != nil {
+		t.Fatalf("legacy attestation failed: %v", err)
+	}
+}
+
+func TestAttestLegacyProtoPayload(t *testing.T) {
+	h := newHarness(t, allowUnboundCSR)
+	h.register(agentID)
+
+	if _, err := h.attest(t, agentID, nil, client.WithPayloadVersion(common.PayloadVersionProto)); err != nil {
+		t.Fatalf("legacy attestation failed: %v", err)
+	}
+}
+
+func TestAttestMultiRound(t *testing.T) {
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeQuote, server.ChallengeNonce))
+	h.register(agentID, h.pcrSelector(t, 0))
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if want := "spiffe://" + trustDomain + "/" + agentID; result.SVID.ID != want {
+		t.Fatalf("expected X509-SVID for %s, got %s", want, result.SVID.ID)
+	}
+}
+
+func TestAttestPCRMismatch(t *testing.T) {
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeQuote))
+	h.register(agentID, registry.Selector{Type: "tpm_pcr", Value: "sha256:0:" + strings.Repeat("ab", 32)})
+
+	_, err := h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid SPIFFE ID requested")
+}
+
+func TestAttestUnboundCSR(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	for _, version := range []uint32{common.PayloadVersionJSON, common.PayloadVersionProto} {
+		_, err := h.attest(t, agentID, nil, client.WithPayloadVersion(version))
+		requireStatus(t, err, codes.InvalidArgument, fmt.Sprintf("payload version %d cannot bind the CSR to the AK", version))
+	}
+}
+
+func TestAttestLegacyAgentMultiRound(t *testing.T) {
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeQuote), allowUnboundCSR)
+	h.register(agentID)
+
+	_, err := h.attest(t, agentID, nil, client.WithPayloadVersion(common.PayloadVersionProto))
+	requireStatus(t, err, codes.InvalidArgument, "payload version 1 does not support quote challenges")
+}
+
+func TestAttestOutOfOrderResponse(t *testing.T) {
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeNonce))
+	h.register(agentID)
+
+	_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return replayingStream{s}
+	})
+	requireStatus(t, err, codes.InvalidArgument, "challenge response is for round 1, expected round 2")
+}
+
+func TestAttestReusedAK(t *testing.T) {
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeNonce), func(cfg *server.Config) {
+		cfg.AKStore = registry.NewMemoryAKStore()
+	})
+	h.register(agentID)
+	akPath := filepath.Join(t.TempDir(), "ak.blob")
+
+	for _, want := range [][]string{
+		// The first attestation activates the new AK and the server remembers it
+		{server.ChallengeActivation, server.ChallengeNonce, server.ChallengeBinding},
+		// The persisted AK is reused and proven with a quote instead
+		{server.ChallengeQuote, server.ChallengeNonce, server.ChallengeBinding},
+	} {
+		var kinds []string
+		_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+			return recordingStream{Agent_AttestAgentClient: s, kinds: &kinds}
+		}, client.WithAKPath(akPath))
+		if err != nil {
+			t.Fatalf("attestation failed: %v", err)
+		}
+		if !slices.Equal(kinds, want) {
+			t.Fatalf("expected challenges %v, got %v", want, kinds)
+		}
+	}
+}
+
+func TestAttestBinding(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	// The binding is added without being configured
+	var kinds []string
+	if _, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return recordingStream{Agent_AttestAgentClient: s, kinds: &kinds}
+	}); err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if want := []string{server.ChallengeActivation, server.ChallengeBinding}; !slices.Equal(kinds, want) {
+		t.Fatalf("expected challenges %v, got %v", want, kinds)
+	}
+
+	csr, _, err := common.NewCSRTemplate("spiffe://" + trustDomain + "/" + agentID)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	_, err = h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return csrSwappingStream{Agent_AttestAgentClient: s, csr: csr}
+	})
+	requireStatus(t, err, codes.PermissionDenied, "CSR is not bound to the AK")
+}
+
+func TestAttestReplayWindow(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+	akPath := filepath.Join(t.TempDir(), "ak.blob")
+
+	var csr []byte
+	if _, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return csrRecordingStream{Agent_AttestAgentClient: s, csr: &csr}
+	}, client.WithAKPath(akPath)); err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+
+	// The persisted AK is presented again with a new CSR
+	if _, err := h.attest(t, agentID, nil, client.WithAKPath(akPath)); err != nil {
+		t.Fatalf("attestation with the persisted AK failed: %v", err)
+	}
+
+	// Replaying the AK parameters and CSR of the first attestation is rejected
+	_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return csrSwappingStream{Agent_AttestAgentClient: s, csr: csr}
+	}, client.WithAKPath(akPath))
+	requireStatus(t, err, codes.PermissionDenied, "AK parameters and CSR were used by another attestation")
+}
+
+func TestAttestTPMKey(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.RequireTPMKeys = true
+	})
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil, client.WithTPMKey())
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	defer result.Close()
+
+	leaf := result.SVID.Certificates[0]
+	digest := sha256.Sum256([]byte("hello"))
+	sig, err := result.SVID.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("failed to sign with the TPM key: %v", err)
+	}
+	if !ecdsa.VerifyASN1(leaf.PublicKey.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Fatal("X509-SVID does not certify the TPM key")
+	}
+}
+
+func TestAttestRequireTPMKey(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.RequireTPMKeys = true
+	})
+	h.register(agentID)
+
+	_, err := h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.InvalidArgument, "CSR key must be certified as TPM-resident by the AK")
+}
+
+// manufacturer is a CA issuing DevID certificates
+type manufacturer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newManufacturer(t *testing.T, name string) *manufacturer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate manufacturer key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create manufacturer certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse manufacturer certificate: %v", err)
+	}
+	return &manufacturer{cert: cert, key: key}
+}
+
+// issue certifies pub as the DevID of the device with the serial number
+func (m *manufacturer) issue(t *testing.T, pub crypto.PublicKey, serialNumber string) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1234),
+		Subject:      pkix.Name{CommonName: "edge-device", SerialNumber: serialNumber},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, m.cert, pub, m.key)
+	if err != nil {
+		t.Fatalf("failed to create DevID certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse DevID certificate: %v", err)
+	}
+	return cert
+}
+
+// provisionDevID creates a signing key in the simulator and persists it at the IDevID handle, like
+// a manufacturer would
+func (h *harness) provisionDevID(t *testing.T) crypto.PublicKey {
+	t.Helper()
+
+	template := tpm2.Public{
+		Type:    tpm2.AlgECC,
+		NameAlg: tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+			tpm2.FlagUserWithAuth | tpm2.FlagSign,
+		ECCParameters: &tpm2.ECCParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+			CurveID: tpm2.CurveNISTP256,
+		},
+	}
+	handle, pub, err := tpm2.CreatePrimary(h.sim, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		t.Fatalf("failed to create DevID key: %v", err)
+	}
+	defer tpm2.FlushContext(h.sim, handle)
+
+	if err := tpm2.EvictControl(h.sim, "", tpm2.HandleOwner, handle, tpmutil.Handle(common.DefaultIDevIDHandle)); err != nil {
+		t.Fatalf("failed to persist DevID key: %v", err)
+	}
+	return pub
+}
+
+// attestDevID attests with the DevID at the IDevID handle, recording the challenges
+func (h *harness) attestDevID(t *testing.T, chain []*x509.Certificate, kinds *[]string) (*client.Result, error) {
+	t.Helper()
+
+	return h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return recordingStream{Agent_AttestAgentClient: s, kinds: kinds}
+	},
+		client.WithDevID(client.DevID{Certificates: chain, Handle: common.DefaultIDevIDHandle}),
+		client.WithTPMDevice(func() (io.ReadWriteCloser, error) { return keepOpen{h.sim}, nil }),
+	)
+}
+
+func TestAttestDevID(t *testing.T) {
+	vendor := newManufacturer(t, "Manufacturer Root CA")
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeNonce), func(cfg *server.Config) {
+		cfg.Attestors = []string{server.AttestorTPMActivation, server.AttestorTPMDevID}
+		cfg.DevIDRoots = []*x509.Certificate{vendor.cert}
+	})
+
+	// The EK is not registered, the node is trusted through the serial number of its DevID
+	h.store.AddEntry(registry.Entry{
+		SPIFFEID:  "spiffe://" + trustDomain + "/" + agentID,
+		Selectors: []registry.Selector{{Type: "tpm_devid", Value: "subject:serialnumber:SN-0042"}},
+	})
+	devid := vendor.issue(t, h.provisionDevID(t), "SN-0042")
+
+	var kinds []string
+	result, err := h.attestDevID(t, []*x509.Certificate{devid}, &kinds)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if want := "spiffe://" + trustDomain + "/" + agentID; result.SVID.ID != want {
+		t.Fatalf("expected %s, got %s", want, result.SVID.ID)
+	}
+	if want := []string{server.ChallengeActivation, server.ChallengeDevID, server.ChallengeNonce, server.ChallengeBinding}; !slices.Equal(kinds, want) {
+		t.Fatalf("expected challenges %v, got %v", want, kinds)
+	}
+
+	// A DevID for the same key from another manufacturer is not trusted
+	other := newManufacturer(t, "Other Root CA").issue(t, devid.PublicKey, "SN-0042")
+	_, err = h.attestDevID(t, []*x509.Certificate{other}, new([]string))
+	requireStatus(t, err, codes.PermissionDenied, "invalid DevID")
+
+	// Without the DevID the EK must be registered
+	_, err = h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid EK hash")
+}
+
+func TestOutput(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "svid")
+	output := client.Output{
+		Dir:            dir,
+		Formats:        []string{client.FormatPEM, client.FormatPKCS12, client.FormatJSON},
+		PKCS12Password: "secret",
+		Hook:           "touch rotated",
+	}
+	if err := output.Write(context.Background(), result); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+
+	for name, perm := range map[string]os.FileMode{
+		client.SVIDFileName:         0o644,
+		client.SVIDKeyFileName:      0o600,
+		client.BundleFileName:       0o644,
+		client.SVIDPKCS12FileName:   0o600,
+		client.BundlePKCS12FileName: 0o644,
+		client.SVIDJSONFileName:     0o600,
+		"rotated":                   0,
+	} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("expected %s to be written: %v", name, err)
+		}
+		if perm != 0 && info.Mode().Perm() != perm {
+			t.Errorf("expected %s to have permissions %o, got %o", name, perm, info.Mode().Perm())
+		}
+	}
+
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, client.SVIDFileName), filepath.Join(dir, client.SVIDKeyFileName))
+	if err != nil {
+		t.Fatalf("svid.pem and svid_key.pem are not a key pair: %v", err)
+	}
+	if !bytes.Equal(pair.Certificate[0], result.SVID.Certificates[0].Raw) {
+		t.Error("svid.pem does not start with the X509-SVID")
+	}
+	bundle, err := common.LoadBundle(filepath.Join(dir, client.BundleFileName))
+	if err != nil || len(bundle) != len(result.Bundle) {
+		t.Errorf("bundle.pem does not hold the trust bundle: %v", err)
+	}
+
+	p12, err := os.ReadFile(filepath.Join(dir, client.SVIDPKCS12FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, cert, err := pkcs12.Decode(p12, "secret"); err != nil || !cert.Equal(result.SVID.Certificates[0]) {
+		t.Errorf("svid.p12 does not hold the X509-SVID: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, client.SVIDJSONFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("svid.json is not JSON: %v", err)
+	}
+	if doc["spiffe_id"] != result.SVID.ID || doc["x509_svid_key"] == nil {
+		t.Errorf("unexpected svid.json: %s", b)
+	}
+}
+
+func TestOutputTPMKey(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	// A previous run without -tpm-key left its key behind
+	dir := t.TempDir()
+	output := client.Output{Dir: dir}
+	exportable, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if err := output.Write(context.Background(), exportable); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+
+	result, err := h.attest(t, agentID, nil, client.WithTPMKey())
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	defer result.Close()
+
+	if err := output.Write(context.Background(), result); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, client.SVIDFileName)); err != nil {
+		t.Errorf("expected svid.pem to be written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, client.SVIDKeyFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected the key of the previous X509-SVID to be removed, got %v", err)
+	}
+
+	// Only TPM-resident keys are left out, other keys that cannot be marshalled are an error
+	unexportable := *exportable.SVID
+	unexportable.PrivateKey = opaqueSigner{exportable.SVID.PrivateKey}
+	if err := output.Write(context.Background(), &client.Result{SVID: &unexportable, Bundle: exportable.Bundle}); err == nil {
+		t.Error("expected a key that cannot be marshalled to be rejected")
+	}
+
+	output.Formats = []string{client.FormatPKCS12}
+	if err := output.Write(context.Background(), result); err == nil {
+		t.Error("expected PKCS#12 to be rejected for a TPM-resident key")
+	}
+}
+
+// opaqueSigner hides the type of a key, so that it cannot be marshalled
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestSDS(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	cache := workload.NewCache()
+	cache.Update(result)
+
+	uid := registry.Selector{Type: "unix", Value: fmt.Sprintf("uid:%d", os.Getuid())}
+	conn := serveSDS(t, cache, []registry.Selector{uid})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stream, err := secretv3.NewSecretDiscoveryServiceClient(conn).StreamSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{sds.DefaultSVIDName, sds.DefaultBundleName}
+	if err := stream.Send(&discoveryv3.DiscoveryRequest{TypeUrl: sds.SecretTypeURL, ResourceNames: names}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive secrets: %v", err)
+	}
+	requireSDSLeaf(t, resp, result)
+
+	// Acknowledging the secrets must not trigger another response, so the next one is the rotation
+	if err := stream.Send(&discoveryv3.DiscoveryRequest{
+		TypeUrl:       sds.SecretTypeURL,
+		VersionInfo:   resp.GetVersionInfo(),
+		ResponseNonce: resp.GetNonce(),
+		ResourceNames: names,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	cache.Update(renewed)
+
+	pushed, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive rotated secrets: %v", err)
+	}
+	if pushed.GetVersionInfo() == resp.GetVersionInfo() {
+		t.Error("expected a new version after rotation")
+	}
+	requireSDSLeaf(t, pushed, renewed)
+}
+
+func TestSDSAgentSelectors(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	cache := workload.NewCache()
+	cache.Update(result)
+
+	other := registry.Selector{Type: "unix", Value: fmt.Sprintf("uid:%d", os.Getuid()+1)}
+	for name, agentSelectors := range map[string][]registry.Selector{
+		"unset":     nil,
+		"other uid": {other},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := serveSDS(t, cache, agentSelectors)
+			resp, err := secretv3.NewSecretDiscoveryServiceClient(conn).FetchSecrets(context.Background(), &discoveryv3.DiscoveryRequest{
+				TypeUrl: sds.SecretTypeURL,
+			})
+			if err != nil {
+				t.Fatalf("failed to fetch secrets: %v", err)
+			}
+			if len(resp.GetResources()) == 0 {
+				t.Fatal("expected the trust bundles to be served")
+			}
+			for _, res := range resp.GetResources() {
+				secret := &tlsv3.Secret{}
+				if err := res.UnmarshalTo(secret); err != nil {
+					t.Fatalf("failed to unmarshal secret: %v", err)
+				}
+				if secret.GetTlsCertificate() != nil {
+					t.Errorf("a caller without the agent selectors received %s", secret.GetName())
+				}
+			}
+		})
+	}
+}
+
+// serveSDS serves SDS for cache on a unix socket and connects to it
+func serveSDS(t *testing.T, cache *workload.Cache, agentSelectors []registry.Selector) *grpc.ClientConn {
+	t.Helper()
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sds.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.Creds(workload.Credentials()))
+	secretv3.RegisterSecretDiscoveryServiceServer(s, sds.NewServer(cache, agentSelectors, workload.UnixAttestor{}))
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("unix://"+listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// requireSDSLeaf fails the test unless resp has the agent X509-SVID of result as the default
+// tls_certificate and its trust bundle as the ROOTCA validation_context
+func requireSDSLeaf(t *testing.T, resp *discoveryv3.DiscoveryResponse, result *client.Result) {
+	t.Helper()
+
+	secrets := map[string]*tlsv3.Secret{}
+	for _, res := range resp.GetResources() {
+		secret := &tlsv3.Secret{}
+		if err := res.UnmarshalTo(secret); err != nil {
+			t.Fatalf("failed to unmarshal secret: %v", err)
+		}
+		secrets[secret.GetName()] = secret
+	}
+	if len(secrets) != 2 {
+		t.Fatalf("expected 2 secrets, got %d", len(secrets))
+	}
+
+	chain := secrets[sds.DefaultSVIDName].GetTlsCertificate().GetCertificateChain().GetInlineBytes()
+	block, _ := pem.Decode(chain)
+	if block == nil || !bytes.Equal(block.Bytes, result.SVID.Certificates[0].Raw) {
+		t.Error("default secret does not hold the agent X509-SVID")
+	}
+
+	ca := secrets[sds.DefaultBundleName].GetValidationContext().GetTrustedCa().GetInlineBytes()
+	block, _ = pem.Decode(ca)
+	if block == nil || !bytes.Equal(block.Bytes, result.Bundle[0].Raw) {
+		t.Error("ROOTCA secret does not hold the trust bundle")
+	}
+}
+
+func TestFogTLS(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	dir := t.TempDir()
+	output := client.Output{Dir: dir}
+	if err := output.Write(context.Background(), result); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	src, err := fogtls.NewFileSource(dir)
+	if err != nil {
+		t.Fatalf("failed to load X509-SVID: %v", err)
+	}
+
+	id := "spiffe://" + trustDomain + "/" + agentID
+	for name, tc := range map[string]struct {
+		server, client fogtls.Authorizer
+		ok             bool
+	}{
+		"exact ID":             {server: fogtls.AuthorizeID(id), client: fogtls.AuthorizeID(id), ok: true},
+		"trust domain member":  {server: fogtls.AuthorizeMemberOf(trustDomain), client: fogtls.AuthorizeAny(), ok: true},
+		"unexpected server ID": {server: fogtls.AuthorizeAny(), client: fogtls.AuthorizeID("spiffe://" + trustDomain + "/other")},
+		"foreign trust domain": {server: fogtls.AuthorizeMemberOf("example.org"), client: fogtls.AuthorizeAny()},
+		"rejected by callback": {server: fogtls.AuthorizeIf(func(*url.URL) bool { return false }), client: fogtls.AuthorizeAny()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := handshake(fogtls.ServerConfig(src, tc.server), fogtls.ClientConfig(src, tc.client))
+			if tc.ok && err != nil {
+				t.Errorf("expected the handshake to succeed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("expected the handshake to fail")
+			}
+		})
+	}
+
+	// Rotated files are picked up by the next handshake
+	renewed, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if err := output.Write(context.Background(), renewed); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	leaf, err := handshake(fogtls.ServerConfig(src, fogtls.AuthorizeAny()), fogtls.ClientConfig(src, fogtls.AuthorizeAny()))
+	if err != nil {
+		t.Fatalf("handshake failed after rotation: %v", err)
+	}
+	if !leaf.Equal(renewed.SVID.Certificates[0]) {
+		t.Error("server did not present the rotated X509-SVID")
+	}
+}
+
+// handshake connects a client to a server over a loopback connection and returns the certificate
+// the server presented. Unlike net.Pipe it buffers writes, so that a side failing the handshake
+// does not block on an alert while the other one is writing.
+func handshake(serverConfig, clientConfig *tls.Config) (*x509.Certificate, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer clientConn.Close()
+	serverConn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	defer serverConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(serverConn, serverConfig).Handshake()
+		serverConn.Close()
+	}()
+
+	conn := tls.Client(clientConn, clientConfig)
+	err = conn.Handshake()
+	clientConn.Close()
+	if err := <-serverErr; err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestFogTLSWorkloadAPI(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+	webID := "spiffe://" + trustDomain + "/web"
+	h.store.AddEntry(registry.Entry{
+		SPIFFEID:          webID,
+		Selectors:         []registry.Selector{{Type: "tpm", Value: "ek_hash:" + h.ekHash}},
+		WorkloadSelectors: []registry.Selector{{Type: "unix", Value: fmt.Sprintf("uid:%d", os.Getuid())}},
+	})
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	cache := workload.NewCache()
+	cache.Update(result)
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workloadServer := grpc.NewServer(grpc.Creds(workload.Credentials()))
+	workloadapi.RegisterSpiffeWorkloadAPIServer(workloadServer, workload.NewServer(cache, workload.UnixAttestor{}))
+	go workloadServer.Serve(listener)
+	t.Cleanup(workloadServer.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	src, err := fogtls.NewWorkloadAPISource(ctx, socket, "")
+	if err != nil {
+		t.Fatalf("failed to fetch X509-SVID: %v", err)
+	}
+	defer src.Close()
+	svid, err := src.X509SVID()
+	if err != nil || svid.ID != webID {
+		t.Fatalf("expected the X509-SVID for %s, got %v", webID, err)
+	}
+
+	// A gRPC server and client authenticating each other with the workload X509-SVID
+	var peerID string
+	s := grpc.NewServer(
+		grpc.Creds(fogtls.ServerCredentials(src, fogtls.AuthorizeMemberOf(trustDomain))),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if id, ok := fogtls.PeerID(ctx); ok {
+				peerID = id.String()
+			}
+			return handler(ctx, req)
+		}),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(tcp)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(tcp.Addr().String(), grpc.WithTransportCredentials(fogtls.ClientCredentials(src, fogtls.AuthorizeID(webID))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("mTLS call failed: %v", err)
+	}
+	if peerID != webID {
+		t.Errorf("expected the server to see peer %s, got %q", webID, peerID)
+	}
+
+	// The client refuses servers it does not authorize
+	conn, err = grpc.NewClient(tcp.Addr().String(), grpc.WithTransportCredentials(fogtls.ClientCredentials(src, fogtls.AuthorizeID(webID+"-other"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the unauthorized server to be refused, got %v", err)
+	}
+}
+
+// selectorAttestor attests every workload with the same selectors
+type selectorAttestor []registry.Selector
+
+func (a selectorAttestor) Attest(context.Context, workload.PeerCred) ([]registry.Selector, error) {
+	return a, nil
+}
+
+func TestWorkloadKeys(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+	ids := map[string]string{}
+	for _, name := range []string{"web", "db"} {
+		ids[name] = "spiffe://" + trustDomain + "/" + name
+		h.store.AddEntry(registry.Entry{
+			SPIFFEID:          ids[name],
+			Selectors:         []registry.Selector{{Type: "tpm", Value: "ek_hash:" + h.ekHash}},
+			WorkloadSelectors: []registry.Selector{{Type: "test", Value: name}},
+		})
+	}
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	cache := workload.NewCache()
+	cache.Update(result)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Each workload gets its own key, and none gets the key of the agent X509-SVID
+	keys := map[string]crypto.PublicKey{}
+	for name, id := range ids {
+		socket := filepath.Join(t.TempDir(), name+".sock")
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workloadServer := grpc.NewServer(grpc.Creds(workload.Credentials()))
+		workloadapi.RegisterSpiffeWorkloadAPIServer(workloadServer, workload.NewServer(cache, selectorAttestor{{Type: "test", Value: name}}))
+		go workloadServer.Serve(listener)
+		t.Cleanup(workloadServer.Stop)
+
+		src, err := fogtls.NewWorkloadAPISource(ctx, socket, "")
+		if err != nil {
+			t.Fatalf("failed to fetch X509-SVID of %s: %v", name, err)
+		}
+		defer src.Close()
+		svid, err := src.X509SVID()
+		if err != nil || svid.ID != id {
+			t.Fatalf("expected the X509-SVID for %s, got %v", id, err)
+		}
+		if !svid.Certificates[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(svid.PrivateKey.Public()) {
+			t.Errorf("X509-SVID of %s does not certify its key", name)
+		}
+		keys[name] = svid.PrivateKey.Public()
+	}
+
+	agentKey := result.SVID.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	web := keys["web"].(interface{ Equal(crypto.PublicKey) bool })
+	if web.Equal(keys["db"]) {
+		t.Error("expected the workloads to get different keys")
+	}
+	if agentKey.Equal(keys["web"]) || agentKey.Equal(keys["db"]) {
+		t.Error("expected no workload to get the agent key")
+	}
+}
+
+func TestFederation(t *testing.T) {
+	const peerTrustDomain = "site_b"
+
+	// The server of the federated trust domain serves its bundle over https_spiffe
+	peerSvc, err := server.New(server.Config{
+		TrustDomain: peerTrustDomain,
+		Store:       registry.NewMemoryStore(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create peer server: %v", err)
+	}
+	tlsConfig, err := peerSvc.TLSConfig(server.TLSConfig{Bootstrap: true})
+	if err != nil {
+		t.Fatalf("failed to configure peer TLS: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := &http.Server{Handler: federation.Handler(peerSvc.Bundle, time.Minute, slog.Default())}
+	go endpoint.Serve(tls.NewListener(listener, tlsConfig))
+	t.Cleanup(func() { endpoint.Close() })
+
+	peer := federation.Peer{
+		TrustDomain:      peerTrustDomain,
+		URL:              "https://" + listener.Addr().String(),
+		Profile:          federation.ProfileHTTPSSPIFFE,
+		EndpointSPIFFEID: "spiffe://" + peerTrustDomain + "/server",
+		Bundle:           peerSvc.Bundle(),
+	}
+
+	// The endpoint must present the configured SPIFFE ID
+	wrongID := peer
+	wrongID.EndpointSPIFFEID = "spiffe://" + peerTrustDomain + "/other"
+	fetcher, err := federation.NewFetcher([]federation.Peer{wrongID}, 0, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create fetcher: %v", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), peerTrustDomain); err == nil {
+		t.Fatal("expected fetching from an endpoint with an unexpected SPIFFE ID to fail")
+	}
+
+	cacheDir := t.TempDir()
+	fetcher, err = federation.NewFetcher([]federation.Peer{peer}, 0, cacheDir, nil)
+	if err != nil {
+		t.Fatalf("failed to create fetcher: %v", err)
+	}
+	next, err := fetcher.Fetch(context.Background(), peerTrustDomain)
+	if err != nil {
+		t.Fatalf("failed to fetch bundle: %v", err)
+	}
+	if next != time.Minute {
+		t.Errorf("expected the refresh hint of the endpoint, got %s", next)
+	}
+
+	// The cached bundle replaces the initial one after a restart
+	noBundle := peer
+	noBundle.Bundle = nil
+	if _, err := federation.NewFetcher([]federation.Peer{noBundle}, 0, "", nil); err == nil {
+		t.Fatal("expected https_spiffe without an initial bundle to be rejected")
+	}
+	restarted, err := federation.NewFetcher([]federation.Peer{noBundle}, 0, cacheDir, nil)
+	if err != nil {
+		t.Fatalf("failed to load cached bundle: %v", err)
+	}
+	if _, err := restarted.Fetch(context.Background(), peerTrustDomain); err != nil {
+		t.Fatalf("failed to fetch bundle with the cached one: %v", err)
+	}
+
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.FederatedBundles = fetcher
+	})
+	h.register(agentID)
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	federated := result.FederatedBundles[peerTrustDomain]
+	if len(federated) != 1 || !federated[0].Equal(peerSvc.Bundle()[0]) {
+		t.Fatalf("expected the bundle of %s, got %v", peerTrustDomain, result.FederatedBundles)
+	}
+
+	// fogtls verifies peers of the federated trust domain against its bundle
+	src := staticSource{svid: result.SVID, bundles: map[string][]*x509.Certificate{trustDomain: result.Bundle}}
+	clientConfig := fogtls.ClientConfig(src, fogtls.AuthorizeMemberOf(peerTrustDomain))
+	if conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig); err == nil {
+		conn.Close()
+		t.Fatal("expected a peer of a trust domain without a bundle to be refused")
+	}
+	src.bundles[peerTrustDomain] = federated
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("failed to connect to federated peer: %v", err)
+	}
+	conn.Close()
+}
+
+// staticSource serves a fixed X509-SVID and bundles
+type staticSource struct {
+	svid    *client.SVID
+	bundles map[string][]*x509.Certificate
+}
+
+func (s staticSource) X509SVID() (*client.SVID, error) {
+	return s.svid, nil
+}
+
+func (s staticSource) Bundle(trustDomain string) ([]*x509.Certificate, error) {
+	if bundle, ok := s.bundles[trustDomain]; ok {
+		return bundle, nil
+	}
+	return nil, fmt.Errorf("no bundle for trust domain %s", trustDomain)
+}
+
+func TestSPIREAPI(t *testing.T) {
+	// SPIRE agents and servers only understand the messages if the field numbers match
+	for _, tc := range []struct {
+		msg    proto.Message
+		fields map[protoreflect.Name]protoreflect.FieldNumber
+	}{
+		{&spiretypes.X509SVID{}, map[protoreflect.Name]protoreflect.FieldNumber{"id": 1, "cert_chain": 2, "expires_at": 3}},
+		{&spiretypes.AttestationData{}, map[protoreflect.Name]protoreflect.FieldNumber{"type": 1, "payload": 2}},
+		{&agentv1.AttestAgentResponse_Result{}, map[protoreflect.Name]protoreflect.FieldNumber{"svid": 1, "reattestable": 2}},
+		{&agentv1.AttestAgentResponse{}, map[protoreflect.Name]protoreflect.FieldNumber{"result": 1, "challenge": 2}},
+		{&agentv1.AttestAgentRequest{}, map[protoreflect.Name]protoreflect.FieldNumber{"params": 1, "challenge_response": 2}},
+	} {
+		desc := tc.msg.ProtoReflect().Descriptor()
+		if desc.Fields().Len() != len(tc.fields) {
+			t.Errorf("%s has %d fields, expected %d", desc.FullName(), desc.Fields().Len(), len(tc.fields))
+		}
+		for name, number := range tc.fields {
+			if f := desc.Fields().ByName(name); f == nil || f.Number() != number {
+				t.Errorf("expected %s.%s to be field %d", desc.FullName(), name, number)
+			}
+		}
+	}
+	if agentv1.Agent_AttestAgent_FullMethodName != "/spire.api.server.agent.v1.Agent/AttestAgent" {
+		t.Errorf("unexpected method %s", agentv1.Agent_AttestAgent_FullMethodName)
+	}
+
+	h := newHarness(t, withChallenges(server.ChallengeActivation, server.ChallengeQuote, server.ChallengeNonce))
+	h.register(agentID, h.pcrSelector(t, 0))
+
+	// Typed challenges are carried as opaque SPIRE challenges
+	result, err := h.attestSPIRE(t, agentID)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if want := "spiffe://" + trustDomain + "/" + agentID; result.SVID.ID != want || len(result.SVIDs) != 1 {
+		t.Fatalf("expected only the X509-SVID for %s, got %s", want, result.SVID.ID)
+	}
+}
+
+func TestSPIREAPIPayloadVersion1(t *testing.T) {
+	h := newHarness(t, allowUnboundCSR)
+	h.register(agentID)
+
+	// Version 1 only has the activation challenge
+	if _, err := h.attestSPIRE(t, agentID, client.WithPayloadVersion(common.PayloadVersionProto)); err != nil {
+		t.Fatalf("attestation with payload version 1 failed: %v", err)
+	}
+	if _, err := h.attestSPIRE(t, agentID, client.WithPayloadVersion(common.PayloadVersionJSON)); err == nil {
+		t.Fatal("expected legacy JSON payloads to be refused over the SPIRE API")
+	}
+	if _, err := h.attestSPIRE(t, agentID, client.WithTPMKey()); err == nil {
+		t.Fatal("expected TPM keys to be refused over the SPIRE API")
+	}
+}
+
+func TestAttestUnknownEK(t *testing.T) {
+	h := newHarness(t)
+
+	_, err := h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid EK hash")
+}
+
+func TestAttestWrongSPIFFEID(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	_, err := h.attest(t, "someone-else", nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid SPIFFE ID requested")
+}
+
+func TestAttestTamperedChallengeResponse(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return tamperingStream{s}
+	})
+	requireStatus(t, err, codes.PermissionDenied, "challenge response does not match")
+}
+
+func TestAttestInvalidAK(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.PerEKLimit = server.RateLimit{Rate: -1}
+	})
+	h.register(agentID)
+
+	// public rewrites the AK public area
+	public := func(change func(*tpm2.Public)) func(*attest.AttestationParameters) {
+		return func(ak *attest.AttestationParameters) {
+			pub, err := tpm2.DecodePublic(ak.Public)
+			if err != nil {
+				t.Fatalf("failed to decode AK: %v", err)
+			}
+			change(&pub)
+			if ak.Public, err = pub.Encode(); err != nil {
+				t.Fatalf("failed to encode AK: %v", err)
+			}
+		}
+	}
+	// attributes rewrites the attributes of the AK public area
+	attributes := func(change func(tpm2.KeyProp) tpm2.KeyProp) func(*attest.AttestationParameters) {
+		return public(func(pub *tpm2.Public) { pub.Attributes = change(pub.Attributes) })
+	}
+
+	for name, tc := range map[string]struct {
+		tamper func(*attest.AttestationParameters)
+		msg    string
+	}{
+		"exportable": {
+			tamper: attributes(func(a tpm2.KeyProp) tpm2.KeyProp { return a &^ tpm2.FlagFixedTPM }),
+			msg:    "AK is exportable",
+		},
+		"duplicable": {
+			tamper: attributes(func(a tpm2.KeyProp) tpm2.KeyProp { return a &^ tpm2.FlagFixedParent }),
+			msg:    "provided key is not limited to attestation",
+		},
+		"imported": {
+			tamper: attributes(func(a tpm2.KeyProp) tpm2.KeyProp { return a &^ tpm2.FlagSensitiveDataOrigin }),
+			msg:    "provided key is not limited to attestation",
+		},
+		"unrestricted": {
+			tamper: attributes(func(a tpm2.KeyProp) tpm2.KeyProp { return a &^ tpm2.FlagRestricted }),
+			msg:    "provided key is not limited to attestation",
+		},
+		"decrypting": {
+			tamper: attributes(func(a tpm2.KeyProp) tpm2.KeyProp { return a | tpm2.FlagDecrypt }),
+			msg:    "AK must be a signing-only key",
+		},
+		"PSS scheme": {
+			tamper: public(func(pub *tpm2.Public) { pub.RSAParameters.Sign.Alg = tpm2.AlgRSAPSS }),
+			msg:    "unsupported AK signature scheme",
+		},
+		"SHA-1 signatures": {
+			tamper: public(func(pub *tpm2.Public) { pub.RSAParameters.Sign.Hash = tpm2.AlgSHA1 }),
+			msg:    "unsupported AK signature hash",
+		},
+		"not attested by a TPM": {
+			tamper: func(ak *attest.AttestationParameters) { ak.CreateAttestation = flipBit(ak.CreateAttestation) },
+			msg:    "incorrect magic value",
+		},
+		"forged creation signature": {
+			tamper: func(ak *attest.AttestationParameters) {
+				sig := slices.Clone(ak.CreateSignature)
+				sig[len(sig)-1] ^= 0x01
+				ak.CreateSignature = sig
+			},
+			msg: "could not verify attestation",
+		},
+		"missing creation data": {
+			tamper: func(ak *attest.AttestationParameters) { ak.CreateData = nil },
+			msg:    "DecodeCreationData() failed",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+				return akTamperingStream{Agent_AttestAgentClient: s, t: t, tamper: tc.tamper}
+			})
+			requireStatus(t, err, codes.InvalidArgument, tc.msg)
+		})
+	}
+}
+
+func TestAttestMalformedParams(t *testing.T) {
+	h := newHarness(t)
+	h.register(agentID)
+
+	for name, tc := range map[string]struct {
+		params *agent.AttestAgentRequest_Params
+		msg    string
+	}{
+		"missing data": {
+			params: &agent.AttestAgentRequest_Params{
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "missing attestation data",
+		},
+		"missing CSR": {
+			params: &agent.AttestAgentRequest_Params{
+				Data: &agent.AttestationData{Type: "tpm_activation", Payload: []byte("{}")},
+			},
+			msg: "missing X509-SVID parameters",
+		},
+		"unsupported type": {
+			params: &agent.AttestAgentRequest_Params{
+				Data:   &agent.AttestationData{Type: "join_token", Payload: []byte("{}")},
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "unsupported type",
+		},
+		"payload is not JSON": {
+			params: &agent.AttestAgentRequest_Params{
+				Data:   &agent.AttestationData{Type: "tpm_activation", Payload: []byte("not json")},
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "malformed activation param",
+		},
+		"payload is not protobuf": {
+			params: &agent.AttestAgentRequest_Params{
+				Data: &agent.AttestationData{
+					Type:           "tpm_activation",
+					Payload:        []byte{0xff},
+					PayloadVersion: common.PayloadVersionProto,
+				},
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "malformed activation param",
+		},
+		"unsupported payload version": {
+			params: &agent.AttestAgentRequest_Params{
+				Data:   &agent.AttestationData{Type: "tpm_activation", Payload: []byte("{}"), PayloadVersion: 99},
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "unsupported payload version: 99",
+		},
+		"missing AK": {
+			params: &agent.AttestAgentRequest_Params{
+				Data:   &agent.AttestationData{Type: "tpm_activation", Payload: []byte("{}")},
+				Params: &agent.AgentX509SVIDParams{Csr: []byte("csr")},
+			},
+			msg: "missing AK attestation parameters",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			stream := h.stream(t, ctx)
+			if err := stream.Send(&agent.AttestAgentRequest{
+				Step: &agent.AttestAgentRequest_Params_{Params: tc.params},
+			}); err != nil {
+				t.Fatalf("failed to send params: %v", err)
+			}
+
+			_, err := stream.Recv()
+			requireStatus(t, err, codes.InvalidArgument, tc.msg)
+		})
+	}
+}
+
+// logRecorder collects the JSON logs of a server, which its handlers write concurrently
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *logRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// wait returns the first record with msg and session ID, waiting for handlers that log after the
+// agent received their status
+func (r *logRecorder) wait(t *testing.T, msg, sessionID string) map[string]any {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		lines := strings.Split(r.String(), "\n")
+		for _, line := range lines {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) != nil {
+				continue
+			}
+			if record["msg"] == msg && (sessionID == "" || record["session_id"] == sessionID) {
+				return record
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server did not log %q for session %q:\n%s", msg, sessionID, r)
+	return nil
+}
+
+func TestSessionLogs(t *testing.T) {
+	logs := &logRecorder{}
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	})
+	h.register(agentID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The session ID in the header identifies the logs of the attestation
+	stream := h.stream(t, ctx)
+	header, err := stream.Header()
+	if err != nil {
+		t.Fatalf("failed to receive header: %v", err)
+	}
+	ids := header.Get(common.SessionIDHeader)
+	if len(ids) != 1 || len(ids[0]) != 16 {
+		t.Fatalf("expected a session ID header, got %v", ids)
+	}
+	if err := stream.Send(&agent.AttestAgentRequest{}); err != nil {
+		t.Fatalf("failed to send params: %v", err)
+	}
+	_, err = stream.Recv()
+	requireStatus(t, err, codes.InvalidArgument, "malformed attestation param")
+
+	failed := logs.wait(t, "attestation failed", ids[0])
+	if failed["code"] != codes.InvalidArgument.String() || failed["peer"] == nil {
+		t.Errorf("expected the failure to be logged with its code and peer, got %v", failed)
+	}
+
+	if _, err := h.attest(t, agentID, nil); err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	succeeded := logs.wait(t, "attestation succeeded", "")
+	if succeeded["session_id"] == ids[0] || succeeded["session_id"] == nil {
+		t.Errorf("expected a new session ID, got %v", succeeded["session_id"])
+	}
+	if succeeded["ek_hash"] != h.ekHash {
+		t.Errorf("expected ek_hash %s, got %v", h.ekHash, succeeded["ek_hash"])
+	}
+	if want := "spiffe://" + trustDomain + "/" + agentID; succeeded["spiffe_id"] != want {
+		t.Errorf("expected spiffe_id %s, got %v", want, succeeded["spiffe_id"])
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.Registerer = reg
+	})
+	h.register(agentID)
+
+	if _, err := h.attest(t, agentID, nil); err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	_, err := h.attest(t, "someone-else", nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid SPIFFE ID requested")
+	_, err = h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return tamperingStream{s}
+	})
+	requireStatus(t, err, codes.PermissionDenied, "challenge response does not match")
+
+	// The handler counts an attestation after it returned, which can be after the agent received the status
+	expected := `
+# HELP spiffe_fog_attestations_total Attestations by attestation type and outcome.
+# TYPE spiffe_fog_attestations_total counter
+spiffe_fog_attestations_total{outcome="rejected",type="tpm_activation"} 2
+spiffe_fog_attestations_total{outcome="success",type="tpm_activation"} 1
+# HELP spiffe_fog_attestation_rejections_total Rejected attestations by reason.
+# TYPE spiffe_fog_attestation_rejections_total counter
+spiffe_fog_attestation_rejections_total{reason="challenge_mismatch"} 1
+spiffe_fog_attestation_rejections_total{reason="unauthorized_id"} 1
+# HELP spiffe_fog_svids_issued_total X509-SVIDs signed for attested agents.
+# TYPE spiffe_fog_svids_issued_total counter
+spiffe_fog_svids_issued_total 1
+# HELP spiffe_fog_active_attestation_streams AttestAgent streams currently open.
+# TYPE spiffe_fog_active_attestation_streams gauge
+spiffe_fog_active_attestation_streams 0
+`
+	names := []string{
+		"spiffe_fog_attestations_total",
+		"spiffe_fog_attestation_rejections_total",
+		"spiffe_fog_svids_issued_total",
+		"spiffe_fog_active_attestation_streams",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := testutil.GatherAndCompare(reg, strings.NewReader(expected), names...)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected metrics: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	count, err := testutil.GatherAndCount(reg, "spiffe_fog_attestation_step_duration_seconds")
+	if err != nil {
+		t.Fatalf("failed to gather step durations: %v", err)
+	}
+	if count == 0 {
+		t.Error("expected step durations to be observed")
+	}
+}
+
+// spanRecorder records the spans of every test. The tracers of the packages delegate to the
+// first global tracer provider, so it is only installed once.
+var spanRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+})
+
+func TestTracing(t *testing.T) {
+	recorder := spanRecorder()
+	h := newHarness(t)
+	h.register(agentID)
+
+	// attest runs an attestation under an Attest span, like the client command, and returns the
+	// spans of its trace by name
+	attest := func(wrap func(agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient) (map[string]sdktrace.ReadOnlySpan, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		ctx, span := otel.Tracer("github.com/mjlshen/spiffe_fog/test/e2e").Start(ctx, "Attest")
+		result, err := h.attestContext(t, ctx, agentID, wrap)
+		span.End()
+		if err == nil {
+			result.Close()
+		}
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		for _, s := range recorder.Ended() {
+			if s.SpanContext().TraceID() == span.SpanContext().TraceID() {
+				spans[s.Name()] = s
+			}
+		}
+		return spans, err
+	}
+
+	// The server spans join the trace of the client through the gRPC metadata
+	spans, err := attest(nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	for _, name := range []string{
+		"Attest",
+		"GenerateCredentialActivationData",
+		"SolveCredentialActivationChallenge",
+		"GenerateKey",
+		"CreateCSR",
+		"GenerateChallenge",
+		"ChallengeRoundTrip",
+		"VerifyChallengeResponse",
+		"SignX509SVIDs",
+	} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("expected a %s span in the trace of the attestation", name)
+			continue
+		}
+		if s.Status().Code == otelcodes.Error {
+			t.Errorf("expected the %s span not to fail, got %q", name, s.Status().Description)
+		}
+	}
+
+	// A rejected challenge response fails the span that verified it
+	spans, err = attest(func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return tamperingStream{s}
+	})
+	requireStatus(t, err, codes.PermissionDenied, "challenge response does not match")
+	verify, ok := spans["VerifyChallengeResponse"]
+	if !ok {
+		t.Fatal("expected a VerifyChallengeResponse span in the trace of the attestation")
+	}
+	if status := verify.Status(); status.Code != otelcodes.Error || !strings.Contains(status.Description, "challenge response does not match") {
+		t.Errorf("expected the VerifyChallengeResponse span to fail, got %+v", status)
+	}
+	if events := verify.Events(); len(events) == 0 || events[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded on the VerifyChallengeResponse span, got %v", events)
+	}
+	if _, ok := spans["SignX509SVIDs"]; ok {
+		t.Error("expected no X509-SVID to be signed for a rejected attestation")
+	}
+}
+
+func TestAttestStepTimeout(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.StepTimeout = 200 * time.Millisecond
+	})
+	h.register(agentID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// An agent that never sends its params is cut off after the step timeout
+	_, err := h.stream(t, ctx).Recv()
+	requireStatus(t, err, codes.DeadlineExceeded, "failed to receive request from stream")
+
+	_, err = h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return delayingStream{Agent_AttestAgentClient: s, delay: time.Second}
+	})
+	requireStatus(t, err, codes.DeadlineExceeded, "failed to receive challenge response")
+}
+
+func TestAttestTimeout(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.AttestTimeout = time.Second
+	})
+	h.register(agentID)
+
+	// No step exceeds the step timeout, but together they exceed the attestation timeout
+	_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+		return delayingStream{Agent_AttestAgentClient: s, delay: 600 * time.Millisecond}
+	})
+	requireStatus(t, err, codes.DeadlineExceeded, "failed to receive challenge response")
+}
+
+func TestAttestRateLimits(t *testing.T) {
+	t.Run("per IP", func(t *testing.T) {
+		h := newHarness(t, func(cfg *server.Config) {
+			cfg.PerIPLimit = server.RateLimit{Rate: 0.001, Burst: 1}
+		})
+		h.register(agentID)
+
+		if _, err := h.attest(t, agentID, nil); err != nil {
+			t.Fatalf("attestation failed: %v", err)
+		}
+		_, err := h.attest(t, agentID, nil)
+		requireStatus(t, err, codes.ResourceExhausted, "rate limit exceeded for")
+	})
+
+	t.Run("per EK", func(t *testing.T) {
+		h := newHarness(t, func(cfg *server.Config) {
+			cfg.PerEKLimit = server.RateLimit{Rate: 0.001, Burst: 1}
+		})
+		h.register(agentID)
+
+		if _, err := h.attest(t, agentID, nil); err != nil {
+			t.Fatalf("attestation failed: %v", err)
+		}
+		_, err := h.attest(t, agentID, nil)
+		requireStatus(t, err, codes.ResourceExhausted, "rate limit exceeded for EK: "+h.ekHash)
+	})
+}
+
+func TestAttestForwardedFor(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.TrustForwardedFor = true
+		cfg.PerIPLimit = server.RateLimit{Rate: 0.001, Burst: 1}
+	})
+
+	// attempt opens a stream as forwarded by a proxy and sends empty params
+	attempt := func(forwardedFor string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", forwardedFor)
+		stream := h.stream(t, ctx)
+		if err := stream.Send(&agent.AttestAgentRequest{}); err != nil {
+			t.Fatalf("failed to send params: %v", err)
+		}
+		_, err := stream.Recv()
+		return err
+	}
+
+	requireStatus(t, attempt("198.51.100.1, 203.0.113.7"), codes.InvalidArgument, "malformed attestation param")
+
+	// The client cannot escape the limit by spoofing the addresses the proxy appends to
+	requireStatus(t, attempt("198.51.100.2, 203.0.113.7"), codes.ResourceExhausted, "rate limit exceeded for 203.0.113.7")
+
+	// Another address the proxy received a request from is limited separately
+	requireStatus(t, attempt("198.51.100.1, 203.0.113.8"), codes.InvalidArgument, "malformed attestation param")
+}
+
+func TestCAExpired(t *testing.T) {
+	authority, err := ca.New(trustDomain, time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.CA = authority
+	})
+	h.register(agentID)
+
+	// Nothing is issued by an expired CA, even for less than the SVID TTL
+	_, err = h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.Internal, "CA expired")
+
+	// The server is not ready
+	svc, err := server.New(server.Config{TrustDomain: trustDomain, CA: authority})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := svc.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "CA expired") {
+		t.Errorf("expected the readiness check to fail, got %v", err)
+	}
+}
+
+func TestPin(t *testing.T) {
+	svc, err := server.New(server.Config{TrustDomain: trustDomain})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	serverConfig, err := svc.TLSConfig(server.TLSConfig{Bootstrap: true, DNSNames: []string{"localhost"}})
+	if err != nil {
+		t.Fatalf("failed to create serving certificate: %v", err)
+	}
+	stale, err := ca.New(trustDomain, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+
+	dir := t.TempDir()
+	caBundle := filepath.Join(dir, "ca.pem")
+	staleBundle := filepath.Join(dir, "stale.pem")
+	if err := client.SaveBundle(caBundle, svc.Bundle()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SaveBundle(staleBundle, []*x509.Certificate{stale.Certificate()}); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(caBundle, bundlePath, serverID, publicKeySHA256 string) (*x509.Certificate, error) {
+		t.Helper()
+		pin, err := client.LoadPin(caBundle, bundlePath, serverID, publicKeySHA256)
+		if err != nil {
+			t.Fatalf("failed to load pin: %v", err)
+		}
+		clientConfig, err := pin.TLSConfig()
+		if err != nil {
+			t.Fatalf("failed to create TLS config: %v", err)
+		}
+		clientConfig.ServerName = "localhost"
+		return handshake(serverConfig, clientConfig)
+	}
+
+	leaf, err := connect(caBundle, "", "", "")
+	if err != nil {
+		t.Fatalf("expected the bundle to anchor the server: %v", err)
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	serverID := "spiffe://" + trustDomain + "/server"
+
+	for name, tc := range map[string]struct {
+		caBundle, bundlePath, serverID, publicKeySHA256 string
+		ok                                              bool
+	}{
+		"other bundle":         {caBundle: staleBundle},
+		"server ID":            {caBundle: caBundle, serverID: serverID, ok: true},
+		"unexpected server ID": {caBundle: caBundle, serverID: serverID + "-other"},
+		"public key":           {publicKeySHA256: hex.EncodeToString(sum[:]), ok: true},
+		"other public key":     {publicKeySHA256: strings.Repeat("ab", sha256.Size)},
+		// A stale saved bundle, e.g. after the CA was replaced, falls back to the configured one
+		"stale saved bundle":   {caBundle: caBundle, bundlePath: staleBundle, serverID: serverID, ok: true},
+		"missing saved bundle": {caBundle: caBundle, bundlePath: filepath.Join(dir, "missing.pem"), ok: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := connect(tc.caBundle, tc.bundlePath, tc.serverID, tc.publicKeySHA256)
+			if tc.ok && err != nil {
+				t.Errorf("expected the handshake to succeed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("expected the handshake to fail")
+			}
+		})
+	}
+
+	// Only a bundle that anchors the server's chain is saved
+	if err := client.VerifyServerChain(svc.Bundle(), []*x509.Certificate{leaf}); err != nil {
+		t.Errorf("expected the server bundle to anchor its chain: %v", err)
+	}
+	if err := client.VerifyServerChain([]*x509.Certificate{stale.Certificate()}, []*x509.Certificate{leaf}); err == nil {
+		t.Error("expected a stale bundle not to anchor the server's chain")
+	}
+	if err := client.VerifyServerChain(svc.Bundle(), nil); err == nil {
+		t.Error("expected a bundle not to be saved without a server chain")
+	}
+}
+
+func TestPinClientCertificate(t *testing.T) {
+	h := newHarness(t)
+	h.register("agent")
+
+	serverConfig, err := h.svc.TLSConfig(server.TLSConfig{
+		Bootstrap:  true,
+		DNSNames:   []string{"localhost"},
+		ClientAuth: server.ClientAuthRequire,
+	})
+	if err != nil {
+		t.Fatalf("failed to create serving certificate: %v", err)
+	}
+
+	dir := t.TempDir()
+	connect := func() (*x509.Certificate, error) {
+		t.Helper()
+		clientConfig, err := client.Pin{Bundle: h.svc.Bundle(), SVIDDir: dir}.TLSConfig()
+		if err != nil {
+			t.Fatalf("failed to create TLS config: %v", err)
+		}
+		clientConfig.ServerName = "localhost"
+		return handshake(serverConfig, clientConfig)
+	}
+
+	// An agent attesting for the first time has no X509-SVID to present
+	if _, err := connect(); err == nil {
+		t.Fatal("expected the server to require a client certificate")
+	}
+
+	result, err := h.attest(t, "agent", nil)
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if err := (client.Output{Dir: dir}).Write(context.Background(), result); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	if _, err := connect(); err != nil {
+		t.Errorf("expected the saved X509-SVID to be accepted: %v", err)
+	}
+}
+
+func TestAttestTrustDomain(t *testing.T) {
+	h := newHarness(t, func(cfg *server.Config) {
+		cfg.TrustDomain = "example.org"
+	})
+	h.store.AddNode(registry.Node{EKHash: h.ekHash})
+	h.store.AddEntry(registry.Entry{
+		SPIFFEID:  "spiffe://example.org/agent",
+		Selectors: []registry.Selector{{Type: "tpm", Value: "ek_hash:" + h.ekHash}},
+	})
+
+	if _, err := h.attest(t, "agent", nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected the default trust domain to be rejected, got %v", err)
+	}
+	result, err := h.attest(t, "agent", nil, client.WithTrustDomain("example.org"))
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+	if got := result.SVID.ID; got != "spiffe://example.org/agent" {
+		t.Errorf("expected spiffe://example.org/agent, got %s", got)
+	}
+}
@@ -0,0 +1,274 @@
+//go:build linux && cgo
+
+package e2e
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mjlshen/spiffe_fog/pkg/config"
+	"github.com/mjlshen/spiffe_fog/proto/agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// serverProcess is the server binary running with a configuration file
+type serverProcess struct {
+	cmd        *exec.Cmd
+	logs       *logRecorder
+	healthAddr string
+}
+
+// startServer builds and starts the server with the configuration file at configPath and the
+// extra environment variables, and waits until it is live
+func startServer(t *testing.T, configPath, healthAddr string, env ...string) *serverProcess {
+	t.Helper()
+
+	bin := filepath.Join(t.TempDir(), "server")
+	if out, err := exec.Command("go", "build", "-o", bin, "github.com/mjlshen/spiffe_fog/cmd/server").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build server: %v\n%s", err, out)
+	}
+
+	logs := &logRecorder{}
+	cmd := exec.Command(bin, "-config", configPath)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	p := &serverProcess{cmd: cmd, logs: logs, healthAddr: healthAddr}
+	deadline := time.Now().Add(30 * time.Second)
+	for p.get("/healthz") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become live:\n%s", logs)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return p
+}
+
+// get returns the status code of path on the health listener, or 0 if it cannot be reached
+func (p *serverProcess) get(path string) int {
+	resp, err := http.Get("http://" + p.healthAddr + path)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// signal sends sig to the server
+func (p *serverProcess) signal(t *testing.T, sig os.Signal) {
+	t.Helper()
+
+	if err := p.cmd.Process.Signal(sig); err != nil {
+		t.Fatalf("failed to send %s: %v", sig, err)
+	}
+}
+
+// freeAddr returns a loopback address that nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// writeServerConfig writes a configuration registering the EK with ekHash, entitled to agentID if
+// entitled is set
+func writeServerConfig(t *testing.T, path, healthAddr, ekHash string, entitled bool, drainTimeout time.Duration) {
+	t.Helper()
+
+	entries := "[]"
+	if entitled {
+		entries = fmt.Sprintf(`[{id: agent, spiffe_id: "spiffe://spiffe_fog/%s", selectors: ["tpm:ek_hash:%s"]}]`, agentID, ekHash)
+	}
+	cfg := fmt.Sprintf(`trust_domain: %[1]s
+listen:
+  grpc: "127.0.0.1:0"
+  metrics: %[3]q
+  health: %[3]q
+ek_registry:
+  nodes:
+    - ek_hash: %[2]s
+datastore:
+  entries: %[4]s
+logging:
+  format: json
+shutdown:
+  drain_timeout: %[5]s
+`, trustDomain, ekHash, healthAddr, entries, drainTimeout)
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatalf("failed to write configuration: %v", err)
+	}
+}
+
+// dial connects to the gRPC listener of a server
+func dial(t *testing.T, addr string) *grpc.ClientConn {
+	t.Helper()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConfigOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(`
+svid_ttl: 10m
+attestation:
+  step_timeout: 5s
+  challenges: [activation]
+rate_limits:
+  per_ip:
+    rate: 5
+    burst: 20
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SPIFFE_FOG_RATE_LIMITS_PER_IP_RATE", "2")
+	t.Setenv("SPIFFE_FOG_ATTESTATION_CHALLENGES", "activation,quote")
+	t.Setenv("PORT", "9999")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	// Files override defaults and the environment overrides files
+	if cfg.SVIDTTL != 10*time.Minute || cfg.Attestation.StepTimeout != 5*time.Second || cfg.RateLimits.PerIP.Burst != 20 {
+		t.Errorf("expected the file to override the defaults, got %+v", cfg)
+	}
+	if cfg.Attestation.AttestTimeout != 2*time.Minute {
+		t.Errorf("expected the default attest timeout, got %s", cfg.Attestation.AttestTimeout)
+	}
+	if cfg.RateLimits.PerIP.Rate != 2 {
+		t.Errorf("expected the environment to override the per-IP rate, got %v", cfg.RateLimits.PerIP.Rate)
+	}
+	if got := cfg.Attestation.Challenges; len(got) != 2 || got[1] != "quote" {
+		t.Errorf("expected the environment to override the challenges, got %v", got)
+	}
+	if cfg.Listen.GRPC != ":9999" {
+		t.Errorf("expected PORT to override the gRPC listen address, got %s", cfg.Listen.GRPC)
+	}
+
+	if err := os.WriteFile(path, []byte("unknown_setting: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path); err == nil {
+		t.Error("expected unknown settings to be rejected")
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	sim, ekHash := newSimulator(t)
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	healthAddr := freeAddr(t)
+	writeServerConfig(t, path, healthAddr, ekHash, false, 10*time.Second)
+
+	// PORT overrides listen.grpc
+	grpcAddr := freeAddr(t)
+	_, port, _ := net.SplitHostPort(grpcAddr)
+	p := startServer(t, path, healthAddr, "PORT="+port)
+	h := &harness{sim: sim, ekHash: ekHash, conn: dial(t, grpcAddr)}
+
+	_, err := h.attest(t, agentID, nil)
+	requireStatus(t, err, codes.InvalidArgument, "invalid SPIFFE ID requested")
+
+	// SIGHUP applies the new entry, the drain timeout only changes on restart
+	writeServerConfig(t, path, healthAddr, ekHash, true, 20*time.Second)
+	p.signal(t, syscall.SIGHUP)
+	p.logs.wait(t, "reloaded configuration", "")
+	warning := p.logs.wait(t, "some settings only take effect after a restart", "")
+	if settings, _ := warning["settings"].([]any); len(settings) != 1 || settings[0] != "shutdown" {
+		t.Errorf("expected only shutdown to require a restart, got %v", warning["settings"])
+	}
+
+	result, err := h.attest(t, agentID, nil)
+	if err != nil {
+		t.Fatalf("attestation failed after reload: %v", err)
+	}
+	if want := "spiffe://" + trustDomain + "/" + agentID; result.SVID.ID != want {
+		t.Errorf("expected %s, got %s", want, result.SVID.ID)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	for name, tc := range map[string]struct {
+		drainTimeout time.Duration
+		finished     bool
+	}{
+		"drained":       {drainTimeout: 10 * time.Second, finished: true},
+		"drain timeout": {drainTimeout: 500 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sim, ekHash := newSimulator(t)
+			path := filepath.Join(t.TempDir(), "server.yaml")
+			healthAddr := freeAddr(t)
+			writeServerConfig(t, path, healthAddr, ekHash, true, tc.drainTimeout)
+
+			grpcAddr := freeAddr(t)
+			_, port, _ := net.SplitHostPort(grpcAddr)
+			p := startServer(t, path, healthAddr, "PORT="+port)
+			h := &harness{sim: sim, ekHash: ekHash, conn: dial(t, grpcAddr)}
+			if code := p.get("/readyz"); code != http.StatusOK {
+				t.Fatalf("expected the server to be ready, got %d", code)
+			}
+
+			// A slow attestation is in progress when SIGTERM arrives
+			attested := make(chan error, 1)
+			go func() {
+				_, err := h.attest(t, agentID, func(s agent.Agent_AttestAgentClient) agent.Agent_AttestAgentClient {
+					return delayingStream{Agent_AttestAgentClient: s, delay: time.Second}
+				})
+				attested <- err
+			}()
+			p.logs.wait(t, "received attestation request", "")
+			p.signal(t, syscall.SIGTERM)
+			p.logs.wait(t, "shutting down", "")
+
+			// Readiness fails while draining, but the process is still alive
+			if code := p.get("/readyz"); code != http.StatusServiceUnavailable {
+				t.Errorf("expected readiness to fail while draining, got %d", code)
+			}
+			if code := p.get("/healthz"); code != http.StatusOK {
+				t.Errorf("expected liveness to succeed while draining, got %d", code)
+			}
+
+			err := <-attested
+			if tc.finished && err != nil {
+				t.Errorf("expected the attestation in progress to finish: %v", err)
+			}
+			if !tc.finished {
+				if err == nil {
+					t.Error("expected the attestation in progress to be cancelled")
+				}
+				p.logs.wait(t, "attestations did not finish before the drain timeout, cancelling them", "")
+			}
+
+			if err := p.cmd.Wait(); err != nil {
+				t.Errorf("expected the server to exit cleanly: %v", err)
+			}
+		})
+	}
+}
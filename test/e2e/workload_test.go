@@ -0,0 +1,159 @@
+//go:build linux && cgo
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mjlshen/spiffe_fog/pkg/registry"
+	"github.com/mjlshen/spiffe_fog/pkg/workload"
+)
+
+const (
+	fakePID         = 4242
+	fakeContainerID = "3f1c2a9e8d7b6c5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d9c8b7a6f5e4d3c2b1a"
+)
+
+// fakeProc creates a procfs at a temporary root with the cgroup and stat files of fakePID, leaving
+// out the files that are empty
+func fakeProc(t *testing.T, cgroup, stat string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, fmt.Sprint(fakePID))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"cgroup": cgroup, "stat": stat} {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// procStat returns a stat line of fakePID with comm and the start time
+func procStat(comm string, started int) string {
+	return fmt.Sprintf("%d (%s) S %s%d 0 0\n", fakePID, comm, strings.Repeat("0 ", 18), started)
+}
+
+func cgroupSelector(value string) registry.Selector {
+	return registry.Selector{Type: "cgroup", Value: value}
+}
+
+func TestCgroupAttestor(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cgroup string
+		stat   string
+		want   []registry.Selector
+		err    string
+	}{
+		"cgroup v1 docker": {
+			cgroup: "12:memory:/docker/" + fakeContainerID + "\n11:cpu,cpuacct:/docker/" + fakeContainerID + "\n1:name=systemd:/system.slice/docker.service\n",
+			stat:   procStat("envoy", 100),
+			want: []registry.Selector{
+				cgroupSelector("path:/docker/" + fakeContainerID),
+				cgroupSelector("path:/system.slice/docker.service"),
+				cgroupSelector("container_id:" + fakeContainerID),
+			},
+		},
+		"cgroup v2 containerd": {
+			cgroup: "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + fakeContainerID + ".scope\n",
+			stat:   procStat("envoy", 100),
+			want: []registry.Selector{
+				cgroupSelector("path:/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + fakeContainerID + ".scope"),
+				cgroupSelector("container_id:" + fakeContainerID),
+			},
+		},
+		"host process": {
+			cgroup: "0::/user.slice/user-1000.slice/session-1.scope\n",
+			stat:   procStat("envoy", 100),
+			want:   []registry.Selector{cgroupSelector("path:/user.slice/user-1000.slice/session-1.scope")},
+		},
+		"malformed lines are skipped": {
+			cgroup: "garbage\n0::/system.slice/envoy.service\n",
+			stat:   procStat("envoy", 100),
+			want:   []registry.Selector{cgroupSelector("path:/system.slice/envoy.service")},
+		},
+		"command name with spaces and parentheses": {
+			cgroup: "0::/system.slice/envoy.service\n",
+			stat:   procStat("envoy (worker) 1)", 100),
+			want:   []registry.Selector{cgroupSelector("path:/system.slice/envoy.service")},
+		},
+		"missing cgroup": {
+			stat: procStat("envoy", 100),
+			err:  "failed to read cgroups of process 4242",
+		},
+		"missing stat": {
+			cgroup: "0::/system.slice/envoy.service\n",
+			err:    "failed to read process 4242",
+		},
+		"truncated stat": {
+			cgroup: "0::/system.slice/envoy.service\n",
+			stat:   fmt.Sprintf("%d (envoy) S 1 2 3\n", fakePID),
+			err:    "malformed stat for process 4242",
+		},
+		"stat without command name": {
+			cgroup: "0::/system.slice/envoy.service\n",
+			stat:   "4242 envoy S\n",
+			err:    "malformed stat for process 4242",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := fakeProc(t, tc.cgroup, tc.stat)
+			attestors := []workload.Attestor{workload.CgroupAttestor{ProcRoot: root}}
+
+			got, err := workload.Attest(context.Background(), attestors, workload.PeerCred{PID: fakePID})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("attestation failed: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("expected selectors %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// restartingAttestor replaces the process in its procfs with another one that has the same PID
+type restartingAttestor struct {
+	workload.CgroupAttestor
+}
+
+func (a restartingAttestor) Attest(context.Context, workload.PeerCred) ([]registry.Selector, error) {
+	stat := filepath.Join(a.ProcRoot, fmt.Sprint(fakePID), "stat")
+	return nil, os.WriteFile(stat, []byte(procStat("envoy", 200)), 0o644)
+}
+
+func TestAttestPIDReuse(t *testing.T) {
+	root := fakeProc(t, "0::/system.slice/envoy.service\n", procStat("envoy", 100))
+	attestors := []workload.Attestor{
+		workload.CgroupAttestor{ProcRoot: root},
+		restartingAttestor{workload.CgroupAttestor{ProcRoot: root}},
+	}
+
+	// The start time is read from the procfs of the attestors rather than from /proc
+	_, err := workload.Attest(context.Background(), attestors[:1], workload.PeerCred{PID: fakePID})
+	if err != nil {
+		t.Fatalf("attestation failed: %v", err)
+	}
+
+	_, err = workload.Attest(context.Background(), attestors, workload.PeerCred{PID: fakePID})
+	if err == nil || !strings.Contains(err.Error(), "exited during attestation") {
+		t.Errorf("expected the reused PID to be detected, got %v", err)
+	}
+}